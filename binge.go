@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"kino/internal/client"
+	"kino/stream"
+)
+
+type nextEpisodeResult struct {
+	// imdbID is empty when there is no next episode.
+	imdbID string
+	// variants is empty when the episode is in the library.
+	variants []stream.StreamVariant
+	err      error
+}
+
+// nextEpisodeFetch resolves the episode after the current one in the
+// background, so it is ready to play as soon as the current one ends.
+type nextEpisodeFetch struct {
+	done   chan struct{}
+	result nextEpisodeResult
+}
+
+func prefetchNextEpisode(imdbID string, season, episode int) *nextEpisodeFetch {
+	f := &nextEpisodeFetch{done: make(chan struct{})}
+
+	go func() {
+		defer close(f.done)
+
+		baseID := strings.Split(imdbID, "/")[0]
+		nextSeason, nextEp, ok, err := nextEpisode(client.New(), baseID, season, episode)
+		if err != nil || !ok {
+			f.result.err = err
+			return
+		}
+
+		nextID := fmt.Sprintf("%s/%d-%d", baseID, nextSeason, nextEp)
+		if _, isLocal := findLocalCopy(nextID, nextSeason, nextEp); isLocal {
+			f.result = nextEpisodeResult{imdbID: nextID}
+			return
+		}
+
+		variants, err := stream.GetStreamVariants(nextID, stream.TV, nextSeason, nextEp)
+		if err != nil {
+			f.result.err = err
+			return
+		}
+		f.result = nextEpisodeResult{imdbID: nextID, variants: variants}
+	}()
+
+	return f
+}
+
+func (f *nextEpisodeFetch) wait() nextEpisodeResult {
+	<-f.done
+	return f.result
+}
+
+// countdown announces the next episode and waits for d, returning false if
+// the user cancels with Ctrl+C.
+func countdown(label string, d time.Duration) bool {
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for remaining := int(d.Seconds()); remaining > 0; remaining-- {
+		fmt.Printf("\rPlaying %s in %ds... (Ctrl+C to cancel) ", label, remaining)
+		select {
+		case <-interrupt:
+			fmt.Println("\nAuto-play cancelled.")
+			return false
+		case <-ticker.C:
+		}
+	}
+	fmt.Println()
+	return true
+}
@@ -0,0 +1,217 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"kino/internal/xdg"
+)
+
+const fileName = "config.json"
+
+// Config holds user preferences read from $XDG_CONFIG_HOME/kino/config.json.
+// Fields missing from the file keep their defaults.
+type Config struct {
+	// FinishedThreshold is the fraction of a title that must be watched for
+	// it to count as finished.
+	FinishedThreshold float64 `json:"finished_threshold"`
+	// CreditsLength also marks a title finished once no more than this much
+	// of it remains. Zero disables the check.
+	CreditsLength Duration `json:"credits_length"`
+	// StripAds leaves out ad breaks providers splice into streams, when
+	// playing through the proxy and when downloading.
+	StripAds bool `json:"strip_ads"`
+
+	Binge BingeConfig `json:"binge"`
+
+	Quality QualityConfig `json:"quality"`
+
+	Download DownloadConfig `json:"download"`
+
+	Library LibraryConfig `json:"library"`
+
+	Proxy ProxyConfig `json:"proxy"`
+
+	Player PlayerConfig `json:"player"`
+
+	Subtitles SubtitlesConfig `json:"subtitles"`
+}
+
+// SubtitlesConfig controls which subtitle tracks are loaded.
+type SubtitlesConfig struct {
+	// Languages are the subtitle languages to load, in order of preference,
+	// e.g. ["en", "de"]. Empty loads none.
+	Languages []string `json:"languages"`
+	// OpenSubtitles is searched for languages the stream has no subtitles
+	// in.
+	OpenSubtitles OpenSubtitlesConfig `json:"opensubtitles"`
+}
+
+// OpenSubtitlesConfig configures the OpenSubtitles-compatible subtitle
+// search. It is used once an API key or URL is set.
+type OpenSubtitlesConfig struct {
+	// URL is the API's base URL. Empty uses api.opensubtitles.com.
+	URL    string `json:"url"`
+	APIKey string `json:"api_key"`
+}
+
+// PlayerConfig chooses the media player.
+type PlayerConfig struct {
+	// Backend is "mpv", "vlc", "ffplay" or "command". Empty uses the first
+	// of mpv, vlc and ffplay that is installed.
+	Backend string `json:"backend"`
+	// Command is run by the "command" backend, e.g.
+	// "myplayer --title {title} {url}".
+	Command string `json:"command"`
+}
+
+// ProxyConfig controls the local HLS proxy playback goes through.
+type ProxyConfig struct {
+	// Enabled plays streams through the proxy rather than handing the
+	// player upstream URLs.
+	Enabled bool `json:"enabled"`
+	// Addr is where the proxy started for playback listens.
+	Addr string `json:"addr"`
+}
+
+// LibraryConfig controls the offline library of downloads.
+type LibraryConfig struct {
+	// MaxSize caps the library, e.g. "200GiB". Once it is exceeded, watched
+	// downloads that are not pinned are deleted, least recently watched
+	// first. Empty means no cap.
+	MaxSize string `json:"max_size"`
+}
+
+// DownloadConfig holds defaults for kino download.
+type DownloadConfig struct {
+	// Dir is where downloads are written unless an output path is given.
+	Dir string `json:"dir"`
+	// Format is "mp4" (remuxed with ffmpeg) or "ts".
+	Format string `json:"format"`
+	// Concurrency is how many segments are fetched at once per download.
+	Concurrency int `json:"concurrency"`
+	// Jobs is how many queued downloads run at once.
+	Jobs int `json:"jobs"`
+	// LimitRate caps download speed, e.g. "2MiB/s". Empty means unlimited.
+	LimitRate string `json:"limit_rate"`
+	// MovieTemplate and EpisodeTemplate name downloads relative to Dir; see
+	// metadata.Expand for the placeholders. The extension comes from Format.
+	MovieTemplate   string `json:"movie_template"`
+	EpisodeTemplate string `json:"episode_template"`
+	// NFO writes Kodi-style .nfo files next to downloads.
+	NFO bool `json:"nfo"`
+}
+
+// QualityConfig controls which stream variant is played.
+type QualityConfig struct {
+	// Default is played without asking when available: a resolution such
+	// as "720p", "best", "worst", "<=1080p", or "auto" for adaptive
+	// playback. Empty means always ask.
+	Default string `json:"default"`
+	// MaxResolution caps automatic choices, e.g. "1080p".
+	MaxResolution string `json:"max_resolution"`
+	// MaxBandwidth caps automatic choices, in bits per second.
+	MaxBandwidth int `json:"max_bandwidth"`
+	// AvoidCodecs lists codecs automatic choices skip, e.g. ["hevc"].
+	AvoidCodecs []string `json:"avoid_codecs"`
+	// Probe measures the connection before "best", "auto" and "<=" choices
+	// and keeps them to variants it can carry.
+	Probe bool `json:"probe"`
+}
+
+// BingeConfig controls playing the next episode automatically when one ends.
+type BingeConfig struct {
+	Enabled bool `json:"enabled"`
+	// MaxEpisodes is how many episodes play back to back, including the first.
+	MaxEpisodes int `json:"max_episodes"`
+	// Countdown is how long to wait, cancelably, before the next episode.
+	Countdown Duration `json:"countdown"`
+}
+
+// Default returns the configuration used when no config file exists.
+func Default() *Config {
+	return &Config{
+		FinishedThreshold: 0.9,
+		StripAds:          true,
+		Binge: BingeConfig{
+			Enabled:     true,
+			MaxEpisodes: 5,
+			Countdown:   Duration(10 * time.Second),
+		},
+		Download: DownloadConfig{
+			Dir:             ".",
+			Format:          "mp4",
+			Concurrency:     4,
+			Jobs:            2,
+			MovieTemplate:   "{title} ({year})",
+			EpisodeTemplate: "{show} - S{season:02}E{episode:02}",
+		},
+		Quality: QualityConfig{
+			Probe: true,
+		},
+		Proxy: ProxyConfig{
+			Enabled: true,
+			Addr:    "127.0.0.1:0",
+		},
+	}
+}
+
+// Load reads the config file, falling back to defaults if it does not exist.
+func Load() (*Config, error) {
+	cfg := Default()
+
+	dir, err := xdg.ConfigDir()
+	if err != nil {
+		return cfg, err
+	}
+	path := filepath.Join(dir, fileName)
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, fmt.Errorf("reading %s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return Default(), fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// IsFinished reports whether position counts as having finished a title of
+// the given duration.
+func (c *Config) IsFinished(position, duration time.Duration) bool {
+	if duration <= 0 {
+		return false
+	}
+	if float64(position) >= c.FinishedThreshold*float64(duration) {
+		return true
+	}
+	return c.CreditsLength > 0 && duration-position <= time.Duration(c.CreditsLength)
+}
+
+// Duration is a time.Duration written as a string such as "90s" or "2m" in the config file.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"90s\": %w", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"kino/history"
+	"kino/ui"
+)
+
+// maxContinueItems caps how many titles are offered, and how many finished
+// shows are looked up, since finding the next episode of each costs IMDb
+// requests.
+const maxContinueItems = 10
+
+// runContinue lets the user pick an in-progress title or the next episode of
+// a show from the watch history and plays it at the quality used last time.
+func runContinue(client *http.Client) error {
+	items, err := continueItems(client)
+	if err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		return fmt.Errorf("nothing to continue watching")
+	}
+
+	selected, err := ui.SelectContinue(items)
+	if err != nil {
+		return err
+	}
+
+	imdbID := selected.IMDbID
+	if selected.Season > 0 && selected.Episode > 0 {
+		imdbID = fmt.Sprintf("%s/%d-%d", selected.IMDbID, selected.Season, selected.Episode)
+	}
+
+	fmt.Printf("\nSelected IMDb ID: %s\n\n", imdbID)
+	return handleStreamingSelection(imdbID, selected.Variant)
+}
+
+// hasContinueItems reports whether the watch history has anything that could
+// be continued. It only reads the history, so it is cheap enough to check
+// before every prompt; whether a finished show has a next episode is left to
+// continueItems.
+func hasContinueItems() bool {
+	store, err := history.Open()
+	if err != nil {
+		return false
+	}
+	latest, err := store.Latest()
+	if err != nil {
+		return false
+	}
+	for _, e := range latest {
+		if !e.Finished || e.Season > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// continueItems returns unfinished titles as they are, and replaces finished
+// episodes with the show's next episode. Finished movies and shows are dropped.
+func continueItems(client *http.Client) ([]history.Entry, error) {
+	store, err := history.Open()
+	if err != nil {
+		return nil, err
+	}
+	latest, err := store.Latest()
+	if err != nil {
+		return nil, err
+	}
+
+	var items []history.Entry
+	lookups := 0
+	for _, e := range latest {
+		if len(items) == maxContinueItems || lookups == maxContinueItems {
+			break
+		}
+
+		if !e.Finished {
+			items = append(items, e)
+			continue
+		}
+		if e.Season == 0 {
+			continue
+		}
+
+		lookups++
+		season, episode, ok, err := nextEpisode(client, e.IMDbID, e.Season, e.Episode)
+		if err != nil {
+			log.Printf("Warning: Could not find next episode of %s: %v", e.Title, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		items = append(items, history.Entry{
+			IMDbID:  e.IMDbID,
+			Title:   e.Title,
+			Season:  season,
+			Episode: episode,
+			Variant: e.Variant,
+		})
+	}
+
+	return items, nil
+}
@@ -0,0 +1,274 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"kino/hls"
+)
+
+// keyCache fetches each encryption key once, sending the headers the key
+// server expects.
+type keyCache struct {
+	client  *http.Client
+	header  http.Header
+	retries int
+
+	mu   sync.Mutex
+	keys map[string][]byte
+}
+
+func newKeyCache(client *http.Client, header http.Header, retries int) *keyCache {
+	return &keyCache{client: client, header: header, retries: retries, keys: make(map[string][]byte)}
+}
+
+func (c *keyCache) get(ctx context.Context, uri string) ([]byte, error) {
+	// Holding the lock while fetching keeps concurrent segments from
+	// requesting the same key; playlists rarely have more than a few keys.
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if key, ok := c.keys[uri]; ok {
+		return key, nil
+	}
+
+	var key []byte
+	err := withRetries(ctx, c.retries, func() error {
+		req, err := http.NewRequestWithContext(ctx, "GET", uri, nil)
+		if err != nil {
+			return fmt.Errorf("creating request for %q: %w", uri, err)
+		}
+		setHeader(req, c.header)
+
+		resp, err := c.client.Do(req)
+		if err != nil {
+			return fmt.Errorf("fetching key %q: %w", uri, err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("unexpected status %d for key %q", resp.StatusCode, uri)
+		}
+		key, err = io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != aes.BlockSize {
+		return nil, fmt.Errorf("key %q is %d bytes, want %d", uri, len(key), aes.BlockSize)
+	}
+
+	c.keys[uri] = key
+	return key, nil
+}
+
+// decryptSegment wraps body to decrypt an AES-128 segment. SAMPLE-AES
+// segments are returned as they are; ffmpeg decrypts them when assembling.
+func (c *keyCache) decryptSegment(ctx context.Context, seg hls.Segment, body io.Reader) (io.Reader, error) {
+	if seg.Key == nil || seg.Key.Method == hls.MethodSampleAES {
+		return body, nil
+	}
+	if seg.Key.Method != hls.MethodAES128 {
+		return nil, fmt.Errorf("unsupported encryption method %q", seg.Key.Method)
+	}
+
+	key, err := c.get(ctx, seg.Key.URI)
+	if err != nil {
+		return nil, err
+	}
+	iv := seg.Key.IV
+	if iv == nil {
+		iv = hls.SequenceIV(seg.Sequence)
+	}
+	return newCBCReader(body, key, iv)
+}
+
+// cbcReader decrypts AES-CBC data as it is read and strips the PKCS#7 padding
+// from the final block, so segments never have to be held in memory whole.
+type cbcReader struct {
+	src  io.Reader
+	mode cipher.BlockMode
+
+	in  []byte // ciphertext not yet decrypted
+	out []byte // plaintext ready to be returned
+	// last is the most recent decrypted block, held back until we know
+	// whether it carries the padding.
+	last []byte
+	eof  bool
+}
+
+func newCBCReader(src io.Reader, key, iv []byte) (*cbcReader, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return &cbcReader{src: src, mode: cipher.NewCBCDecrypter(block, iv)}, nil
+}
+
+func (r *cbcReader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.eof {
+			return 0, io.EOF
+		}
+		if err := r.fill(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+func (r *cbcReader) fill() error {
+	buf := make([]byte, 32*1024)
+	n, err := r.src.Read(buf)
+	r.in = append(r.in, buf[:n]...)
+
+	if err == io.EOF {
+		r.eof = true
+		if len(r.in)%aes.BlockSize != 0 {
+			return fmt.Errorf("encrypted segment is not a multiple of the block size")
+		}
+	} else if err != nil {
+		return err
+	}
+
+	full := len(r.in) / aes.BlockSize * aes.BlockSize
+	if full > 0 {
+		plain := make([]byte, full)
+		r.mode.CryptBlocks(plain, r.in[:full])
+		r.in = r.in[full:]
+
+		r.out = append(r.out, r.last...)
+		r.out = append(r.out, plain[:full-aes.BlockSize]...)
+		r.last = plain[full-aes.BlockSize:]
+	}
+
+	if r.eof {
+		unpadded, err := unpad(r.last)
+		if err != nil {
+			return err
+		}
+		r.out = append(r.out, unpadded...)
+		r.last = nil
+	}
+	return nil
+}
+
+func unpad(block []byte) ([]byte, error) {
+	if len(block) == 0 {
+		return block, nil
+	}
+	n := int(block[len(block)-1])
+	if n == 0 || n > aes.BlockSize || n > len(block) ||
+		!bytes.Equal(block[len(block)-n:], bytes.Repeat([]byte{byte(n)}, n)) {
+		return nil, fmt.Errorf("invalid padding in decrypted segment; wrong key?")
+	}
+	return block[:len(block)-n], nil
+}
+
+func hasSampleAES(segments []hls.Segment) bool {
+	for _, seg := range segments {
+		if seg.Key != nil && seg.Key.Method == hls.MethodSampleAES {
+			return true
+		}
+	}
+	return false
+}
+
+// assembleSampleAES hands the still-encrypted segments to ffmpeg through a
+// local playlist. SAMPLE-AES encrypts individual audio and video samples
+// inside the transport stream, which ffmpeg's HLS demuxer knows how to undo.
+func assembleSampleAES(ctx context.Context, dir string, segments []hls.Segment, keys *keyCache, output string) error {
+	ffmpeg, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return fmt.Errorf("ffmpeg is needed to decrypt SAMPLE-AES streams: %w", err)
+	}
+
+	text, err := localPlaylist(ctx, dir, segments, keys)
+	if err != nil {
+		return err
+	}
+	playlist := filepath.Join(dir, "local.m3u8")
+	if err := os.WriteFile(playlist, []byte(text), 0644); err != nil {
+		return fmt.Errorf("writing %s: %w", playlist, err)
+	}
+
+	args := []string{"-allowed_extensions", "ALL", "-i", playlist, "-c", "copy"}
+	if strings.EqualFold(filepath.Ext(output), ".mp4") {
+		args = append(args, "-bsf:a", "aac_adtstoasc")
+	}
+	return runFFmpeg(ffmpeg, output, args...)
+}
+
+// localPlaylist lists the segments saved in dir, with their keys saved
+// next to them. Segments left out as ads leave gaps in the sequence numbers
+// ffmpeg would derive IVs from, so a key without an IV is written again
+// before every segment with the IV spelt out.
+func localPlaylist(ctx context.Context, dir string, segments []hls.Segment, keys *keyCache) (string, error) {
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:5\n")
+	fmt.Fprintf(&b, "#EXT-X-MEDIA-SEQUENCE:%d\n", segments[0].Sequence)
+
+	keyFiles := make(map[string]string)
+	var current *hls.Key
+	for i, seg := range segments {
+		if seg.Key != current || (seg.Key != nil && seg.Key.Method == hls.MethodSampleAES && seg.Key.IV == nil) {
+			current = seg.Key
+			if err := writeLocalKey(ctx, &b, dir, seg, keys, keyFiles); err != nil {
+				return "", err
+			}
+		}
+		fmt.Fprintf(&b, "#EXTINF:%.3f,\n%s\n", seg.Duration.Seconds(), filepath.Base(segmentPath(dir, i)))
+	}
+	b.WriteString("#EXT-X-ENDLIST\n")
+	return b.String(), nil
+}
+
+// writeLocalKey saves the SAMPLE-AES key of seg next to the segments and
+// writes an EXT-X-KEY tag pointing at the local copy.
+func writeLocalKey(ctx context.Context, b *strings.Builder, dir string, seg hls.Segment, keys *keyCache, keyFiles map[string]string) error {
+	key := seg.Key
+	if key == nil || key.Method == hls.MethodAES128 {
+		// AES-128 segments were decrypted as they were fetched.
+		b.WriteString("#EXT-X-KEY:METHOD=NONE\n")
+		return nil
+	}
+
+	name, ok := keyFiles[key.URI]
+	if !ok {
+		data, err := keys.get(ctx, key.URI)
+		if err != nil {
+			return err
+		}
+		name = fmt.Sprintf("key%d.bin", len(keyFiles))
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+			return fmt.Errorf("writing key: %w", err)
+		}
+		keyFiles[key.URI] = name
+	}
+
+	iv := key.IV
+	if iv == nil {
+		iv = hls.SequenceIV(seg.Sequence)
+	}
+	fmt.Fprintf(b, "#EXT-X-KEY:METHOD=%s,URI=\"%s\",IV=0x%s", key.Method, name, hex.EncodeToString(iv))
+	if key.KeyFormat != "" {
+		fmt.Fprintf(b, ",KEYFORMAT=\"%s\"", key.KeyFormat)
+	}
+	b.WriteString("\n")
+	return nil
+}
@@ -0,0 +1,178 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"kino/hls"
+)
+
+var (
+	testKey = []byte("0123456789abcdef")
+	testIV  = []byte("fedcba9876543210")
+)
+
+// encryptSegment encrypts plain with AES-128-CBC and PKCS#7 padding, as an
+// HLS packager does.
+func encryptSegment(t *testing.T, plain, key, iv []byte) []byte {
+	t.Helper()
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := aes.BlockSize - len(plain)%aes.BlockSize
+	padded := append(append([]byte(nil), plain...), bytes.Repeat([]byte{byte(n)}, n)...)
+	out := make([]byte, len(padded))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(out, padded)
+	return out
+}
+
+func TestDownloadAES128(t *testing.T) {
+	const firstSequence = 7
+	segments := [][]byte{
+		bytes.Repeat([]byte("first segment "), 1000),
+		[]byte("a segment whose length is not a multiple of the block size"),
+		bytes.Repeat([]byte{0x47}, 188*3),
+	}
+
+	tests := []struct {
+		name string
+		// iv is the playlist's IV attribute; nil derives it from the media
+		// sequence number.
+		iv []byte
+	}{
+		{"explicit IV", testIV},
+		{"IV from sequence number", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var playlist strings.Builder
+			fmt.Fprintf(&playlist, "#EXTM3U\n#EXT-X-TARGETDURATION:4\n#EXT-X-MEDIA-SEQUENCE:%d\n", firstSequence)
+			playlist.WriteString(`#EXT-X-KEY:METHOD=AES-128,URI="key.bin"`)
+			if tt.iv != nil {
+				fmt.Fprintf(&playlist, ",IV=0x%s", hex.EncodeToString(tt.iv))
+			}
+			playlist.WriteString("\n")
+
+			mux := http.NewServeMux()
+			for i, plain := range segments {
+				iv := tt.iv
+				if iv == nil {
+					iv = hls.SequenceIV(firstSequence + i)
+				}
+				data := encryptSegment(t, plain, testKey, iv)
+				name := fmt.Sprintf("/seg%d.ts", i)
+				mux.HandleFunc(name, func(w http.ResponseWriter, r *http.Request) { w.Write(data) })
+				fmt.Fprintf(&playlist, "#EXTINF:4.0,\n%s\n", name[1:])
+			}
+			playlist.WriteString("#EXT-X-ENDLIST\n")
+			mux.HandleFunc("/index.m3u8", func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(playlist.String()))
+			})
+			mux.HandleFunc("/key.bin", func(w http.ResponseWriter, r *http.Request) { w.Write(testKey) })
+			srv := httptest.NewServer(mux)
+			defer srv.Close()
+
+			output := filepath.Join(t.TempDir(), "out.ts")
+			if err := Download(context.Background(), srv.URL+"/index.m3u8", output, Options{Client: srv.Client()}); err != nil {
+				t.Fatal(err)
+			}
+			got, err := os.ReadFile(output)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := bytes.Join(segments, nil); !bytes.Equal(got, want) {
+				t.Errorf("output is %d bytes that differ from the %d byte plaintext", len(got), len(want))
+			}
+		})
+	}
+}
+
+func TestLocalPlaylistAfterAds(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write(testKey) }))
+	defer srv.Close()
+
+	key := &hls.Key{Method: hls.MethodSampleAES, URI: srv.URL + "/key"}
+	// Sequence numbers 11 and 12 were an ad break.
+	segments := []hls.Segment{
+		{Sequence: 10, Duration: 4e9, Key: key},
+		{Sequence: 13, Duration: 4e9, Key: key},
+		{Sequence: 14, Duration: 4e9, Key: key},
+	}
+	dir := t.TempDir()
+	got, err := localPlaylist(context.Background(), dir, segments, newKeyCache(srv.Client(), nil, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `#EXTM3U
+#EXT-X-VERSION:5
+#EXT-X-MEDIA-SEQUENCE:10
+#EXT-X-KEY:METHOD=SAMPLE-AES,URI="key0.bin",IV=0x0000000000000000000000000000000a
+#EXTINF:4.000,
+000000.ts
+#EXT-X-KEY:METHOD=SAMPLE-AES,URI="key0.bin",IV=0x0000000000000000000000000000000d
+#EXTINF:4.000,
+000001.ts
+#EXT-X-KEY:METHOD=SAMPLE-AES,URI="key0.bin",IV=0x0000000000000000000000000000000e
+#EXTINF:4.000,
+000002.ts
+#EXT-X-ENDLIST
+`
+	if got != want {
+		t.Errorf("local playlist:\n%s\nwant:\n%s", got, want)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "key0.bin")); err != nil || !bytes.Equal(data, testKey) {
+		t.Errorf("saved key = %x, %v", data, err)
+	}
+}
+
+func TestLocalPlaylistMixedMethods(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write(testKey) }))
+	defer srv.Close()
+
+	aes128 := &hls.Key{Method: hls.MethodAES128, URI: srv.URL + "/aes"}
+	sample := &hls.Key{Method: hls.MethodSampleAES, URI: srv.URL + "/sample", IV: testIV}
+	segments := []hls.Segment{
+		{Sequence: 0, Duration: 4e9, Key: aes128},
+		{Sequence: 1, Duration: 4e9, Key: aes128},
+		{Sequence: 2, Duration: 4e9, Key: sample},
+		{Sequence: 3, Duration: 4e9},
+	}
+	got, err := localPlaylist(context.Background(), t.TempDir(), segments, newKeyCache(srv.Client(), nil, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The AES-128 segments were decrypted on download, so ffmpeg must not
+	// decrypt them again.
+	want := `#EXTM3U
+#EXT-X-VERSION:5
+#EXT-X-MEDIA-SEQUENCE:0
+#EXT-X-KEY:METHOD=NONE
+#EXTINF:4.000,
+000000.ts
+#EXTINF:4.000,
+000001.ts
+#EXT-X-KEY:METHOD=SAMPLE-AES,URI="key0.bin",IV=0x66656463626139383736353433323130
+#EXTINF:4.000,
+000002.ts
+#EXT-X-KEY:METHOD=NONE
+#EXTINF:4.000,
+000003.ts
+#EXT-X-ENDLIST
+`
+	if got != want {
+		t.Errorf("local playlist:\n%s\nwant:\n%s", got, want)
+	}
+}
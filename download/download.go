@@ -0,0 +1,454 @@
+package download
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"kino/hls"
+	httpclient "kino/internal/client"
+)
+
+const (
+	defaultConcurrency = 4
+	defaultRetries     = 3
+	defaultHTTPTimeout = 30 * time.Second
+	retryBackoff       = time.Second
+	partsSuffix        = ".parts"
+	manifestFileName   = "manifest.json"
+)
+
+// Options controls how a playlist is downloaded.
+type Options struct {
+	// Concurrency is how many segments are fetched at once.
+	Concurrency int
+	// Retries is how many times a failed request is retried.
+	Retries int
+	Client  *http.Client
+	// Header is sent with every playlist, key and segment request, e.g.
+	// the Referer and User-Agent the stream host expects.
+	Header http.Header
+	// Limiter, if set, caps the throughput of all segment fetches sharing it.
+	Limiter *Limiter
+	// Bandwidth is the variant's BANDWIDTH in bits per second, used to
+	// estimate the disk space a download needs. Zero means unknown.
+	Bandwidth int64
+	// Progress, if set, is called once before fetching and after each
+	// segment completes.
+	Progress func(Progress)
+	// StripAds leaves out segments hls.DetectAds takes for ads.
+	StripAds bool
+	// Debugf, if set, receives the ad breaks found.
+	Debugf func(format string, v ...any)
+}
+
+// Progress describes how far a download has got.
+type Progress struct {
+	Segments      int
+	TotalSegments int
+	// Bytes counts what was fetched in this run, not segments kept from an
+	// interrupted one.
+	Bytes int64
+	// Media and TotalMedia are the EXTINF durations of the completed and all
+	// segments.
+	Media      time.Duration
+	TotalMedia time.Duration
+}
+
+func (o *Options) setDefaults() {
+	if o.Concurrency <= 0 {
+		o.Concurrency = defaultConcurrency
+	}
+	if o.Retries < 0 {
+		o.Retries = 0
+	} else if o.Retries == 0 {
+		o.Retries = defaultRetries
+	}
+	if o.Client == nil {
+		o.Client = httpclient.NewMedia(defaultHTTPTimeout)
+	}
+}
+
+func (o *Options) debugf(format string, v ...any) {
+	if o.Debugf != nil {
+		o.Debugf(format, v...)
+	}
+}
+
+// manifest identifies the playlist a parts directory belongs to, so an
+// interrupted download is only resumed against the same segment layout.
+type manifest struct {
+	Segments int           `json:"segments"`
+	Duration time.Duration `json:"duration"`
+}
+
+// Download fetches the HLS media playlist at playlistURL and writes its
+// segments into a single file at output. Segments are kept in a directory
+// next to output until the download completes, so running it again after an
+// interruption only fetches what is missing, even if the playlist URL has
+// changed in the meantime. Outputs ending in .mp4 are remuxed with ffmpeg.
+func Download(ctx context.Context, playlistURL, output string, opts Options) error {
+	opts.setDefaults()
+
+	playlist, err := fetchPlaylist(ctx, playlistURL, opts)
+	if err != nil {
+		return err
+	}
+	if len(playlist.Segments) == 0 {
+		return fmt.Errorf("playlist %q has no segments", playlistURL)
+	}
+	if opts.StripAds {
+		breaks := hls.DetectAds(playlist)
+		for _, b := range breaks {
+			opts.debugf("Skipping %s", b)
+		}
+		playlist = playlist.WithoutAds(breaks)
+	}
+
+	partsDir := PartsDir(output)
+	if err := preparePartsDir(partsDir, manifest{
+		Segments: len(playlist.Segments),
+		Duration: playlist.TotalDuration(),
+	}); err != nil {
+		return err
+	}
+	if err := checkFreeSpace(output, playlist, opts.Bandwidth); err != nil {
+		return err
+	}
+
+	keys := newKeyCache(opts.Client, opts.Header, opts.Retries)
+	if err := fetchSegments(ctx, playlist.Segments, partsDir, keys, opts); err != nil {
+		return err
+	}
+
+	if hasSampleAES(playlist.Segments) {
+		err = assembleSampleAES(ctx, partsDir, playlist.Segments, keys, output)
+	} else {
+		err = assemble(partsDir, len(playlist.Segments), output)
+	}
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(partsDir)
+}
+
+func fetchPlaylist(ctx context.Context, playlistURL string, opts Options) (*hls.MediaPlaylist, error) {
+	var playlist *hls.MediaPlaylist
+	err := withRetries(ctx, opts.Retries, func() error {
+		body, err := get(ctx, opts.Client, playlistURL, opts.Header)
+		if err != nil {
+			return err
+		}
+		defer body.Close()
+
+		playlist, err = hls.ParseMedia(body, playlistURL)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("fetching media playlist: %w", err)
+	}
+	return playlist, nil
+}
+
+// PartsDir returns the directory segments of output are kept in until the
+// download completes.
+func PartsDir(output string) string {
+	return output + partsSuffix
+}
+
+func preparePartsDir(dir string, want manifest) error {
+	path := filepath.Join(dir, manifestFileName)
+
+	data, err := os.ReadFile(path)
+	if err == nil {
+		var have manifest
+		if json.Unmarshal(data, &have) == nil && have == want {
+			return nil
+		}
+		// The playlist no longer matches what was downloaded; start over.
+		if err := os.RemoveAll(dir); err != nil {
+			return fmt.Errorf("removing stale parts in %s: %w", dir, err)
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("creating %s: %w", dir, err)
+	}
+	data, err = json.Marshal(want)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+func segmentPath(dir string, index int) string {
+	return filepath.Join(dir, fmt.Sprintf("%06d.ts", index))
+}
+
+// fetchSegments downloads every segment that is not already in dir. The
+// first failure cancels the remaining fetches.
+func fetchSegments(ctx context.Context, segments []hls.Segment, dir string, keys *keyCache, opts Options) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	progress := Progress{TotalSegments: len(segments)}
+	var pending []int
+	for i, seg := range segments {
+		progress.TotalMedia += seg.Duration
+		if _, err := os.Stat(segmentPath(dir, i)); err != nil {
+			pending = append(pending, i)
+		} else {
+			progress.Segments++
+			progress.Media += seg.Duration
+		}
+	}
+	if opts.Progress != nil {
+		opts.Progress(progress)
+	}
+
+	var fetched atomic.Int64
+
+	jobs := make(chan int)
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+
+	for w := 0; w < opts.Concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				err := withRetries(ctx, opts.Retries, func() error {
+					return fetchSegment(ctx, opts, keys, segments[i], segmentPath(dir, i), &fetched)
+				})
+
+				mu.Lock()
+				if err != nil {
+					if firstErr == nil {
+						firstErr = fmt.Errorf("segment %d: %w", i, err)
+					}
+					cancel()
+				} else {
+					progress.Segments++
+					progress.Media += segments[i].Duration
+					progress.Bytes = fetched.Load()
+					if opts.Progress != nil {
+						opts.Progress(progress)
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+feed:
+	for _, i := range pending {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+func fetchSegment(ctx context.Context, opts Options, keys *keyCache, seg hls.Segment, path string, fetched *atomic.Int64) error {
+	body, err := get(ctx, opts.Client, seg.URI, opts.Header)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	var r io.Reader = &countingReader{r: body, n: fetched}
+	if opts.Limiter != nil {
+		r = &limitedReader{ctx: ctx, r: r, limiter: opts.Limiter}
+	}
+
+	plain, err := keys.decryptSegment(ctx, seg, r)
+	if err != nil {
+		return err
+	}
+	return writeAtomic(path, plain)
+}
+
+type countingReader struct {
+	r io.Reader
+	n *atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// writeAtomic copies r to a temporary file renamed to path once complete, so a
+// segment file only exists when it is whole.
+func writeAtomic(path string, r io.Reader) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("creating %s: %w", tmp, err)
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("writing %s: %w", tmp, err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("closing %s: %w", tmp, err)
+	}
+	return os.Rename(tmp, path)
+}
+
+func get(ctx context.Context, client *http.Client, url string, header http.Header) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request for %q: %w", url, err)
+	}
+	setHeader(req, header)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching %q: %w", url, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status %d for %q", resp.StatusCode, url)
+	}
+	return resp.Body, nil
+}
+
+// setHeader adds header to req, replacing any defaults.
+func setHeader(req *http.Request, header http.Header) {
+	for k, v := range header {
+		req.Header[k] = v
+	}
+}
+
+// withRetries calls fn until it succeeds, backing off between attempts.
+func withRetries(ctx context.Context, retries int, fn func() error) error {
+	var err error
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(retryBackoff * time.Duration(attempt)):
+			}
+		}
+		if err = fn(); err == nil || ctx.Err() != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// assemble concatenates the segments into output, remuxing to MP4 with
+// ffmpeg when output asks for it.
+func assemble(dir string, count int, output string) error {
+	remux := strings.EqualFold(filepath.Ext(output), ".mp4")
+
+	joined := output
+	if remux {
+		joined = filepath.Join(dir, "joined.ts")
+	}
+
+	if err := concat(dir, count, joined); err != nil {
+		return err
+	}
+	if !remux {
+		return nil
+	}
+	return remuxMP4(joined, output)
+}
+
+func concat(dir string, count int, output string) error {
+	tmp := output + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("creating %s: %w", tmp, err)
+	}
+
+	for i := 0; i < count; i++ {
+		if err := appendFile(out, segmentPath(dir, i)); err != nil {
+			out.Close()
+			os.Remove(tmp)
+			return err
+		}
+	}
+
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("closing %s: %w", tmp, err)
+	}
+	return os.Rename(tmp, output)
+}
+
+func appendFile(dst io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("opening segment: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(dst, f); err != nil {
+		return fmt.Errorf("copying %s: %w", path, err)
+	}
+	return nil
+}
+
+// CanRemux reports whether ffmpeg is available for writing MP4 files.
+func CanRemux() bool {
+	_, err := exec.LookPath("ffmpeg")
+	return err == nil
+}
+
+func remuxMP4(input, output string) error {
+	ffmpeg, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return fmt.Errorf("ffmpeg is needed to write %s (choose a .ts output instead): %w", output, err)
+	}
+	return runFFmpeg(ffmpeg, output, "-i", input, "-c", "copy", "-bsf:a", "aac_adtstoasc")
+}
+
+// runFFmpeg runs ffmpeg with args and writes to output under a temporary
+// name, so a failed run never looks finished.
+func runFFmpeg(ffmpeg, output string, args ...string) error {
+	ext := filepath.Ext(output)
+	tmp := strings.TrimSuffix(output, ext) + ".tmp" + ext
+
+	args = append([]string{"-hide_banner", "-loglevel", "error", "-y"}, args...)
+	if strings.EqualFold(ext, ".ts") {
+		args = append(args, "-f", "mpegts")
+	}
+	cmd := exec.Command(ffmpeg, append(args, tmp)...)
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("running ffmpeg: %w", err)
+	}
+	return os.Rename(tmp, output)
+}
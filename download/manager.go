@@ -0,0 +1,223 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"time"
+
+	"kino/internal/filelock"
+	"kino/subtitles"
+)
+
+const (
+	defaultJobs          = 2
+	pollInterval         = time.Second
+	progressSaveInterval = 2 * time.Second
+)
+
+// ErrWorkerRunning is returned by Run when another process already runs the queue.
+var ErrWorkerRunning = errors.New("another download worker is running")
+
+// Manager works through the download queue, running a limited number of jobs
+// at once. It watches the queue for jobs paused or canceled by other
+// processes and stops them.
+type Manager struct {
+	Queue *Queue
+	// Jobs is how many downloads run at once.
+	Jobs int
+	// Options apply to each job; Concurrency is the per-job segment parallelism.
+	Options Options
+	// Resolve returns the media playlist to download for a job.
+	Resolve func(ctx context.Context, job Job) (Source, error)
+	// OnDone, if set, is called with each job that completes.
+	OnDone func(Job)
+	// Logf, if set, receives job state changes.
+	Logf func(format string, v ...any)
+}
+
+// Source is a resolved media playlist for a job.
+type Source struct {
+	URL string
+	// Variant is the resolution of the variant the playlist belongs to.
+	Variant string
+	// Bandwidth is the variant's BANDWIDTH in bits per second, or 0.
+	Bandwidth int64
+	// Header is sent with the job's requests, overriding Options.Header.
+	Header http.Header
+	// Subtitles, if set, returns the subtitles to add to the output once the
+	// download completes.
+	Subtitles func(output string) []subtitles.File
+}
+
+type jobResult struct {
+	job Job
+	err error
+}
+
+// Run processes jobs until the queue is empty, or until ctx is canceled, in
+// which case running jobs are stopped and left queued for the next worker.
+func (m *Manager) Run(ctx context.Context) error {
+	unlock, ok, err := filelock.TryLock(m.Queue.workerLockPath())
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrWorkerRunning
+	}
+	defer unlock()
+
+	// Only one worker runs, so anything still marked running was interrupted.
+	if err := m.Queue.requeueRunning(); err != nil {
+		return err
+	}
+
+	limit := m.Jobs
+	if limit <= 0 {
+		limit = defaultJobs
+	}
+
+	running := make(map[int]context.CancelFunc)
+	results := make(chan jobResult)
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	done := ctx.Done()
+
+	for {
+		if err := m.stopUnwanted(running); err != nil {
+			m.logf("Warning: %v", err)
+		}
+
+		if free := limit - len(running); free > 0 && ctx.Err() == nil {
+			claimed, err := m.Queue.claim(free)
+			if err != nil {
+				m.logf("Warning: %v", err)
+			}
+			for _, job := range claimed {
+				jobCtx, cancel := context.WithCancel(ctx)
+				running[job.ID] = cancel
+				m.logf("Starting job %d: %s", job.ID, job.DisplayTitle())
+				go func(job Job) {
+					results <- jobResult{job: job, err: m.runJob(jobCtx, job)}
+				}(job)
+			}
+		}
+
+		if len(running) == 0 {
+			return ctx.Err()
+		}
+
+		select {
+		case r := <-results:
+			running[r.job.ID]()
+			delete(running, r.job.ID)
+			m.finish(ctx, r)
+		case <-ticker.C:
+		case <-done:
+			done = nil
+		}
+	}
+}
+
+// stopUnwanted cancels running jobs whose state was changed in the queue.
+func (m *Manager) stopUnwanted(running map[int]context.CancelFunc) error {
+	if len(running) == 0 {
+		return nil
+	}
+	jobs, err := m.Queue.List()
+	if err != nil {
+		return err
+	}
+	for _, job := range jobs {
+		if cancel, ok := running[job.ID]; ok && job.State != StateRunning {
+			cancel()
+		}
+	}
+	return nil
+}
+
+func (m *Manager) runJob(ctx context.Context, job Job) error {
+	src, err := m.Resolve(ctx, job)
+	if err != nil {
+		return err
+	}
+	m.Queue.Update(job.ID, func(j *Job) error {
+		j.Variant = src.Variant
+		return nil
+	})
+
+	opts := m.Options
+	opts.Bandwidth = src.Bandwidth
+	if src.Header != nil {
+		opts.Header = src.Header
+	}
+	var lastSave time.Time
+	opts.Progress = func(p Progress) {
+		if time.Since(lastSave) < progressSaveInterval {
+			return
+		}
+		lastSave = time.Now()
+		m.Queue.Update(job.ID, func(j *Job) error {
+			j.Done, j.Total = p.Segments, p.TotalSegments
+			return nil
+		})
+	}
+
+	if err := Download(ctx, src.URL, job.Output, opts); err != nil {
+		return err
+	}
+	if src.Subtitles == nil {
+		return nil
+	}
+	// Missing subtitles are not worth failing a finished download for.
+	if err := EmbedSubtitles(job.Output, src.Subtitles(job.Output)); err != nil {
+		m.logf("Warning: Could not add subtitles to job %d: %v", job.ID, err)
+	}
+	return nil
+}
+
+// finish records the outcome of a job. A job that completed before a pause or
+// cancel took effect counts as done; otherwise states set by the user are
+// left alone.
+func (m *Manager) finish(ctx context.Context, r jobResult) {
+	var cleanup string
+	var finished *Job
+	err := m.Queue.Update(r.job.ID, func(j *Job) error {
+		switch {
+		case r.err == nil:
+			j.State = StateDone
+			j.Done = j.Total
+			finished = j
+			m.logf("Finished job %d: %s", j.ID, j.DisplayTitle())
+		case j.State == StateCanceled:
+			cleanup = PartsDir(j.Output)
+			m.logf("Canceled job %d: %s", j.ID, j.DisplayTitle())
+		case j.State == StatePaused:
+			m.logf("Paused job %d: %s", j.ID, j.DisplayTitle())
+		case ctx.Err() != nil:
+			// The worker is shutting down; pick the job up again next time.
+			j.State = StateQueued
+		default:
+			j.State = StateFailed
+			j.Error = r.err.Error()
+			m.logf("Job %d failed: %v", j.ID, r.err)
+		}
+		return nil
+	})
+	if err != nil {
+		m.logf("Warning: %v", err)
+	}
+	if cleanup != "" {
+		os.RemoveAll(cleanup)
+	}
+	if finished != nil && m.OnDone != nil {
+		m.OnDone(*finished)
+	}
+}
+
+func (m *Manager) logf(format string, v ...any) {
+	if m.Logf != nil {
+		m.Logf(format, v...)
+	}
+}
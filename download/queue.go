@@ -0,0 +1,237 @@
+package download
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"kino/internal/jsonstore"
+	"kino/internal/xdg"
+)
+
+const queueFileName = "downloads.json"
+
+// JobState is where a queued download is in its lifecycle.
+type JobState string
+
+const (
+	StateQueued   JobState = "queued"
+	StateRunning  JobState = "running"
+	StatePaused   JobState = "paused"
+	StateDone     JobState = "done"
+	StateFailed   JobState = "failed"
+	StateCanceled JobState = "canceled"
+)
+
+// Job is a download waiting in or taken from the queue. It stores what to
+// resolve rather than a stream URL, since those expire before a job may run.
+type Job struct {
+	ID        int    `json:"id"`
+	Title     string `json:"title"`
+	IMDbID    string `json:"imdb_id"`
+	MediaType string `json:"media_type"`
+	Season    int    `json:"season,omitempty"`
+	Episode   int    `json:"episode,omitempty"`
+	Quality   string `json:"quality,omitempty"`
+	// Subtitles are the subtitle languages to fetch, e.g. ["en", "de"].
+	Subtitles []string `json:"subtitles,omitempty"`
+	// Variant is the resolution actually downloaded, known once the job starts.
+	Variant string   `json:"variant,omitempty"`
+	Output  string   `json:"output"`
+	State   JobState `json:"state"`
+	Error   string   `json:"error,omitempty"`
+	// Done and Total count segments, as of the last progress update.
+	Done      int       `json:"done"`
+	Total     int       `json:"total"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// DisplayTitle returns the title with an SxxEyy suffix for episodes.
+func (j Job) DisplayTitle() string {
+	if j.Season > 0 && j.Episode > 0 {
+		return fmt.Sprintf("%s - S%02dE%02d", j.Title, j.Season, j.Episode)
+	}
+	return j.Title
+}
+
+type queueFile struct {
+	NextID int   `json:"next_id"`
+	Jobs   []Job `json:"jobs"`
+}
+
+// Queue is the download job list persisted in the XDG state directory, shared
+// between the worker and the commands that control it.
+type Queue struct {
+	path string
+}
+
+// OpenQueue returns the queue in the default state directory.
+func OpenQueue() (*Queue, error) {
+	dir, err := xdg.StateDir()
+	if err != nil {
+		return nil, err
+	}
+	return &Queue{path: filepath.Join(dir, queueFileName)}, nil
+}
+
+// Add appends a queued job and returns it with its ID assigned.
+func (q *Queue) Add(job Job) (Job, error) {
+	var f queueFile
+	err := jsonstore.Update(q.path, &f, func() error {
+		f.NextID++
+		job.ID = f.NextID
+		job.State = StateQueued
+		job.CreatedAt = time.Now()
+		job.UpdatedAt = job.CreatedAt
+		f.Jobs = append(f.Jobs, job)
+		return nil
+	})
+	if err != nil {
+		return Job{}, fmt.Errorf("queueing download: %w", err)
+	}
+	return job, nil
+}
+
+// List returns all jobs in the order they were queued.
+func (q *Queue) List() ([]Job, error) {
+	var f queueFile
+	if err := jsonstore.View(q.path, &f); err != nil {
+		return nil, fmt.Errorf("reading download queue: %w", err)
+	}
+	return f.Jobs, nil
+}
+
+// Update applies fn to the job with the given ID.
+func (q *Queue) Update(id int, fn func(*Job) error) error {
+	var f queueFile
+	err := jsonstore.Update(q.path, &f, func() error {
+		for i := range f.Jobs {
+			if f.Jobs[i].ID == id {
+				if err := fn(&f.Jobs[i]); err != nil {
+					return err
+				}
+				f.Jobs[i].UpdatedAt = time.Now()
+				return nil
+			}
+		}
+		return fmt.Errorf("no download job with id %d", id)
+	})
+	if err != nil {
+		return fmt.Errorf("updating download job: %w", err)
+	}
+	return nil
+}
+
+// Pause stops a queued or running job, keeping its downloaded segments.
+func (q *Queue) Pause(id int) error {
+	return q.Update(id, func(job *Job) error {
+		if job.State != StateQueued && job.State != StateRunning {
+			return fmt.Errorf("job %d is %s", id, job.State)
+		}
+		job.State = StatePaused
+		return nil
+	})
+}
+
+// Resume puts a paused, failed or canceled job back in the queue.
+func (q *Queue) Resume(id int) error {
+	return q.Update(id, func(job *Job) error {
+		switch job.State {
+		case StatePaused, StateFailed, StateCanceled:
+			job.State = StateQueued
+			job.Error = ""
+			return nil
+		default:
+			return fmt.Errorf("job %d is %s", id, job.State)
+		}
+	})
+}
+
+// Cancel stops a job and discards its downloaded segments. A running job's
+// segments are removed by the worker once it has stopped.
+func (q *Queue) Cancel(id int) error {
+	var output string
+	wasRunning := false
+	err := q.Update(id, func(job *Job) error {
+		if job.State == StateDone || job.State == StateCanceled {
+			return fmt.Errorf("job %d is %s", id, job.State)
+		}
+		output = job.Output
+		wasRunning = job.State == StateRunning
+		job.State = StateCanceled
+		return nil
+	})
+	if err != nil || wasRunning {
+		return err
+	}
+	return os.RemoveAll(PartsDir(output))
+}
+
+// claim marks up to n queued jobs as running and returns them.
+func (q *Queue) claim(n int) ([]Job, error) {
+	var f queueFile
+	var claimed []Job
+	err := jsonstore.Update(q.path, &f, func() error {
+		for i := range f.Jobs {
+			if len(claimed) == n {
+				break
+			}
+			if f.Jobs[i].State == StateQueued {
+				f.Jobs[i].State = StateRunning
+				f.Jobs[i].Error = ""
+				f.Jobs[i].UpdatedAt = time.Now()
+				claimed = append(claimed, f.Jobs[i])
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("claiming download jobs: %w", err)
+	}
+	return claimed, nil
+}
+
+// requeueRunning puts jobs left running by a worker that did not shut down
+// cleanly back in the queue.
+func (q *Queue) requeueRunning() error {
+	var f queueFile
+	return jsonstore.Update(q.path, &f, func() error {
+		for i := range f.Jobs {
+			if f.Jobs[i].State == StateRunning {
+				f.Jobs[i].State = StateQueued
+			}
+		}
+		return nil
+	})
+}
+
+// Prune removes finished, failed and canceled jobs and reports how many.
+func (q *Queue) Prune() (int, error) {
+	var f queueFile
+	removed := 0
+	err := jsonstore.Update(q.path, &f, func() error {
+		kept := f.Jobs[:0]
+		for _, job := range f.Jobs {
+			switch job.State {
+			case StateDone, StateFailed, StateCanceled:
+				removed++
+			default:
+				kept = append(kept, job)
+			}
+		}
+		f.Jobs = kept
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("pruning download queue: %w", err)
+	}
+	return removed, nil
+}
+
+// workerLockPath is held by the process running the queue, so only one
+// worker runs at a time.
+func (q *Queue) workerLockPath() string {
+	return q.path + ".worker"
+}
@@ -0,0 +1,362 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"kino/internal/filelock"
+)
+
+func newTestQueue(t *testing.T) *Queue {
+	t.Helper()
+	return &Queue{path: filepath.Join(t.TempDir(), queueFileName)}
+}
+
+// addJob queues a job writing to a .ts file in the queue's directory and
+// moves it to state.
+func addJob(t *testing.T, q *Queue, state JobState) Job {
+	t.Helper()
+	job, err := q.Add(Job{Title: "Movie", Output: filepath.Join(filepath.Dir(q.path), fmt.Sprintf("movie%d.ts", time.Now().UnixNano()))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = q.Update(job.ID, func(j *Job) error {
+		j.State = state
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job.State = state
+	return job
+}
+
+func jobState(t *testing.T, q *Queue, id int) JobState {
+	t.Helper()
+	jobs, err := q.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, job := range jobs {
+		if job.ID == id {
+			return job.State
+		}
+	}
+	t.Fatalf("no job %d", id)
+	return ""
+}
+
+func TestQueueTransitions(t *testing.T) {
+	ops := map[string]func(*Queue, int) error{
+		"pause":  (*Queue).Pause,
+		"resume": (*Queue).Resume,
+		"cancel": (*Queue).Cancel,
+	}
+	tests := []struct {
+		from JobState
+		op   string
+		want JobState // "" if the op is refused
+	}{
+		{StateQueued, "pause", StatePaused},
+		{StateRunning, "pause", StatePaused},
+		{StatePaused, "pause", ""},
+		{StateDone, "pause", ""},
+		{StateFailed, "pause", ""},
+		{StateCanceled, "pause", ""},
+
+		{StatePaused, "resume", StateQueued},
+		{StateFailed, "resume", StateQueued},
+		{StateCanceled, "resume", StateQueued},
+		{StateQueued, "resume", ""},
+		{StateRunning, "resume", ""},
+		{StateDone, "resume", ""},
+
+		{StateQueued, "cancel", StateCanceled},
+		{StateRunning, "cancel", StateCanceled},
+		{StatePaused, "cancel", StateCanceled},
+		{StateFailed, "cancel", StateCanceled},
+		{StateDone, "cancel", ""},
+		{StateCanceled, "cancel", ""},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s %s", tt.op, tt.from), func(t *testing.T) {
+			q := newTestQueue(t)
+			job := addJob(t, q, tt.from)
+			err := ops[tt.op](q, job.ID)
+			switch {
+			case tt.want == "" && err == nil:
+				t.Errorf("%s of a %s job succeeded", tt.op, tt.from)
+			case tt.want != "" && err != nil:
+				t.Errorf("%s of a %s job: %v", tt.op, tt.from, err)
+			}
+
+			want := tt.want
+			if want == "" {
+				want = tt.from
+			}
+			if got := jobState(t, q, job.ID); got != want {
+				t.Errorf("state after %s = %s, want %s", tt.op, got, want)
+			}
+		})
+	}
+}
+
+func TestResumeClearsError(t *testing.T) {
+	q := newTestQueue(t)
+	job := addJob(t, q, StateFailed)
+	q.Update(job.ID, func(j *Job) error {
+		j.Error = "segment 3: 404 Not Found"
+		return nil
+	})
+	if err := q.Resume(job.ID); err != nil {
+		t.Fatal(err)
+	}
+	jobs, _ := q.List()
+	if jobs[0].Error != "" {
+		t.Errorf("Error after Resume = %q", jobs[0].Error)
+	}
+}
+
+func TestCancelRemovesParts(t *testing.T) {
+	tests := []struct {
+		from      JobState
+		wantParts bool // a running job's parts are left to the worker
+	}{
+		{StateQueued, false},
+		{StatePaused, false},
+		{StateRunning, true},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.from), func(t *testing.T) {
+			q := newTestQueue(t)
+			job := addJob(t, q, tt.from)
+			if err := os.MkdirAll(PartsDir(job.Output), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := q.Cancel(job.ID); err != nil {
+				t.Fatal(err)
+			}
+			_, err := os.Stat(PartsDir(job.Output))
+			if gotParts := err == nil; gotParts != tt.wantParts {
+				t.Errorf("parts kept = %v, want %v", gotParts, tt.wantParts)
+			}
+		})
+	}
+}
+
+func TestPrune(t *testing.T) {
+	q := newTestQueue(t)
+	for _, state := range []JobState{StateQueued, StateRunning, StatePaused, StateDone, StateFailed, StateCanceled} {
+		addJob(t, q, state)
+	}
+	n, err := q.Prune()
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobs, _ := q.List()
+	var left []JobState
+	for _, job := range jobs {
+		left = append(left, job.State)
+	}
+	if n != 3 || fmt.Sprint(left) != "[queued running paused]" {
+		t.Errorf("Prune() = %d, left %v", n, left)
+	}
+}
+
+// segmentServer serves a media playlist of n segments and counts the
+// requests for each.
+func segmentServer(t *testing.T, n int) (*httptest.Server, func(i int) int) {
+	t.Helper()
+	var mu sync.Mutex
+	hits := make(map[int]int)
+
+	mux := http.NewServeMux()
+	var playlist strings.Builder
+	playlist.WriteString("#EXTM3U\n#EXT-X-TARGETDURATION:4\n")
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&playlist, "#EXTINF:4.0,\nseg%d.ts\n", i)
+		mux.HandleFunc(fmt.Sprintf("/seg%d.ts", i), func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			hits[i]++
+			mu.Unlock()
+			fmt.Fprintf(w, "remote %d;", i)
+		})
+	}
+	playlist.WriteString("#EXT-X-ENDLIST\n")
+	mux.HandleFunc("/index.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(playlist.String()))
+	})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv, func(i int) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return hits[i]
+	}
+}
+
+func TestManagerRecoversInterruptedJob(t *testing.T) {
+	srv, hits := segmentServer(t, 3)
+	q := newTestQueue(t)
+	// A worker that died mid-download leaves the job running with some of
+	// its segments on disk.
+	job := addJob(t, q, StateRunning)
+	parts := PartsDir(job.Output)
+	if err := preparePartsDir(parts, manifest{Segments: 3, Duration: 12 * time.Second}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(segmentPath(parts, 0), []byte("local 0;"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var done []int
+	m := &Manager{
+		Queue: q,
+		Resolve: func(ctx context.Context, job Job) (Source, error) {
+			return Source{URL: srv.URL + "/index.m3u8", Variant: "1280x720"}, nil
+		},
+		OnDone: func(job Job) { done = append(done, job.ID) },
+	}
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := jobState(t, q, job.ID); got != StateDone {
+		t.Errorf("state = %s, want done", got)
+	}
+	if fmt.Sprint(done) != fmt.Sprint([]int{job.ID}) {
+		t.Errorf("OnDone called for %v", done)
+	}
+	if hits(0) != 0 || hits(1) != 1 || hits(2) != 1 {
+		t.Errorf("segment requests = %d, %d, %d; want 0, 1, 1", hits(0), hits(1), hits(2))
+	}
+	data, err := os.ReadFile(job.Output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "local 0;remote 1;remote 2;" {
+		t.Errorf("output = %q", data)
+	}
+	if _, err := os.Stat(parts); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("parts directory left behind: %v", err)
+	}
+}
+
+func TestManagerFailsJob(t *testing.T) {
+	q := newTestQueue(t)
+	job := addJob(t, q, StateQueued)
+	m := &Manager{
+		Queue: q,
+		Resolve: func(ctx context.Context, job Job) (Source, error) {
+			return Source{}, errors.New("no streams found")
+		},
+	}
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	jobs, _ := q.List()
+	if jobs[0].State != StateFailed || jobs[0].Error != "no streams found" {
+		t.Errorf("job %d = %s %q, want failed", job.ID, jobs[0].State, jobs[0].Error)
+	}
+}
+
+func TestManagerStopsJobs(t *testing.T) {
+	tests := []struct {
+		name      string
+		stop      func(*Queue, int) error
+		want      JobState
+		wantParts bool
+	}{
+		{"pause", (*Queue).Pause, StatePaused, true},
+		{"cancel", (*Queue).Cancel, StateCanceled, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newTestQueue(t)
+			job := addJob(t, q, StateQueued)
+			if err := os.MkdirAll(PartsDir(job.Output), 0755); err != nil {
+				t.Fatal(err)
+			}
+
+			started := make(chan struct{})
+			m := &Manager{
+				Queue: q,
+				// The download runs until the manager stops it.
+				Resolve: func(ctx context.Context, job Job) (Source, error) {
+					close(started)
+					<-ctx.Done()
+					return Source{}, ctx.Err()
+				},
+			}
+			errc := make(chan error)
+			go func() { errc <- m.Run(context.Background()) }()
+
+			<-started
+			// Another process changes the job's state in the queue.
+			if err := tt.stop(q, job.ID); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case err := <-errc:
+				if err != nil {
+					t.Fatal(err)
+				}
+			case <-time.After(5 * pollInterval):
+				t.Fatal("the manager did not stop the job")
+			}
+
+			if got := jobState(t, q, job.ID); got != tt.want {
+				t.Errorf("state = %s, want %s", got, tt.want)
+			}
+			_, err := os.Stat(PartsDir(job.Output))
+			if gotParts := err == nil; gotParts != tt.wantParts {
+				t.Errorf("parts kept = %v, want %v", gotParts, tt.wantParts)
+			}
+		})
+	}
+}
+
+func TestManagerShutdownRequeues(t *testing.T) {
+	q := newTestQueue(t)
+	job := addJob(t, q, StateQueued)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &Manager{
+		Queue: q,
+		Resolve: func(ctx context.Context, job Job) (Source, error) {
+			cancel()
+			<-ctx.Done()
+			return Source{}, ctx.Err()
+		},
+	}
+	if err := m.Run(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Run = %v, want context.Canceled", err)
+	}
+	if got := jobState(t, q, job.ID); got != StateQueued {
+		t.Errorf("state = %s, want queued", got)
+	}
+}
+
+func TestManagerSingleWorker(t *testing.T) {
+	q := newTestQueue(t)
+	unlock, ok, err := filelock.TryLock(q.workerLockPath())
+	if err != nil || !ok {
+		t.Fatalf("locking: %v, %v", ok, err)
+	}
+	defer unlock()
+
+	m := &Manager{Queue: q}
+	if err := m.Run(context.Background()); !errors.Is(err, ErrWorkerRunning) {
+		t.Errorf("Run = %v, want ErrWorkerRunning", err)
+	}
+}
@@ -0,0 +1,88 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLimiterSharedRate(t *testing.T) {
+	const (
+		rate    = 256 << 10
+		readers = 4
+		each    = 64 << 10
+	)
+	l := NewLimiter(rate)
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := &limitedReader{ctx: context.Background(), r: bytes.NewReader(make([]byte, each)), limiter: l}
+			if n, err := io.Copy(io.Discard, r); err != nil || n != each {
+				t.Errorf("copied %d, %v", n, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// The bucket starts empty, so readers*each bytes take about a second
+	// together however many readers share them.
+	want := time.Duration(readers * each * float64(time.Second) / rate)
+	if elapsed := time.Since(start); elapsed < want*8/10 || elapsed > want*3 {
+		t.Errorf("reading %d bytes at %d B/s took %v, want about %v", readers*each, rate, elapsed, want)
+	}
+}
+
+func TestLimiterBurst(t *testing.T) {
+	const rate = 64 << 10
+	l := NewLimiter(rate)
+	// Idle time fills the bucket, but only up to one second's worth.
+	time.Sleep(1200 * time.Millisecond)
+
+	start := time.Now()
+	if err := l.wait(context.Background(), rate); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("a full bucket waited %v", elapsed)
+	}
+
+	start = time.Now()
+	if err := l.wait(context.Background(), rate/4); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("an empty bucket waited only %v", elapsed)
+	}
+}
+
+func TestLimiterCanceled(t *testing.T) {
+	l := NewLimiter(1024)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	r := &limitedReader{ctx: ctx, r: bytes.NewReader(make([]byte, 1<<20)), limiter: l}
+	_, err := io.Copy(io.Discard, r)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Copy = %v, want the context's error", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("cancellation took %v", elapsed)
+	}
+}
+
+func TestLimitedReaderChunks(t *testing.T) {
+	r := &limitedReader{ctx: context.Background(), r: bytes.NewReader(make([]byte, 4*limitChunk)), limiter: NewLimiter(1 << 40)}
+	n, err := r.Read(make([]byte, 4*limitChunk))
+	if err != nil || n != limitChunk {
+		t.Errorf("Read = %d, %v; want %d", n, err, limitChunk)
+	}
+}
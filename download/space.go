@@ -0,0 +1,58 @@
+package download
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"kino/hls"
+)
+
+// minFreeSpace is required when a download's size cannot be estimated.
+const minFreeSpace = 512 << 20
+
+// ErrInsufficientSpace is returned when the disk a download is written to
+// does not have room for it.
+var ErrInsufficientSpace = errors.New("not enough free disk space")
+
+// checkFreeSpace refuses to start a download that would not fit next to
+// output. The estimate covers the segments still to fetch plus the assembled
+// file, which exists alongside the parts until they are removed, and the
+// intermediate .ts when remuxing to mp4.
+func checkFreeSpace(output string, playlist *hls.MediaPlaylist, bandwidth int64) error {
+	dir := filepath.Dir(output)
+	free, ok := freeSpace(dir)
+	if !ok {
+		return nil
+	}
+
+	need := int64(minFreeSpace)
+	if bandwidth > 0 {
+		total := int64(playlist.TotalDuration().Seconds()) * bandwidth / 8
+
+		partsDir := PartsDir(output)
+		missing := 0
+		for i := range playlist.Segments {
+			if _, err := os.Stat(segmentPath(partsDir, i)); err != nil {
+				missing++
+			}
+		}
+		need = total * int64(missing) / int64(len(playlist.Segments))
+
+		need += total
+		if strings.EqualFold(filepath.Ext(output), ".mp4") {
+			need += total
+		}
+	}
+
+	if free < need {
+		return fmt.Errorf("%w in %s: %s free, about %s needed", ErrInsufficientSpace, dir, formatGiB(free), formatGiB(need))
+	}
+	return nil
+}
+
+func formatGiB(n int64) string {
+	return fmt.Sprintf("%.1f GiB", float64(n)/(1<<30))
+}
@@ -0,0 +1,8 @@
+//go:build !(linux || darwin || freebsd)
+
+package download
+
+// freeSpace is not implemented on this platform, so no check is made.
+func freeSpace(dir string) (int64, bool) {
+	return 0, false
+}
@@ -0,0 +1,88 @@
+package download
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"kino/subtitles"
+)
+
+// EmbedSubtitles adds subtitle files to a finished download. MP4 outputs get
+// them as text tracks tagged with their language; other outputs get them as
+// sidecar files named like "Film.en.srt", which players and media servers
+// pick up.
+func EmbedSubtitles(output string, files []subtitles.File) error {
+	if len(files) == 0 {
+		return nil
+	}
+	if !strings.EqualFold(filepath.Ext(output), ".mp4") {
+		for _, f := range files {
+			if err := copyFile(f.Path, SidecarPath(output, f)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	ffmpeg, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return fmt.Errorf("ffmpeg is needed to embed subtitles: %w", err)
+	}
+	// The new tracks follow any the output already has, and -metadata
+	// counts subtitle streams across the whole output.
+	existing, err := subtitleStreams(output)
+	if err != nil {
+		return err
+	}
+
+	args := []string{"-i", output}
+	for _, f := range files {
+		args = append(args, "-i", f.Path)
+	}
+	args = append(args, "-map", "0")
+	for i := range files {
+		args = append(args, "-map", fmt.Sprint(i+1))
+	}
+	args = append(args, "-c", "copy", "-c:s", "mov_text")
+	for i, f := range files {
+		args = append(args, fmt.Sprintf("-metadata:s:s:%d", existing+i), "language="+subtitles.LanguageCode3(f.Language))
+	}
+	return runFFmpeg(ffmpeg, output, args...)
+}
+
+// subtitleStreams returns how many subtitle streams the media file at path
+// has.
+func subtitleStreams(path string) (int, error) {
+	ffprobe, err := exec.LookPath("ffprobe")
+	if err != nil {
+		return 0, fmt.Errorf("ffprobe is needed to embed subtitles: %w", err)
+	}
+	out, err := exec.Command(ffprobe, "-v", "error", "-select_streams", "s",
+		"-show_entries", "stream=index", "-of", "csv=p=0", path).Output()
+	if err != nil {
+		return 0, fmt.Errorf("probing %s: %w", path, err)
+	}
+	return len(strings.Fields(string(out))), nil
+}
+
+// SidecarPath returns where EmbedSubtitles puts f next to output.
+func SidecarPath(output string, f subtitles.File) string {
+	base := strings.TrimSuffix(output, filepath.Ext(output))
+	lang := f.Language
+	if lang == "" {
+		lang = strings.TrimSuffix(filepath.Base(f.Path), filepath.Ext(f.Path))
+	}
+	return base + "." + lang + filepath.Ext(f.Path)
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	return writeAtomic(dst, in)
+}
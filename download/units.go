@@ -0,0 +1,62 @@
+package download
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var sizePattern = regexp.MustCompile(`^([0-9]*\.?[0-9]+)\s*([kmgt]?)(i?)(b?)$`)
+
+// ParseRate parses a rate such as "2MiB/s", "500k" or "1.5MB/s" into bytes
+// per second. Units are as for ParseSize.
+func ParseRate(s string) (int64, error) {
+	n, ok := parseBytes(strings.TrimSuffix(strings.ToLower(strings.TrimSpace(s)), "/s"))
+	if !ok {
+		return 0, fmt.Errorf("invalid rate %q (want something like 2MiB/s)", s)
+	}
+	if n < 1 {
+		return 0, fmt.Errorf("rate %q is too low", s)
+	}
+	return n, nil
+}
+
+// ParseSize parses a size such as "50GiB", "500M" or "1.5TB" into bytes.
+// Binary (KiB) and decimal (KB) units are both accepted; a bare "k", "m",
+// "g" or "t" is binary.
+func ParseSize(s string) (int64, error) {
+	n, ok := parseBytes(strings.ToLower(strings.TrimSpace(s)))
+	if !ok {
+		return 0, fmt.Errorf("invalid size %q (want something like 50GiB)", s)
+	}
+	return n, nil
+}
+
+func parseBytes(s string) (int64, bool) {
+	m := sizePattern.FindStringSubmatch(s)
+	if m == nil {
+		return 0, false
+	}
+
+	value, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, false
+	}
+
+	base := 1024.0
+	if m[3] == "" && m[4] == "b" {
+		base = 1000
+	}
+	switch m[2] {
+	case "k":
+		value *= base
+	case "m":
+		value *= base * base
+	case "g":
+		value *= base * base * base
+	case "t":
+		value *= base * base * base * base
+	}
+	return int64(value), true
+}
@@ -0,0 +1,73 @@
+package download
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64 // -1 for an error
+	}{
+		{"0", 0},
+		{"1234", 1234},
+		{"500k", 500 << 10},
+		{"500K", 500 << 10},
+		{"500KiB", 500 << 10},
+		{"500KB", 500000},
+		{"50GiB", 50 << 30},
+		{"50 GiB", 50 << 30},
+		{" 2m ", 2 << 20},
+		{"1.5TB", 1500000000000},
+		{"1.5t", 1.5 * (1 << 40)},
+		{".5g", 1 << 29},
+		{"12b", 12},
+		{"", -1},
+		{"GiB", -1},
+		{"-5M", -1},
+		{"5 MiB/s", -1},
+		{"5PB", -1},
+		{"1.2.3", -1},
+	}
+	for _, tt := range tests {
+		got, err := ParseSize(tt.in)
+		if tt.want < 0 {
+			if err == nil {
+				t.Errorf("ParseSize(%q) = %d, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ParseSize(%q) = %d, %v; want %d", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64 // -1 for an error
+	}{
+		{"2MiB/s", 2 << 20},
+		{"2mib/S", 2 << 20},
+		{"500k", 500 << 10},
+		{"500k/s", 500 << 10},
+		{"1.5MB/s", 1500000},
+		{"800", 800},
+		{"0", -1},
+		{"0.5", -1},
+		{"fast", -1},
+		{"2MiB/min", -1},
+		{"/s", -1},
+	}
+	for _, tt := range tests {
+		got, err := ParseRate(tt.in)
+		if tt.want < 0 {
+			if err == nil {
+				t.Errorf("ParseRate(%q) = %d, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ParseRate(%q) = %d, %v; want %d", tt.in, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,606 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	"kino/download"
+	"kino/internal/xdg"
+	"kino/library"
+	"kino/metadata"
+	"kino/stream"
+	"kino/subtitles"
+	"kino/ui"
+
+	"github.com/StalkR/imdb"
+)
+
+const downloadUsage = `usage: kino download [flags] <query|imdb-id>`
+
+const downloadsUsage = `usage: kino downloads [list]
+       kino downloads pause|resume|cancel <id>...
+       kino downloads run
+       kino downloads prune`
+
+var imdbIDPattern = regexp.MustCompile(`^tt\d+$`)
+
+// downloadTarget is a resolved movie or episode to download.
+type downloadTarget struct {
+	imdbID    string
+	mediaType stream.MediaType
+	season    int
+	episode   int
+	title     *imdb.Title
+	// episodeInfo is the episode's entry in the IMDb season, if known.
+	episodeInfo *imdb.Episode
+	output      string
+}
+
+func runDownload(client *http.Client, args []string) error {
+	fs := flag.NewFlagSet("download", flag.ContinueOnError)
+	season := fs.Int("season", 0, "Season number for TV shows")
+	episode := fs.Int("episode", 0, "Episode number for TV shows; with -queue, omit to queue the whole season")
+	quality := fs.String("quality", "", "Variant to download: 720p, best, worst or <=720p; asks when unset or unavailable")
+	output := fs.String("o", "", "Output file; the extension picks .ts or remuxed .mp4")
+	dir := fs.String("dir", cfg.Download.Dir, "Directory for downloads when -o is not given; use it when queueing a season")
+	concurrency := fs.Int("concurrency", cfg.Download.Concurrency, "Segments fetched at once")
+	queue := fs.Bool("queue", false, "Add to the background download queue instead of downloading now")
+	limitRate := fs.String("limit-rate", cfg.Download.LimitRate, "Cap download speed across all segment fetches (e.g., 2MiB/s)")
+	progressMode := fs.String("progress", "auto", "Progress output: auto, bar or json (auto uses json when stdout is not a terminal)")
+	nfo := fs.Bool("nfo", cfg.Download.NFO, "Write Kodi-style .nfo files next to downloads")
+	subsList := fs.String("subs", strings.Join(subtitleLanguages(), ","), "Subtitle languages to add, e.g. en,de")
+	skipSubs := fs.Bool("no-subs", false, "Do not add subtitles")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), downloadUsage)
+		fs.PrintDefaults()
+	}
+
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected one query or IMDb ID")
+	}
+
+	targets, err := resolveDownloadTargets(client, positional[0], *season, *episode, *queue)
+	if err != nil {
+		return err
+	}
+	languages := parseLanguages(*subsList)
+	if *skipSubs {
+		languages = nil
+	}
+
+	if *output != "" && len(targets) > 1 {
+		return fmt.Errorf("-o names a single file but %d episodes were chosen; use -dir instead", len(targets))
+	}
+	for _, t := range targets {
+		if *output != "" {
+			t.output = *output
+		} else if t.output, err = downloadPath(*dir, t); err != nil {
+			return err
+		}
+	}
+
+	if *queue {
+		return queueDownloads(client, targets, *quality, languages, *nfo)
+	}
+
+	limiter, err := newLimiter(*limitRate)
+	if err != nil {
+		return err
+	}
+	progress, err := newProgressReporter(*progressMode)
+	if err != nil {
+		return err
+	}
+
+	target := targets[0]
+	if !progress.json {
+		fmt.Println("\nFetching streaming options...")
+	}
+	variants, err := stream.GetStreamVariants(target.imdbID, target.mediaType, target.season, target.episode)
+	if err != nil {
+		return fmt.Errorf("failed to get streaming variants: %w", err)
+	}
+	variant := stream.Select(variants, *quality, qualityPreferences())
+	if variant == nil {
+		variant, err = ui.SelectStreamVariant(variants)
+		if err != nil {
+			return err
+		}
+	}
+
+	path := target.output
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("creating output directory: %w", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if !progress.json {
+		fmt.Printf("\nDownloading %s to %s\n", ui.FormatVariantDisplay(*variant), path)
+	}
+	err = download.Download(ctx, variant.URL, path, download.Options{
+		Concurrency: *concurrency,
+		Header:      stream.VariantHeader(variant),
+		Limiter:     limiter,
+		Bandwidth:   variantBandwidth(variant),
+		Progress:    progress.report,
+		StripAds:    cfg.StripAds,
+		Debugf:      debugf,
+	})
+	progress.finish()
+	if errors.Is(err, context.Canceled) {
+		return fmt.Errorf("download interrupted; run the same command again to resume")
+	}
+	if err != nil {
+		return err
+	}
+	files := downloadSubtitles(target.imdbID, target.season, target.episode, variant, languages, path)
+	if err := download.EmbedSubtitles(path, files); err != nil {
+		log.Printf("Warning: Could not add subtitles: %v", err)
+	}
+
+	addToLibrary(library.Item{
+		Title:   target.title.Name,
+		IMDbID:  target.title.ID,
+		Season:  target.season,
+		Episode: target.episode,
+		Variant: variant.Resolution,
+		Path:    path,
+	})
+
+	if *nfo {
+		writeMetadata(client, target)
+	}
+
+	if !progress.json {
+		fmt.Printf("Saved %s\n", path)
+	}
+	return nil
+}
+
+// newLimiter returns a limiter for a rate such as "2MiB/s", or nil for no limit.
+func newLimiter(rate string) (*download.Limiter, error) {
+	if rate == "" {
+		return nil, nil
+	}
+	bytesPerSecond, err := download.ParseRate(rate)
+	if err != nil {
+		return nil, err
+	}
+	return download.NewLimiter(bytesPerSecond), nil
+}
+
+// queueDownloads adds targets to the download queue and makes sure a
+// background worker is running to process it.
+func queueDownloads(client *http.Client, targets []*downloadTarget, quality string, languages []string, nfo bool) error {
+	q, err := download.OpenQueue()
+	if err != nil {
+		return err
+	}
+
+	for _, t := range targets {
+		path := t.output
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return fmt.Errorf("creating output directory: %w", err)
+		}
+
+		job, err := q.Add(download.Job{
+			Title:     t.title.Name,
+			IMDbID:    t.imdbID,
+			MediaType: string(t.mediaType),
+			Season:    t.season,
+			Episode:   t.episode,
+			Quality:   quality,
+			Subtitles: languages,
+			Output:    path,
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Queued job %d: %s\n", job.ID, job.DisplayTitle())
+
+		if nfo {
+			writeMetadata(client, t)
+		}
+	}
+
+	return startDownloadWorker()
+}
+
+// parseInterspersed parses flags that may appear before or after positional
+// arguments, which the flag package alone stops at.
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// resolveDownloadTargets turns a query or IMDb ID into a movie or episode,
+// asking for the title, season or episode where they are not given. With
+// wholeSeason set, a missing episode selects every episode of the season.
+func resolveDownloadTargets(client *http.Client, query string, season, episode int, wholeSeason bool) ([]*downloadTarget, error) {
+	id := query
+	if !imdbIDPattern.MatchString(query) {
+		results, err := imdb.SearchTitle(client, query)
+		if err != nil {
+			return nil, fmt.Errorf("error searching: %v", err)
+		}
+		if len(results) == 0 {
+			return nil, fmt.Errorf("no results found for %q", query)
+		}
+		selected, err := ui.SelectTitle(results)
+		if err != nil {
+			return nil, err
+		}
+		id = selected.ID
+	}
+
+	title, err := imdb.NewTitle(client, id)
+	if err != nil {
+		return nil, fmt.Errorf("error getting title details: %v", err)
+	}
+
+	if !isTVShow(title) {
+		return []*downloadTarget{{imdbID: id, mediaType: stream.Movie, title: title}}, nil
+	}
+
+	if season == 0 {
+		seasons, err := getSeasons(client, title)
+		if err != nil {
+			return nil, err
+		}
+		if season, err = ui.SelectSeason(seasons); err != nil {
+			return nil, err
+		}
+	}
+
+	seasonInfo, err := imdb.NewSeason(client, id, season)
+	if err != nil {
+		return nil, fmt.Errorf("error getting season %d: %v", season, err)
+	}
+
+	episodes := []int{episode}
+	if episode == 0 {
+		all := make([]int, len(seasonInfo.Episodes))
+		for i, ep := range seasonInfo.Episodes {
+			all[i] = ep.EpisodeNumber
+		}
+		if len(all) == 0 {
+			return nil, fmt.Errorf("no episodes found for season %d", season)
+		}
+		if wholeSeason {
+			episodes = all
+		} else if episodes[0], err = ui.SelectEpisode(all); err != nil {
+			return nil, err
+		}
+	}
+
+	targets := make([]*downloadTarget, len(episodes))
+	for i, ep := range episodes {
+		targets[i] = &downloadTarget{
+			imdbID:    fmt.Sprintf("%s/%d-%d", id, season, ep),
+			mediaType: stream.TV,
+			season:    season,
+			episode:   ep,
+			title:     title,
+		}
+		for j := range seasonInfo.Episodes {
+			if seasonInfo.Episodes[j].EpisodeNumber == ep {
+				targets[i].episodeInfo = &seasonInfo.Episodes[j]
+			}
+		}
+	}
+	return targets, nil
+}
+
+// downloadFormat returns the configured file extension, falling back to .ts
+// when ffmpeg is not installed for remuxing.
+func downloadFormat() string {
+	format := strings.ToLower(cfg.Download.Format)
+	if format == "mp4" && !download.CanRemux() {
+		log.Printf("Warning: ffmpeg not found in PATH, saving as .ts instead of .mp4")
+		return "ts"
+	}
+	if format != "mp4" {
+		return "ts"
+	}
+	return format
+}
+
+// downloadPath fills in the configured output template for t under dir.
+func downloadPath(dir string, t *downloadTarget) (string, error) {
+	fields := metadata.Fields{
+		Title:   t.title.Name,
+		Year:    t.title.Year,
+		Season:  t.season,
+		Episode: t.episode,
+		IMDbID:  t.title.ID,
+	}
+	template := cfg.Download.MovieTemplate
+	if t.mediaType == stream.TV {
+		template = cfg.Download.EpisodeTemplate
+		if t.episodeInfo != nil {
+			fields.EpisodeTitle = t.episodeInfo.Name
+		}
+	}
+
+	name, err := metadata.Expand(template, fields)
+	if err != nil {
+		return "", err
+	}
+
+	// The format decides the extension, whatever the template says.
+	switch ext := filepath.Ext(name); strings.ToLower(ext) {
+	case ".mp4", ".ts":
+		name = strings.TrimSuffix(name, ext)
+	}
+	return filepath.Join(dir, name+"."+downloadFormat()), nil
+}
+
+// writeMetadata writes the .nfo files for a download. They are a nicety, so
+// failures are only logged.
+func writeMetadata(client *http.Client, t *downloadTarget) {
+	var err error
+	if t.mediaType != stream.TV {
+		err = metadata.WriteMovieNFO(t.output, t.title)
+	} else {
+		if err := metadata.WriteTVShowNFO(t.output, t.title); err != nil {
+			log.Printf("Warning: Could not write show metadata: %v", err)
+		}
+
+		episode := imdb.Episode{SeasonNumber: t.season, EpisodeNumber: t.episode}
+		var details *imdb.Title
+		if t.episodeInfo != nil {
+			episode = *t.episodeInfo
+			if episode.ID != "" {
+				if details, err = imdb.NewTitle(client, episode.ID); err != nil {
+					log.Printf("Warning: Could not get episode details: %v", err)
+				}
+			}
+		}
+		err = metadata.WriteEpisodeNFO(t.output, t.title, episode, details)
+	}
+	if err != nil {
+		log.Printf("Warning: Could not write metadata: %v", err)
+	}
+}
+
+func runDownloads(args []string) error {
+	q, err := download.OpenQueue()
+	if err != nil {
+		return err
+	}
+
+	cmd := "list"
+	if len(args) > 0 {
+		cmd, args = args[0], args[1:]
+	}
+
+	switch cmd {
+	case "list":
+		jobs, err := q.List()
+		if err != nil {
+			return err
+		}
+		printDownloads(jobs)
+
+	case "pause", "resume", "cancel":
+		if len(args) == 0 {
+			return fmt.Errorf("missing job id\n%s", downloadsUsage)
+		}
+		for _, arg := range args {
+			id, err := strconv.Atoi(arg)
+			if err != nil {
+				return fmt.Errorf("invalid job id %q", arg)
+			}
+			switch cmd {
+			case "pause":
+				err = q.Pause(id)
+			case "resume":
+				err = q.Resume(id)
+			case "cancel":
+				err = q.Cancel(id)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if cmd == "resume" {
+			return startDownloadWorker()
+		}
+
+	case "run":
+		return runDownloadWorker(q)
+
+	case "prune":
+		removed, err := q.Prune()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Removed %d jobs.\n", removed)
+
+	default:
+		return fmt.Errorf("unknown downloads command %q\n%s", cmd, downloadsUsage)
+	}
+
+	return nil
+}
+
+func printDownloads(jobs []download.Job) {
+	if len(jobs) == 0 {
+		fmt.Println("No downloads queued.")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTATE\tPROGRESS\tTITLE\tOUTPUT")
+	for _, job := range jobs {
+		progress := "-"
+		if job.Total > 0 {
+			progress = fmt.Sprintf("%d/%d", job.Done, job.Total)
+		}
+		state := string(job.State)
+		if job.Error != "" {
+			state += ": " + job.Error
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", job.ID, state, progress, job.DisplayTitle(), job.Output)
+	}
+	w.Flush()
+}
+
+// runDownloadWorker processes the queue in this process until it is empty.
+func runDownloadWorker(q *download.Queue) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// One limiter for the whole worker keeps the cap across concurrent jobs.
+	limiter, err := newLimiter(cfg.Download.LimitRate)
+	if err != nil {
+		return err
+	}
+
+	m := &download.Manager{
+		Queue: q,
+		Jobs:  cfg.Download.Jobs,
+		Options: download.Options{
+			Concurrency: cfg.Download.Concurrency,
+			Header:      stream.Header(),
+			Limiter:     limiter,
+			StripAds:    cfg.StripAds,
+			Debugf:      debugf,
+		},
+		Resolve: resolveDownloadJob,
+		OnDone: func(job download.Job) {
+			addToLibrary(library.Item{
+				Title:   job.Title,
+				IMDbID:  strings.Split(job.IMDbID, "/")[0],
+				Season:  job.Season,
+				Episode: job.Episode,
+				Variant: job.Variant,
+				Path:    job.Output,
+			})
+		},
+		Logf: log.Printf,
+	}
+
+	err = m.Run(ctx)
+	if errors.Is(err, download.ErrWorkerRunning) {
+		fmt.Println("Downloads are already being processed by another kino process.")
+		return nil
+	}
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return err
+}
+
+func resolveDownloadJob(ctx context.Context, job download.Job) (download.Source, error) {
+	variants, err := stream.GetStreamVariants(job.IMDbID, stream.MediaType(job.MediaType), job.Season, job.Episode)
+	if err != nil {
+		return download.Source{}, err
+	}
+
+	prefs := qualityPreferences()
+	variant := stream.Select(variants, job.Quality, prefs)
+	if variant == nil {
+		variant = stream.Select(variants, "best", prefs)
+	}
+	if variant == nil {
+		variant = stream.BestVariant(variants)
+	}
+	return download.Source{
+		URL:       variant.URL,
+		Variant:   variant.Resolution,
+		Bandwidth: variantBandwidth(variant),
+		Header:    stream.VariantHeader(variant),
+		Subtitles: func(output string) []subtitles.File {
+			return downloadSubtitles(job.IMDbID, job.Season, job.Episode, variant, job.Subtitles, output)
+		},
+	}, nil
+}
+
+// variantBandwidth returns the variant's BANDWIDTH in bits per second, or 0
+// if it is missing.
+func variantBandwidth(v *stream.StreamVariant) int64 {
+	n, _ := strconv.ParseInt(v.Bandwidth, 10, 64)
+	return n
+}
+
+// addToLibrary registers a finished download. The file is already saved, so
+// a failure here is only logged.
+func addToLibrary(item library.Item) {
+	lib, err := library.Open()
+	if err != nil {
+		log.Printf("Warning: Could not open library: %v", err)
+		return
+	}
+	if _, err := lib.Add(item); err != nil {
+		log.Printf("Warning: Could not add %s to library: %v", item.Path, err)
+		return
+	}
+
+	if cfg.Library.MaxSize != "" {
+		if err := enforceLibraryQuota(lib, false); err != nil {
+			log.Printf("Warning: Could not apply library size cap: %v", err)
+		}
+	}
+}
+
+// startDownloadWorker runs "kino downloads run" in the background, detached
+// from the terminal, logging to downloads.log in the state directory. If a
+// worker is already running the new one exits straight away.
+func startDownloadWorker() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("locating kino executable: %w", err)
+	}
+	dir, err := xdg.StateDir()
+	if err != nil {
+		return err
+	}
+	logPath := filepath.Join(dir, "downloads.log")
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("opening %s: %w", logPath, err)
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command(exe, "downloads", "run")
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	detach(cmd)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("starting download worker: %w", err)
+	}
+	cmd.Process.Release()
+
+	fmt.Printf("Downloading in the background; see \"kino downloads\" (log: %s)\n", logPath)
+	return nil
+}
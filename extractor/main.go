@@ -12,7 +12,9 @@ import (
 	"strings"
 	"time"
 
+	"kino/hls"
 	httpclient "kino/internal/client"
+	"kino/subtitles"
 
 	"github.com/PuerkitoBio/goquery"
 )
@@ -28,6 +30,9 @@ const (
 	defaultStartCounter    = 1
 )
 
+// Referer is the Referer header stream hosts expect, as sent when fetching the ProRCP page.
+const Referer = cloudnestraBaseURL
+
 // debugMode controls whether debug logs are shown
 var debugMode = os.Getenv("DEBUG") == "1"
 
@@ -73,15 +78,61 @@ type ResolveOptions struct {
 	Episode int
 }
 
+// AutoResolution is the Resolution of a variant that plays the master
+// playlist, leaving the choice of quality to the player.
+const AutoResolution = "auto"
+
 // StreamVariant represents one HLS variant (quality level).
 type StreamVariant struct {
 	Resolution string
 	Bandwidth  string
-	URL        string
+	// Codecs is the CODECS attribute, e.g. "avc1.64001f,mp4a.40.2".
+	Codecs string
+	URL    string
+	// MasterURL is the master playlist the variant was listed in.
+	MasterURL string
+	// Mirrors are other master playlists decoded for the same title.
+	Mirrors []string
+	// Header is what the stream host expects on playlist, key and segment
+	// requests.
+	Header http.Header
+	// Subtitles are the subtitle tracks offered for the title.
+	Subtitles []subtitles.Track
+}
+
+// PlaybackHeader returns the headers stream hosts check: the Referer and
+// Origin of the embedding page, and a browser User-Agent.
+func PlaybackHeader() http.Header {
+	return http.Header{
+		"Referer":    {Referer + "/"},
+		"Origin":     {Referer},
+		"User-Agent": {httpclient.UserAgent},
+	}
 }
 
 // ResolveVariants runs the full resolution pipeline and returns the final HLS master URL.
 func (opts ResolveOptions) ResolveVariants() (string, error) {
+	masterURL, _, err := opts.ResolveMaster()
+	return masterURL, err
+}
+
+// ResolveMaster runs the full resolution pipeline and returns the first
+// working HLS master URL, along with the decoded mirrors after it, which
+// have not been checked.
+func (opts ResolveOptions) ResolveMaster() (string, []string, error) {
+	src, err := opts.resolve()
+	return src.master, src.mirrors, err
+}
+
+// source is what the resolution pipeline finds for a title.
+type source struct {
+	master  string
+	mirrors []string
+	// subtitles are the tracks listed in the player config.
+	subtitles []subtitles.Track
+}
+
+func (opts ResolveOptions) resolve() (source, error) {
 	mediaType := "movie"
 	if opts.Type == TV {
 		mediaType = "TV show"
@@ -91,52 +142,53 @@ func (opts ResolveOptions) ResolveVariants() (string, error) {
 	// Step 1: Build and fetch the initial embed page
 	embedURL, err := opts.constructEmbedURL()
 	if err != nil {
-		return "", err
+		return source{}, err
 	}
 	logDebug("Built embed URL")
 
 	embedHTML, err := fetchContent(embedURL, "")
 	if err != nil {
-		return "", err
+		return source{}, err
 	}
 
 	// Step 2: Extract the RCP URL from the iframe
 	rcpURL, err := extractRCPURL(embedHTML)
 	if err != nil {
-		return "", err
+		return source{}, err
 	}
 	logSuccess("Extracted RCP URL")
 
 	// Step 3: Fetch the RCP page content
 	rcpHTML, err := fetchContent(httpsScheme+rcpURL, "")
 	if err != nil {
-		return "", err
+		return source{}, err
 	}
 
 	// Step 4: Extract the ProRCP URL from the RCP page
 	proRCPURL, err := extractProRCPURL(rcpHTML)
 	if err != nil {
-		return "", err
+		return source{}, err
 	}
 	logSuccess("Extracted ProRCP URL")
 
 	// Step 5: Fetch the ProRCP page with the correct Referer
 	proRCPHTML, err := fetchContent(cloudnestraBaseURL+proRCPURL, cloudnestraBaseURL)
 	if err != nil {
-		return "", err
+		return source{}, err
 	}
 
 	// Step 6: Try to decode, otherwise save
 	decodedURL, err := decodeStreamURL(proRCPHTML)
 	if err != nil {
-		return "", err
+		return source{}, err
 	}
 	logSuccess("Decoded stream URL")
+	tracks := extractSubtitles(proRCPHTML)
 
 	decodedArr := processAndDeduplicateStreamURLs(decodedURL)
 
 	// Try each URL and return the first successful one
-	for _, testURL := range decodedArr {
+	for i, testURL := range decodedArr {
 		logDebug("Testing URL viability")
 		parsedURL, err := url.Parse(testURL)
 		if err != nil {
@@ -151,11 +203,11 @@ func (opts ResolveOptions) ResolveVariants() (string, error) {
 		logDebug("Response status: %d %s", resp.StatusCode, resp.Status)
 		resp.Body.Close()
 		if resp.StatusCode == http.StatusOK {
-			return parsedURL.String(), nil
+			return source{master: parsedURL.String(), mirrors: decodedArr[i+1:], subtitles: tracks}, nil
 		}
 	}
 
-	return "", fmt.Errorf("no successful URL found in %d decoded URLs", len(decodedArr))
+	return source{}, fmt.Errorf("no successful URL found in %d decoded URLs", len(decodedArr))
 }
 
 // processAndDeduplicateStreamURLs processes a decoded URL string by splitting it,
@@ -192,13 +244,20 @@ func processAndDeduplicateStreamURLs(decodedURL string) []string {
 	return uniqueURLs
 }
 func (o ResolveOptions) ResolveStreamVariants() ([]StreamVariant, error) {
-	masterURL, err := o.ResolveVariants()
+	src, err := o.resolve()
 	if err != nil {
 		return nil, err
 	}
+	masterURL := src.master
 	logDebug("Fetching master playlist")
 
-	resp, err := client.Get(masterURL)
+	header := PlaybackHeader()
+	req, err := http.NewRequest("GET", masterURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request for master playlist %q: %w", masterURL, err)
+	}
+	req.Header = header.Clone()
+	resp, err := client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("fetching master playlist %q: %w", masterURL, err)
 	}
@@ -215,11 +274,12 @@ func (o ResolveOptions) ResolveStreamVariants() ([]StreamVariant, error) {
 
 	lines := strings.Split(string(body), "\n")
 	var variants []StreamVariant
+	tracks := append(src.subtitles, renditionSubtitles(lines, masterURL)...)
 
 	for i, line := range lines {
 		line = strings.TrimSpace(line)
 		if strings.HasPrefix(line, "#EXT-X-STREAM-INF") {
-			attrs := parseAttributes(line)
+			attrs := hls.ParseAttributes(strings.TrimPrefix(line, "#EXT-X-STREAM-INF:"))
 			resolution := attrs["RESOLUTION"]
 			bandwidth := attrs["BANDWIDTH"]
 			if i+1 < len(lines) {
@@ -229,7 +289,12 @@ func (o ResolveOptions) ResolveStreamVariants() ([]StreamVariant, error) {
 					variant := StreamVariant{
 						Resolution: resolution,
 						Bandwidth:  bandwidth,
+						Codecs:     attrs["CODECS"],
 						URL:        abs,
+						MasterURL:  masterURL,
+						Mirrors:    src.mirrors,
+						Header:     header,
+						Subtitles:  tracks,
 					}
 					variants = append(variants, variant)
 					logDebug("Found variant: %s, %s", resolution, bandwidth)
@@ -246,18 +311,58 @@ func (o ResolveOptions) ResolveStreamVariants() ([]StreamVariant, error) {
 	return variants, nil
 }
 
-func parseAttributes(line string) map[string]string {
-	attrs := map[string]string{}
-	parts := strings.Split(line, ",")
-	for _, part := range parts {
-		if strings.Contains(part, "=") {
-			kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
-			key := kv[0]
-			val := strings.Trim(kv[1], "\"")
-			attrs[key] = val
-		}
+// playerjsSubtitlesPattern matches the Playerjs subtitle option.
+var playerjsSubtitlesPattern = regexp.MustCompile(`subtitle:\s*"([^"]*)"`)
+
+// playerjsSubtitlePattern matches one "[Label]URL" entry of the Playerjs
+// subtitle option.
+var playerjsSubtitlePattern = regexp.MustCompile(`\[([^\]]*)\]\s*(https?://[^,\s"]+)`)
+
+// extractSubtitles returns the tracks listed in the Playerjs config as
+// `subtitle: "[English]https://...vtt,[Spanish]https://...srt"`.
+func extractSubtitles(proRCPHTML string) []subtitles.Track {
+	match := playerjsSubtitlesPattern.FindStringSubmatch(proRCPHTML)
+	if len(match) < 2 {
+		return nil
 	}
-	return attrs
+
+	var tracks []subtitles.Track
+	for _, m := range playerjsSubtitlePattern.FindAllStringSubmatch(match[1], -1) {
+		tracks = append(tracks, subtitles.Track{
+			Label:    m[1],
+			Language: subtitles.LanguageCode(m[1]),
+			URL:      m[2],
+		})
+	}
+	logDebug("Found %d subtitle track(s) in player config", len(tracks))
+	return tracks
+}
+
+// renditionSubtitles returns the EXT-X-MEDIA subtitle renditions of a master
+// playlist.
+func renditionSubtitles(lines []string, masterURL string) []subtitles.Track {
+	var tracks []subtitles.Track
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "#EXT-X-MEDIA:") {
+			continue
+		}
+		attrs := hls.ParseAttributes(strings.TrimPrefix(line, "#EXT-X-MEDIA:"))
+		if attrs["TYPE"] != "SUBTITLES" || attrs["URI"] == "" {
+			continue
+		}
+		language := subtitles.LanguageCode(attrs["LANGUAGE"])
+		if language == "" {
+			language = subtitles.LanguageCode(attrs["NAME"])
+		}
+		tracks = append(tracks, subtitles.Track{
+			Label:    attrs["NAME"],
+			Language: language,
+			URL:      resolveRelativeURL(masterURL, attrs["URI"]),
+			Playlist: true,
+		})
+	}
+	return tracks
 }
 
 func resolveRelativeURL(baseStr, refStr string) string {
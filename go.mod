@@ -6,6 +6,7 @@ require (
 	github.com/PuerkitoBio/goquery v1.11.0
 	github.com/StalkR/imdb v1.0.17
 	github.com/ktr0731/go-fuzzyfinder v0.9.0
+	golang.org/x/text v0.31.0
 )
 
 require (
@@ -21,5 +22,4 @@ require (
 	golang.org/x/net v0.47.0 // indirect
 	golang.org/x/sys v0.38.0 // indirect
 	golang.org/x/term v0.37.0 // indirect
-	golang.org/x/text v0.31.0 // indirect
 )
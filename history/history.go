@@ -0,0 +1,201 @@
+package history
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"kino/internal/jsonstore"
+	"kino/internal/xdg"
+)
+
+const fileName = "history.json"
+
+// Entry is a single playback of a movie or episode.
+type Entry struct {
+	ID        int           `json:"id"`
+	IMDbID    string        `json:"imdb_id"`
+	Title     string        `json:"title"`
+	Season    int           `json:"season,omitempty"`
+	Episode   int           `json:"episode,omitempty"`
+	Provider  string        `json:"provider"`
+	Variant   string        `json:"variant"`
+	StartedAt time.Time     `json:"started_at"`
+	Position  time.Duration `json:"position"`
+	Duration  time.Duration `json:"duration"`
+	Finished  bool          `json:"finished,omitempty"`
+}
+
+// DisplayTitle returns the title with an SxxEyy suffix for episodes.
+func (e Entry) DisplayTitle() string {
+	if e.Season > 0 && e.Episode > 0 {
+		return fmt.Sprintf("%s - S%02dE%02d", e.Title, e.Season, e.Episode)
+	}
+	return e.Title
+}
+
+type file struct {
+	NextID  int     `json:"next_id"`
+	Entries []Entry `json:"entries"`
+}
+
+// Store is the watch history persisted as JSON in the XDG data directory.
+// Every operation re-reads the file under a lock, so several kino processes
+// can share it.
+type Store struct {
+	path string
+}
+
+// Open returns the store in the default data directory.
+func Open() (*Store, error) {
+	dir, err := xdg.DataDir()
+	if err != nil {
+		return nil, err
+	}
+	return OpenFile(filepath.Join(dir, fileName)), nil
+}
+
+// OpenFile returns a store backed by the given file.
+func OpenFile(path string) *Store {
+	return &Store{path: path}
+}
+
+// Add records a new entry and returns it with its ID assigned.
+func (s *Store) Add(e Entry) (Entry, error) {
+	var f file
+	err := jsonstore.Update(s.path, &f, func() error {
+		f.NextID++
+		e.ID = f.NextID
+		f.Entries = append(f.Entries, e)
+		return nil
+	})
+	if err != nil {
+		return Entry{}, fmt.Errorf("adding history entry: %w", err)
+	}
+	return e, nil
+}
+
+// Update applies fn to the entry with the given ID.
+func (s *Store) Update(id int, fn func(*Entry)) error {
+	var f file
+	err := jsonstore.Update(s.path, &f, func() error {
+		for i := range f.Entries {
+			if f.Entries[i].ID == id {
+				fn(&f.Entries[i])
+				return nil
+			}
+		}
+		return fmt.Errorf("no history entry with id %d", id)
+	})
+	if err != nil {
+		return fmt.Errorf("updating history entry: %w", err)
+	}
+	return nil
+}
+
+// List returns all entries, most recent first.
+func (s *Store) List() ([]Entry, error) {
+	var f file
+	if err := jsonstore.View(s.path, &f); err != nil {
+		return nil, fmt.Errorf("reading history: %w", err)
+	}
+
+	sort.SliceStable(f.Entries, func(i, j int) bool {
+		return f.Entries[i].StartedAt.After(f.Entries[j].StartedAt)
+	})
+	return f.Entries, nil
+}
+
+// Last returns the most recent entry for a movie (season and episode 0) or
+// episode, regardless of the provider or variant it was played from.
+func (s *Store) Last(imdbID string, season, episode int) (Entry, bool, error) {
+	entries, err := s.List()
+	if err != nil {
+		return Entry{}, false, err
+	}
+
+	for _, e := range entries {
+		if e.IMDbID == imdbID && e.Season == season && e.Episode == episode {
+			return e, true, nil
+		}
+	}
+	return Entry{}, false, nil
+}
+
+// Latest returns the most recent entry of each movie or show, most recent first.
+func (s *Store) Latest() ([]Entry, error) {
+	entries, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var latest []Entry
+	for _, e := range entries {
+		if seen[e.IMDbID] {
+			continue
+		}
+		seen[e.IMDbID] = true
+		latest = append(latest, e)
+	}
+	return latest, nil
+}
+
+// Search returns entries whose title or IMDb ID contains query, most recent first.
+func (s *Store) Search(query string) ([]Entry, error) {
+	entries, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+
+	query = strings.ToLower(query)
+	var matches []Entry
+	for _, e := range entries {
+		if strings.Contains(strings.ToLower(e.Title), query) || strings.Contains(e.IMDbID, query) {
+			matches = append(matches, e)
+		}
+	}
+	return matches, nil
+}
+
+// Delete removes the entries with the given IDs and reports how many were removed.
+func (s *Store) Delete(ids ...int) (int, error) {
+	remove := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		remove[id] = true
+	}
+
+	var f file
+	removed := 0
+	err := jsonstore.Update(s.path, &f, func() error {
+		kept := f.Entries[:0]
+		for _, e := range f.Entries {
+			if remove[e.ID] {
+				removed++
+				continue
+			}
+			kept = append(kept, e)
+		}
+		f.Entries = kept
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("deleting history entries: %w", err)
+	}
+	return removed, nil
+}
+
+// Clear removes every entry.
+func (s *Store) Clear() error {
+	var f file
+	err := jsonstore.Update(s.path, &f, func() error {
+		f.Entries = nil
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("clearing history: %w", err)
+	}
+	return nil
+}
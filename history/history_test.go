@@ -0,0 +1,126 @@
+package history
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// writerEnv makes the test binary act as a separate kino process adding
+// entries to the history file it names, for TestConcurrentProcesses.
+const writerEnv = "KINO_HISTORY_TEST_WRITER"
+
+func TestMain(m *testing.M) {
+	if path := os.Getenv(writerEnv); path != "" {
+		n, _ := strconv.Atoi(os.Getenv(writerEnv + "_COUNT"))
+		if err := addEntries(OpenFile(path), os.Getpid(), n); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func addEntries(s *Store, writer, n int) error {
+	for i := 0; i < n; i++ {
+		if _, err := s.Add(Entry{IMDbID: fmt.Sprintf("tt%d", writer), Title: fmt.Sprintf("writer %d entry %d", writer, i)}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkEntries fails unless s holds want entries with distinct IDs and
+// titles.
+func checkEntries(t *testing.T, s *Store, want int) {
+	t.Helper()
+	entries, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != want {
+		t.Fatalf("history has %d entries, want %d", len(entries), want)
+	}
+	ids := make(map[int]bool)
+	titles := make(map[string]bool)
+	for _, e := range entries {
+		if ids[e.ID] || titles[e.Title] {
+			t.Errorf("entry %d (%s) is duplicated", e.ID, e.Title)
+		}
+		ids[e.ID], titles[e.Title] = true, true
+	}
+}
+
+func TestConcurrentWriters(t *testing.T) {
+	const writers, each = 8, 25
+	s := OpenFile(filepath.Join(t.TempDir(), fileName))
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Each writer opens the store itself, as separate commands do.
+			if err := addEntries(OpenFile(s.path), w, each); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	checkEntries(t, s, writers*each)
+}
+
+func TestConcurrentProcesses(t *testing.T) {
+	const processes, each = 4, 25
+	path := filepath.Join(t.TempDir(), fileName)
+
+	cmds := make([]*exec.Cmd, processes)
+	for i := range cmds {
+		cmds[i] = exec.Command(os.Args[0], "-test.run=^$")
+		cmds[i].Env = append(os.Environ(), writerEnv+"="+path, fmt.Sprintf("%s_COUNT=%d", writerEnv, each))
+		cmds[i].Stderr = os.Stderr
+		if err := cmds[i].Start(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, cmd := range cmds {
+		if err := cmd.Wait(); err != nil {
+			t.Errorf("writer process: %v", err)
+		}
+	}
+	checkEntries(t, OpenFile(path), processes*each)
+}
+
+func TestConcurrentUpdates(t *testing.T) {
+	s := OpenFile(filepath.Join(t.TempDir(), fileName))
+	e, err := s.Add(Entry{IMDbID: "tt1", Title: "Film"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const updates = 50
+	var wg sync.WaitGroup
+	for i := 0; i < updates; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.Update(e.ID, func(e *Entry) { e.Position++ }); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	entries, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Position != updates {
+		t.Errorf("after %d increments, entries = %+v", updates, entries)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"kino/history"
+	"kino/ui"
+)
+
+const historyUsage = `usage: kino history [list]
+       kino history search <query>
+       kino history delete <id>...
+       kino history clear`
+
+func runHistory(args []string) error {
+	store, err := history.Open()
+	if err != nil {
+		return err
+	}
+
+	cmd := "list"
+	if len(args) > 0 {
+		cmd, args = args[0], args[1:]
+	}
+
+	switch cmd {
+	case "list":
+		entries, err := store.List()
+		if err != nil {
+			return err
+		}
+		printHistory(entries)
+
+	case "search":
+		if len(args) == 0 {
+			return fmt.Errorf("missing search query\n%s", historyUsage)
+		}
+		entries, err := store.Search(args[0])
+		if err != nil {
+			return err
+		}
+		printHistory(entries)
+
+	case "delete":
+		if len(args) == 0 {
+			return fmt.Errorf("missing entry id\n%s", historyUsage)
+		}
+		ids := make([]int, len(args))
+		for i, arg := range args {
+			id, err := strconv.Atoi(arg)
+			if err != nil {
+				return fmt.Errorf("invalid entry id %q", arg)
+			}
+			ids[i] = id
+		}
+		removed, err := store.Delete(ids...)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Deleted %d entries.\n", removed)
+
+	case "clear":
+		if err := store.Clear(); err != nil {
+			return err
+		}
+		fmt.Println("History cleared.")
+
+	default:
+		return fmt.Errorf("unknown history command %q\n%s", cmd, historyUsage)
+	}
+
+	return nil
+}
+
+func printHistory(entries []history.Entry) {
+	if len(entries) == 0 {
+		fmt.Println("No history entries.")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tWATCHED\tTITLE\tPROGRESS\tVARIANT\tPROVIDER")
+	for _, e := range entries {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n",
+			e.ID,
+			e.StartedAt.Local().Format("2006-01-02 15:04"),
+			e.DisplayTitle(),
+			formatProgress(e.Position, e.Duration),
+			e.Variant,
+			e.Provider,
+		)
+	}
+	w.Flush()
+}
+
+func formatProgress(position, duration time.Duration) string {
+	if duration <= 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%s / %s", ui.FormatClock(position), ui.FormatClock(duration))
+}
@@ -0,0 +1,202 @@
+package hls
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"time"
+)
+
+const (
+	// maxAdRun is the longest run of segments taken for an ad break on
+	// account of its length alone.
+	maxAdRun = 90 * time.Second
+	// minAdProgramme is how long a playlist must be before short runs are
+	// considered, so clips and live windows are left alone.
+	minAdProgramme = 10 * time.Minute
+	// durationMismatch is how far, as a fraction, a run's typical segment
+	// duration must be from the programme's to count as different.
+	durationMismatch = 0.25
+)
+
+// adPathPattern matches segment paths used by ad servers.
+var adPathPattern = regexp.MustCompile(`(?i)(^|[/_.-])(ads?|adv|advert\w*|commercials?|preroll|midroll|postroll|adserver|doubleclick)([/_.-]|$)`)
+
+// AdBreak is a run of segments spliced into a playlist that DetectAds takes
+// for an ad.
+type AdBreak struct {
+	// First and Last index Segments, inclusive.
+	First, Last int
+	// Start is how far into the playlist the break starts.
+	Start    time.Duration
+	Duration time.Duration
+	// Reason says which heuristic matched, for tuning them.
+	Reason string
+}
+
+// DetectAds splits p into runs at discontinuities and returns the short runs
+// that look like ads: mostly segments on a path used by ad servers, served
+// from another host than the programme, or with segment durations that
+// differ from the programme's. The run holding most of the playlist is never
+// reported, nor are runs longer than maxAdRun.
+func DetectAds(p *MediaPlaylist) []AdBreak {
+	runs := discontinuityRuns(p.Segments)
+	if len(runs) < 2 {
+		return nil
+	}
+
+	programme := runs[0]
+	for _, r := range runs[1:] {
+		if r.duration > programme.duration {
+			programme = r
+		}
+	}
+	programmeHost := dominantHost(p.Segments)
+	programmeSegment := typicalDuration(p.Segments[programme.first : programme.last+1])
+	total := p.TotalDuration()
+
+	var breaks []AdBreak
+	for _, r := range runs {
+		if r == programme {
+			continue
+		}
+		segments := p.Segments[r.first : r.last+1]
+		reason := ""
+		switch {
+		case r.duration <= maxAdRun && adPath(segments):
+			reason = "ad path"
+		case programmeHost != "" && r.duration <= maxAdRun && !sameHost(segments, programmeHost):
+			reason = "different host"
+		case total >= minAdProgramme && r.duration <= maxAdRun &&
+			mismatched(typicalDuration(segments), programmeSegment):
+			reason = fmt.Sprintf("short run with %s segments", typicalDuration(segments).Round(10*time.Millisecond))
+		}
+		if reason != "" {
+			breaks = append(breaks, AdBreak{First: r.first, Last: r.last, Start: r.start, Duration: r.duration, Reason: reason})
+		}
+	}
+	return breaks
+}
+
+// WithoutAds returns a copy of p without the segments in breaks. The
+// segment after each removed break starts a discontinuity.
+func (p *MediaPlaylist) WithoutAds(breaks []AdBreak) *MediaPlaylist {
+	if len(breaks) == 0 {
+		return p
+	}
+	out := *p
+	out.Segments = nil
+	skipped := false
+	for i, seg := range p.Segments {
+		if inBreak(breaks, i) {
+			skipped = true
+			continue
+		}
+		if skipped {
+			seg.Discontinuity = true
+			skipped = false
+		}
+		out.Segments = append(out.Segments, seg)
+	}
+	return &out
+}
+
+func inBreak(breaks []AdBreak, i int) bool {
+	for _, b := range breaks {
+		if i >= b.First && i <= b.Last {
+			return true
+		}
+	}
+	return false
+}
+
+func (b AdBreak) String() string {
+	return fmt.Sprintf("ad break at %s: %d segments, %s (%s)",
+		b.Start.Round(time.Second), b.Last-b.First+1, b.Duration.Round(time.Second), b.Reason)
+}
+
+type run struct {
+	first, last     int
+	start, duration time.Duration
+}
+
+// discontinuityRuns splits segments wherever a discontinuity starts.
+func discontinuityRuns(segments []Segment) []run {
+	var runs []run
+	var start time.Duration
+	for i, seg := range segments {
+		if i == 0 || seg.Discontinuity {
+			runs = append(runs, run{first: i, last: i, start: start})
+		}
+		r := &runs[len(runs)-1]
+		r.last = i
+		r.duration += seg.Duration
+		start += seg.Duration
+	}
+	return runs
+}
+
+func adPath(segments []Segment) bool {
+	matched := 0
+	for _, seg := range segments {
+		if u, err := url.Parse(seg.URI); err == nil && adPathPattern.MatchString(u.Path) {
+			matched++
+		}
+	}
+	return matched*2 > len(segments)
+}
+
+// dominantHost returns the host serving the most playback time.
+func dominantHost(segments []Segment) string {
+	byHost := make(map[string]time.Duration)
+	for _, seg := range segments {
+		byHost[segmentHost(seg)] += seg.Duration
+	}
+	host, most := "", time.Duration(-1)
+	for h, d := range byHost {
+		if d > most || d == most && h < host {
+			host, most = h, d
+		}
+	}
+	return host
+}
+
+func sameHost(segments []Segment, host string) bool {
+	for _, seg := range segments {
+		if segmentHost(seg) != host {
+			return false
+		}
+	}
+	return true
+}
+
+func segmentHost(seg Segment) string {
+	u, err := url.Parse(seg.URI)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
+// typicalDuration returns the median segment duration. The last segment of
+// a run is usually cut short, so it is left out when there are others.
+func typicalDuration(segments []Segment) time.Duration {
+	if len(segments) > 1 {
+		segments = segments[:len(segments)-1]
+	}
+	durations := make([]time.Duration, len(segments))
+	for i, seg := range segments {
+		durations[i] = seg.Duration
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	return durations[len(durations)/2]
+}
+
+func mismatched(d, want time.Duration) bool {
+	if want <= 0 {
+		return false
+	}
+	diff := float64(d-want) / float64(want)
+	return diff > durationMismatch || diff < -durationMismatch
+}
@@ -0,0 +1,115 @@
+package hls
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testRun is a run of count segments of the same duration under prefix, a
+// host and path.
+type testRun struct {
+	prefix   string
+	count    int
+	duration time.Duration
+}
+
+// playlistOf builds a playlist of runs separated by discontinuities.
+func playlistOf(runs ...testRun) *MediaPlaylist {
+	p := &MediaPlaylist{EndList: true}
+	for _, r := range runs {
+		for i := 0; i < r.count; i++ {
+			p.Segments = append(p.Segments, Segment{
+				URI:           fmt.Sprintf("https://%s/seg%d.ts", r.prefix, len(p.Segments)),
+				Duration:      r.duration,
+				Sequence:      len(p.Segments),
+				Discontinuity: i == 0 && len(p.Segments) > 0,
+			})
+		}
+	}
+	return p
+}
+
+func TestDetectAds(t *testing.T) {
+	const s = time.Second
+	tests := []struct {
+		name     string
+		playlist *MediaPlaylist
+		want     []string // reasons, one per break
+	}{
+		{
+			name: "ad path",
+			playlist: playlistOf(
+				testRun{"cdn.example/film", 100, 6 * s},
+				testRun{"cdn.example/ads", 5, 6 * s},
+				testRun{"cdn.example/film", 100, 6 * s},
+			),
+			want: []string{"ad path"},
+		},
+		{
+			name: "long run on an ad path",
+			playlist: playlistOf(
+				testRun{"cdn.example/film", 100, 6 * s},
+				testRun{"cdn.example/ads", 20, 6 * s},
+				testRun{"cdn.example/film", 100, 6 * s},
+			),
+		},
+		{
+			name: "one segment on an ad path",
+			playlist: func() *MediaPlaylist {
+				p := playlistOf(
+					testRun{"cdn.example/film", 100, 6 * s},
+					testRun{"cdn.example/film", 10, 6 * s},
+					testRun{"cdn.example/film", 100, 6 * s},
+				)
+				p.Segments[105].URI = "https://cdn.example/film/recap_ad.ts"
+				return p
+			}(),
+		},
+		{
+			name: "short run on another host",
+			playlist: playlistOf(
+				testRun{"cdn.example/film", 100, 6 * s},
+				testRun{"other.example/x", 5, 6 * s},
+				testRun{"cdn.example/film", 100, 6 * s},
+			),
+			want: []string{"different host"},
+		},
+		{
+			name: "programme moved to a second host",
+			playlist: playlistOf(
+				testRun{"cdn1.example/film", 300, 6 * s},
+				testRun{"cdn2.example/film", 200, 6 * s},
+			),
+		},
+		{
+			name: "short run with other segment durations",
+			playlist: playlistOf(
+				testRun{"cdn.example/film", 100, 6 * s},
+				testRun{"cdn.example/film", 15, 2 * s},
+				testRun{"cdn.example/film", 100, 6 * s},
+			),
+			want: []string{"short run with 2s segments"},
+		},
+		{
+			name: "chapter with the same segments",
+			playlist: playlistOf(
+				testRun{"cdn.example/film", 100, 6 * s},
+				testRun{"cdn.example/film", 10, 6 * s},
+				testRun{"cdn.example/film", 100, 6 * s},
+			),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, b := range DetectAds(tt.playlist) {
+				got = append(got, b.Reason)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DetectAds = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package hls
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MediaPlaylist is a parsed HLS media playlist.
+type MediaPlaylist struct {
+	TargetDuration time.Duration
+	MediaSequence  int
+	Segments       []Segment
+	EndList        bool
+}
+
+// Segment is a single media segment.
+type Segment struct {
+	// URI is absolute, resolved against the playlist URL.
+	URI      string
+	Duration time.Duration
+	// Sequence is the segment's media sequence number.
+	Sequence int
+	// Discontinuity is set when an EXT-X-DISCONTINUITY tag precedes the segment.
+	Discontinuity bool
+	// Key is the encryption in effect for the segment, or nil if it is clear.
+	Key *Key
+}
+
+// Encryption methods of EXT-X-KEY.
+const (
+	MethodAES128    = "AES-128"
+	MethodSampleAES = "SAMPLE-AES"
+)
+
+// Key is an EXT-X-KEY tag.
+type Key struct {
+	Method string
+	// URI is absolute, resolved against the playlist URL.
+	URI string
+	// IV is nil when the tag has none and the media sequence number should be used.
+	IV        []byte
+	KeyFormat string
+}
+
+// SequenceIV returns the IV used for a segment whose key has no explicit IV:
+// its media sequence number as a big-endian 128-bit integer.
+func SequenceIV(sequence int) []byte {
+	iv := make([]byte, 16)
+	binary.BigEndian.PutUint64(iv[8:], uint64(sequence))
+	return iv
+}
+
+func parseKey(attrList string, base *url.URL) (*Key, error) {
+	attrs := ParseAttributes(attrList)
+	method := attrs["METHOD"]
+	if method == "" || method == "NONE" {
+		return nil, nil
+	}
+
+	key := &Key{Method: method, KeyFormat: attrs["KEYFORMAT"]}
+	if uri := attrs["URI"]; uri != "" {
+		ref, err := url.Parse(uri)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key URI %q: %w", uri, err)
+		}
+		key.URI = base.ResolveReference(ref).String()
+	}
+	if iv := attrs["IV"]; iv != "" {
+		raw, err := hex.DecodeString(strings.TrimPrefix(strings.TrimPrefix(iv, "0x"), "0X"))
+		if err != nil || len(raw) != 16 {
+			return nil, fmt.Errorf("invalid key IV %q", iv)
+		}
+		key.IV = raw
+	}
+	return key, nil
+}
+
+// TotalDuration returns the sum of the segment durations.
+func (p *MediaPlaylist) TotalDuration() time.Duration {
+	var total time.Duration
+	for _, s := range p.Segments {
+		total += s.Duration
+	}
+	return total
+}
+
+// ParseMedia parses a media playlist fetched from playlistURL.
+func ParseMedia(r io.Reader, playlistURL string) (*MediaPlaylist, error) {
+	base, err := url.Parse(playlistURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing playlist URL %q: %w", playlistURL, err)
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	p := &MediaPlaylist{}
+	var pending Segment
+	var key *Key
+	sawHeader := false
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+			continue
+
+		case line == "#EXTM3U":
+			sawHeader = true
+
+		case strings.HasPrefix(line, "#EXT-X-TARGETDURATION:"):
+			secs, err := strconv.Atoi(strings.TrimPrefix(line, "#EXT-X-TARGETDURATION:"))
+			if err == nil {
+				p.TargetDuration = time.Duration(secs) * time.Second
+			}
+
+		case strings.HasPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"):
+			seq, err := strconv.Atoi(strings.TrimPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"))
+			if err == nil {
+				p.MediaSequence = seq
+			}
+
+		case strings.HasPrefix(line, "#EXTINF:"):
+			value := strings.TrimPrefix(line, "#EXTINF:")
+			value, _, _ = strings.Cut(value, ",")
+			secs, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid EXTINF %q", line)
+			}
+			pending.Duration = time.Duration(secs * float64(time.Second))
+
+		case strings.HasPrefix(line, "#EXT-X-KEY:"):
+			key, err = parseKey(strings.TrimPrefix(line, "#EXT-X-KEY:"), base)
+			if err != nil {
+				return nil, err
+			}
+
+		case line == "#EXT-X-DISCONTINUITY":
+			pending.Discontinuity = true
+
+		case line == "#EXT-X-ENDLIST":
+			p.EndList = true
+
+		case strings.HasPrefix(line, "#EXT-X-STREAM-INF"):
+			return nil, fmt.Errorf("%q is a master playlist, not a media playlist", playlistURL)
+
+		case strings.HasPrefix(line, "#"):
+			// Unsupported tags and comments.
+
+		default:
+			ref, err := url.Parse(line)
+			if err != nil {
+				return nil, fmt.Errorf("invalid segment URI %q: %w", line, err)
+			}
+			pending.URI = base.ResolveReference(ref).String()
+			pending.Sequence = p.MediaSequence + len(p.Segments)
+			pending.Key = key
+			p.Segments = append(p.Segments, pending)
+			pending = Segment{}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading playlist: %w", err)
+	}
+	if !sawHeader {
+		return nil, fmt.Errorf("%q is not an HLS playlist", playlistURL)
+	}
+
+	return p, nil
+}
+
+// ParseAttributes parses an attribute list such as
+// `BANDWIDTH=800000,CODECS="avc1.4d401f,mp4a.40.2"`. Quoted values may
+// contain commas.
+func ParseAttributes(list string) map[string]string {
+	attrs := map[string]string{}
+	for list != "" {
+		key, rest, ok := strings.Cut(list, "=")
+		if !ok {
+			break
+		}
+		key = strings.TrimSpace(key)
+
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+			_, rest, _ = strings.Cut(rest, ",")
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+
+		attrs[key] = value
+		list = rest
+	}
+	return attrs
+}
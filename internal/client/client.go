@@ -5,9 +5,10 @@ import (
 	"time"
 )
 
+// UserAgent is the browser user-agent sent instead of Go's default.
 // IMDb deployed awswaf and denies requests using the default Go user-agent (Go-http-client/1.1).
 // For now it still allows requests from a browser user-agent. Remain respectful, no spam, etc.
-const userAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/107.0.0.0 Safari/537.36"
+const UserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/107.0.0.0 Safari/537.36"
 
 type customTransport struct {
 	http.RoundTripper
@@ -19,7 +20,7 @@ func (e *customTransport) RoundTrip(r *http.Request) (*http.Response, error) {
 
 	// avoid IP-based language detection
 	r.Header.Set("Accept-Language", "en")
-	r.Header.Set("User-Agent", userAgent)
+	r.Header.Set("User-Agent", UserAgent)
 
 	return e.RoundTripper.RoundTrip(r)
 }
@@ -38,3 +39,24 @@ func NewWithTimeout(timeout time.Duration) *http.Client {
 		Transport: &customTransport{http.DefaultTransport},
 	}
 }
+
+// mediaTransport sets the browser user-agent without the IMDb throttling, for
+// fetching playlists and media segments from CDNs.
+type mediaTransport struct {
+	http.RoundTripper
+}
+
+func (e *mediaTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.Header.Get("User-Agent") == "" {
+		r.Header.Set("User-Agent", UserAgent)
+	}
+	return e.RoundTripper.RoundTrip(r)
+}
+
+// NewMedia returns a new http.Client for streaming media with a timeout.
+func NewMedia(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: &mediaTransport{http.DefaultTransport},
+	}
+}
@@ -0,0 +1,93 @@
+package filelock
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	lockRetryInterval = 50 * time.Millisecond
+	staleLockAge      = 30 * time.Second
+)
+
+// lockFile blocks until it holds the O_EXCL lock file at path. Lock files
+// stand in for flock where it is unavailable; they build everywhere so they
+// are tested everywhere.
+func lockFile(path string) (func(), error) {
+	for {
+		unlock, ok, err := tryLockFile(path)
+		if err != nil || ok {
+			return unlock, err
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+// tryLockFile creates the lock file at path if nobody else holds it. ok is
+// false if the lock is taken. The lock file holds the owner's PID and is
+// touched while held, so a lock left behind by a crashed process can be told
+// apart from a long-held one.
+func tryLockFile(path string) (unlock func(), ok bool, err error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, false, fmt.Errorf("locking %s: %w", path, err)
+		}
+		if stale(path) {
+			os.Remove(path)
+			return tryLockFile(path)
+		}
+		return nil, false, nil
+	}
+	_, err = f.WriteString(strconv.Itoa(os.Getpid()))
+	f.Close()
+	if err != nil {
+		os.Remove(path)
+		return nil, false, fmt.Errorf("locking %s: %w", path, err)
+	}
+
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(staleLockAge / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case now := <-ticker.C:
+				os.Chtimes(path, now, now)
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		os.Remove(path)
+	}, true, nil
+}
+
+// stale reports whether the lock file at path was left behind: it has not
+// been touched for staleLockAge and the process that wrote it is gone. A
+// lock whose owner is still running is kept, however slow it is.
+func stale(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil || time.Since(info.ModTime()) <= staleLockAge {
+		return false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		// Nothing to check it against; the owner died before writing its
+		// PID or is from a version that did not.
+		return true
+	}
+	return !running(pid)
+}
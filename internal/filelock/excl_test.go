@@ -0,0 +1,107 @@
+package filelock
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTryLockFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.lock")
+	unlock, ok, err := tryLockFile(path)
+	if err != nil || !ok {
+		t.Fatalf("first lock: ok=%v, %v", ok, err)
+	}
+	data, _ := os.ReadFile(path)
+	if string(data) != strconv.Itoa(os.Getpid()) {
+		t.Errorf("lock file holds %q, want this process's PID", data)
+	}
+	if _, ok, err := tryLockFile(path); err != nil || ok {
+		t.Fatalf("second lock while held: ok=%v, %v", ok, err)
+	}
+	unlock()
+	unlock, ok, err = tryLockFile(path)
+	if err != nil || !ok {
+		t.Fatalf("lock after unlock: ok=%v, %v", ok, err)
+	}
+	unlock()
+}
+
+// exitedPID returns the PID of a process that has already exited.
+func exitedPID(t *testing.T) int {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	return cmd.Process.Pid
+}
+
+func TestStaleLockFile(t *testing.T) {
+	old := time.Now().Add(-2 * staleLockAge)
+	tests := []struct {
+		name     string
+		contents string
+		modified time.Time
+		broken   bool
+	}{
+		{"owner exited", strconv.Itoa(exitedPID(t)), old, true},
+		{"owner running", strconv.Itoa(os.Getpid()), old, false},
+		{"no PID", "", old, true},
+		{"touched recently", strconv.Itoa(exitedPID(t)), time.Now(), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "test.lock")
+			if err := os.WriteFile(path, []byte(tt.contents), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(path, tt.modified, tt.modified); err != nil {
+				t.Fatal(err)
+			}
+
+			unlock, ok, err := tryLockFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != tt.broken {
+				t.Errorf("took the lock = %v, want %v", ok, tt.broken)
+			}
+			if ok {
+				unlock()
+			}
+		})
+	}
+}
+
+func TestLockFileWaits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.lock")
+	unlock, ok, err := tryLockFile(path)
+	if err != nil || !ok {
+		t.Fatalf("first lock: ok=%v, %v", ok, err)
+	}
+
+	locked := make(chan func())
+	go func() {
+		unlock, err := lockFile(path)
+		if err != nil {
+			t.Error(err)
+		}
+		locked <- unlock
+	}()
+	select {
+	case <-locked:
+		t.Fatal("lockFile returned while the lock was held")
+	case <-time.After(4 * lockRetryInterval):
+	}
+	unlock()
+	select {
+	case unlock := <-locked:
+		unlock()
+	case <-time.After(time.Second):
+		t.Fatal("lockFile did not take the released lock")
+	}
+}
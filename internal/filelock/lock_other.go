@@ -0,0 +1,28 @@
+//go:build !unix
+
+package filelock
+
+import "os"
+
+// Lock falls back to an O_EXCL lock file where flock is unavailable. Shared
+// and exclusive locks are both treated as exclusive.
+func Lock(path string, _ bool) (func(), error) {
+	return lockFile(path)
+}
+
+// TryLock takes the lock on path if nobody else holds it. ok is false if the
+// lock is taken.
+func TryLock(path string) (unlock func(), ok bool, err error) {
+	return tryLockFile(path)
+}
+
+// running reports whether a process with pid exists. Where that cannot be
+// told, the process is assumed to be running.
+func running(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
@@ -0,0 +1,87 @@
+package jsonstore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"kino/internal/filelock"
+)
+
+// View decodes the JSON file at path into v while holding a shared lock.
+// A missing file leaves v untouched.
+func View(path string, v any) error {
+	unlock, err := filelock.Lock(path+".lock", false)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return load(path, v)
+}
+
+// Update decodes the JSON file at path into v, calls fn and writes v back,
+// all while holding an exclusive lock so concurrent kino processes cannot
+// lose each other's writes. Nothing is written if fn returns an error.
+func Update(path string, v any, fn func() error) error {
+	unlock, err := filelock.Lock(path+".lock", true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := load(path, v); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		return err
+	}
+	return save(path, v)
+}
+
+func load(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", path, err)
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("decoding %s: %w", path, err)
+	}
+	return nil
+}
+
+// save writes to a temporary file and renames it over path, so readers never
+// observe a half-written file.
+func save(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding %s: %w", path, err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("creating temp file for %s: %w", path, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing %s: %w", tmp.Name(), err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("closing %s: %w", tmp.Name(), err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("replacing %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package xdg
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const appName = "kino"
+
+// DataDir returns kino's data directory ($XDG_DATA_HOME/kino), creating it if needed.
+func DataDir() (string, error) {
+	return appDir("XDG_DATA_HOME", ".local/share")
+}
+
+// StateDir returns kino's state directory ($XDG_STATE_HOME/kino), creating it if needed.
+func StateDir() (string, error) {
+	return appDir("XDG_STATE_HOME", ".local/state")
+}
+
+// ConfigDir returns kino's config directory ($XDG_CONFIG_HOME/kino), creating it if needed.
+func ConfigDir() (string, error) {
+	return appDir("XDG_CONFIG_HOME", ".config")
+}
+
+// CacheDir returns kino's cache directory ($XDG_CACHE_HOME/kino), creating it if needed.
+func CacheDir() (string, error) {
+	return appDir("XDG_CACHE_HOME", ".cache")
+}
+
+func appDir(env, fallback string) (string, error) {
+	base := os.Getenv(env)
+	// The spec says relative paths must be ignored.
+	if base == "" || !filepath.IsAbs(base) {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("resolving home directory: %w", err)
+		}
+		base = filepath.Join(home, fallback)
+	}
+
+	dir := filepath.Join(base, appName)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("creating %s: %w", dir, err)
+	}
+	return dir, nil
+}
@@ -0,0 +1,229 @@
+package library
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"kino/internal/jsonstore"
+	"kino/internal/xdg"
+)
+
+const fileName = "library.json"
+
+// Item is a downloaded movie or episode.
+type Item struct {
+	ID       int    `json:"id"`
+	Title    string `json:"title"`
+	IMDbID   string `json:"imdb_id"`
+	Season   int    `json:"season,omitempty"`
+	Episode  int    `json:"episode,omitempty"`
+	Variant  string `json:"variant,omitempty"`
+	Path     string `json:"path"`
+	Size     int64  `json:"size"`
+	Checksum string `json:"checksum"`
+	// Pinned items are never evicted to stay under the size cap.
+	Pinned bool `json:"pinned,omitempty"`
+	// AddedAt is when the download finished.
+	AddedAt time.Time `json:"added_at"`
+}
+
+// DisplayTitle returns the title with an SxxEyy suffix for episodes.
+func (i Item) DisplayTitle() string {
+	if i.Season > 0 && i.Episode > 0 {
+		return fmt.Sprintf("%s - S%02dE%02d", i.Title, i.Season, i.Episode)
+	}
+	return i.Title
+}
+
+type file struct {
+	NextID int    `json:"next_id"`
+	Items  []Item `json:"items"`
+}
+
+// Library is the index of downloaded files, kept in the XDG data directory.
+type Library struct {
+	path string
+}
+
+// Open returns the library in the default data directory.
+func Open() (*Library, error) {
+	dir, err := xdg.DataDir()
+	if err != nil {
+		return nil, err
+	}
+	return &Library{path: filepath.Join(dir, fileName)}, nil
+}
+
+// Add registers the file at item.Path, filling in its size and checksum. An
+// existing entry for the same path is replaced.
+func (l *Library) Add(item Item) (Item, error) {
+	abs, err := filepath.Abs(item.Path)
+	if err != nil {
+		return Item{}, fmt.Errorf("resolving %s: %w", item.Path, err)
+	}
+	item.Path = abs
+
+	info, err := os.Stat(abs)
+	if err != nil {
+		return Item{}, fmt.Errorf("adding to library: %w", err)
+	}
+	item.Size = info.Size()
+	if item.Checksum, err = Checksum(abs); err != nil {
+		return Item{}, err
+	}
+	if item.AddedAt.IsZero() {
+		item.AddedAt = time.Now()
+	}
+
+	var f file
+	err = jsonstore.Update(l.path, &f, func() error {
+		kept := f.Items[:0]
+		for _, existing := range f.Items {
+			if existing.Path == abs {
+				// A re-download keeps the old entry's pin.
+				item.Pinned = item.Pinned || existing.Pinned
+				continue
+			}
+			kept = append(kept, existing)
+		}
+		f.NextID++
+		item.ID = f.NextID
+		f.Items = append(kept, item)
+		return nil
+	})
+	if err != nil {
+		return Item{}, fmt.Errorf("adding to library: %w", err)
+	}
+	return item, nil
+}
+
+// List returns all items sorted by title, season and episode.
+func (l *Library) List() ([]Item, error) {
+	var f file
+	if err := jsonstore.View(l.path, &f); err != nil {
+		return nil, fmt.Errorf("reading library: %w", err)
+	}
+
+	sort.SliceStable(f.Items, func(i, j int) bool {
+		a, b := f.Items[i], f.Items[j]
+		if a.Title != b.Title {
+			return a.Title < b.Title
+		}
+		if a.Season != b.Season {
+			return a.Season < b.Season
+		}
+		return a.Episode < b.Episode
+	})
+	return f.Items, nil
+}
+
+// Find returns a local copy of a movie (season and episode 0) or episode
+// whose file still exists.
+func (l *Library) Find(imdbID string, season, episode int) (Item, bool, error) {
+	items, err := l.List()
+	if err != nil {
+		return Item{}, false, err
+	}
+
+	for _, item := range items {
+		if item.IMDbID != imdbID || item.Season != season || item.Episode != episode {
+			continue
+		}
+		if _, err := os.Stat(item.Path); err == nil {
+			return item, true, nil
+		}
+	}
+	return Item{}, false, nil
+}
+
+// Remove drops the items with the given IDs from the index, leaving their
+// files alone, and reports how many were removed.
+func (l *Library) Remove(ids ...int) (int, error) {
+	remove := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		remove[id] = true
+	}
+
+	var f file
+	removed := 0
+	err := jsonstore.Update(l.path, &f, func() error {
+		kept := f.Items[:0]
+		for _, item := range f.Items {
+			if remove[item.ID] {
+				removed++
+				continue
+			}
+			kept = append(kept, item)
+		}
+		f.Items = kept
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("removing library items: %w", err)
+	}
+	return removed, nil
+}
+
+// Problem is an item whose file is missing or no longer matches the index.
+type Problem struct {
+	Item   Item
+	Reason string
+}
+
+// Verify checks that every item's file exists with the recorded size and,
+// if checksums is set, the recorded checksum.
+func (l *Library) Verify(checksums bool) ([]Problem, error) {
+	items, err := l.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []Problem
+	for _, item := range items {
+		info, err := os.Stat(item.Path)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			problems = append(problems, Problem{item, "missing"})
+			continue
+		case err != nil:
+			problems = append(problems, Problem{item, err.Error()})
+			continue
+		case info.Size() != item.Size:
+			problems = append(problems, Problem{item, "size changed"})
+			continue
+		}
+
+		if checksums {
+			sum, err := Checksum(item.Path)
+			if err != nil {
+				problems = append(problems, Problem{item, err.Error()})
+			} else if sum != item.Checksum {
+				problems = append(problems, Problem{item, "checksum mismatch"})
+			}
+		}
+	}
+	return problems, nil
+}
+
+// Checksum returns the hex SHA-256 of the file at path.
+func Checksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("opening %s: %w", path, err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("hashing %s: %w", path, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
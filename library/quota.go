@@ -0,0 +1,155 @@
+package library
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"kino/internal/jsonstore"
+)
+
+// Watch is what the watch history says about an item.
+type Watch struct {
+	LastWatched time.Time
+	Finished    bool
+}
+
+// SetPinned pins or unpins the items with the given IDs and reports how many
+// were found.
+func (l *Library) SetPinned(pinned bool, ids ...int) (int, error) {
+	want := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		want[id] = true
+	}
+
+	var f file
+	changed := 0
+	err := jsonstore.Update(l.path, &f, func() error {
+		for i := range f.Items {
+			if want[f.Items[i].ID] {
+				f.Items[i].Pinned = pinned
+				changed++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("updating library: %w", err)
+	}
+	return changed, nil
+}
+
+// Size returns the total size of the items whose files still exist.
+func Size(items []Item) int64 {
+	var total int64
+	for _, item := range items {
+		if _, err := os.Stat(item.Path); err == nil {
+			total += item.Size
+		}
+	}
+	return total
+}
+
+// EvictionPlan returns the items to delete to bring the library down to
+// maxSize bytes. Only unpinned items that have been watched to the end are
+// candidates, least recently watched first. The returned size is what the
+// library would take up afterwards, which is still over maxSize if there
+// were not enough candidates.
+func (l *Library) EvictionPlan(maxSize int64, watched func(Item) Watch) ([]Item, int64, error) {
+	items, err := l.List()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	size := Size(items)
+	if size <= maxSize {
+		return nil, size, nil
+	}
+
+	type candidate struct {
+		item  Item
+		watch Watch
+	}
+	var candidates []candidate
+	for _, item := range items {
+		if item.Pinned {
+			continue
+		}
+		if _, err := os.Stat(item.Path); err != nil {
+			continue
+		}
+		if w := watched(item); w.Finished {
+			candidates = append(candidates, candidate{item, w})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].watch.LastWatched.Before(candidates[j].watch.LastWatched)
+	})
+
+	var plan []Item
+	for _, c := range candidates {
+		if size <= maxSize {
+			break
+		}
+		plan = append(plan, c.item)
+		size -= c.item.Size
+	}
+	return plan, size, nil
+}
+
+// Evict deletes the items' files along with their .nfo and subtitle
+// sidecars, and removes them from the index. Files that are already gone are
+// not an error.
+func (l *Library) Evict(items []Item) error {
+	ids := make([]int, 0, len(items))
+	var errs []error
+	for _, item := range items {
+		if err := os.Remove(item.Path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			errs = append(errs, err)
+			continue
+		}
+		ids = append(ids, item.ID)
+		for _, path := range sidecars(item.Path) {
+			if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	if _, err := l.Remove(ids...); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// sidecars returns the files saved next to the media file at path: its .nfo
+// and any "<name>.<lang>.srt" or .vtt subtitles.
+func sidecars(path string) []string {
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		return nil
+	}
+
+	base := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	var paths []string
+	for _, e := range entries {
+		name := e.Name()
+		rest, ok := strings.CutPrefix(name, base+".")
+		if !ok || e.IsDir() {
+			continue
+		}
+		switch ext := filepath.Ext(rest); {
+		case rest == "nfo":
+		case (ext == ".srt" || ext == ".vtt") && !strings.Contains(strings.TrimSuffix(rest, ext), "."):
+		default:
+			continue
+		}
+		paths = append(paths, filepath.Join(filepath.Dir(path), name))
+	}
+	return paths
+}
@@ -0,0 +1,173 @@
+package library
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestLibrary returns a library in a temporary directory holding one
+// 100-byte movie per title.
+func newTestLibrary(t *testing.T, titles ...string) (*Library, map[string]Item) {
+	t.Helper()
+	dir := t.TempDir()
+	l := &Library{path: filepath.Join(dir, fileName)}
+	items := make(map[string]Item)
+	for _, title := range titles {
+		path := filepath.Join(dir, title+".mp4")
+		if err := os.WriteFile(path, make([]byte, 100), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		item, err := l.Add(Item{Title: title, IMDbID: "tt" + title, Path: path})
+		if err != nil {
+			t.Fatal(err)
+		}
+		items[title] = item
+	}
+	return l, items
+}
+
+func TestEvictionPlan(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name     string
+		maxSize  int64
+		pinned   []string
+		missing  []string
+		finished map[string]time.Duration // title -> how long ago
+		unseen   map[string]time.Duration // watched recently but not to the end
+		want     []string
+		wantSize int64
+	}{
+		{
+			name:     "under the cap",
+			maxSize:  300,
+			finished: map[string]time.Duration{"a": time.Hour},
+			wantSize: 300,
+		},
+		{
+			name:     "least recently watched first",
+			maxSize:  200,
+			finished: map[string]time.Duration{"a": time.Hour, "b": 48 * time.Hour, "c": 24 * time.Hour},
+			want:     []string{"b"},
+			wantSize: 200,
+		},
+		{
+			name:     "as many as needed",
+			maxSize:  150,
+			finished: map[string]time.Duration{"a": time.Hour, "b": 48 * time.Hour, "c": 24 * time.Hour},
+			want:     []string{"b", "c"},
+			wantSize: 100,
+		},
+		{
+			name:     "pinned items are skipped",
+			maxSize:  200,
+			pinned:   []string{"b"},
+			finished: map[string]time.Duration{"a": time.Hour, "b": 48 * time.Hour, "c": 24 * time.Hour},
+			want:     []string{"c"},
+			wantSize: 200,
+		},
+		{
+			name:     "unfinished items are skipped",
+			maxSize:  200,
+			finished: map[string]time.Duration{"a": time.Hour},
+			unseen:   map[string]time.Duration{"b": 48 * time.Hour},
+			want:     []string{"a"},
+			wantSize: 200,
+		},
+		{
+			name:     "not enough candidates",
+			maxSize:  0,
+			pinned:   []string{"a"},
+			finished: map[string]time.Duration{"a": time.Hour, "b": 48 * time.Hour},
+			want:     []string{"b"},
+			wantSize: 200,
+		},
+		{
+			name:     "missing files do not count",
+			maxSize:  200,
+			missing:  []string{"c"},
+			finished: map[string]time.Duration{"a": time.Hour, "c": 48 * time.Hour},
+			wantSize: 200,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, items := newTestLibrary(t, "a", "b", "c")
+			for _, title := range tt.pinned {
+				if _, err := l.SetPinned(true, items[title].ID); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, title := range tt.missing {
+				if err := os.Remove(items[title].Path); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			plan, size, err := l.EvictionPlan(tt.maxSize, func(item Item) Watch {
+				if ago, ok := tt.finished[item.Title]; ok {
+					return Watch{LastWatched: now.Add(-ago), Finished: true}
+				}
+				if ago, ok := tt.unseen[item.Title]; ok {
+					return Watch{LastWatched: now.Add(-ago)}
+				}
+				return Watch{}
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, item := range plan {
+				got = append(got, item.Title)
+			}
+			if !reflect.DeepEqual(got, tt.want) || size != tt.wantSize {
+				t.Errorf("EvictionPlan(%d) = %q, %d; want %q, %d", tt.maxSize, got, size, tt.want, tt.wantSize)
+			}
+		})
+	}
+}
+
+func TestEvict(t *testing.T) {
+	l, items := newTestLibrary(t, "a", "a.2", "b")
+	dir := filepath.Dir(items["a"].Path)
+	for _, name := range []string{
+		"a.nfo", "a.en.srt", "a.pt-BR.vtt", // a's sidecars
+		"a.2.nfo", "a.notes.txt", "b.en.srt", "tvshow.nfo", // someone else's
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := l.Evict([]Item{items["a"]}); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var left []string
+	for _, e := range entries {
+		if !strings.HasPrefix(e.Name(), fileName) {
+			left = append(left, e.Name())
+		}
+	}
+	want := []string{"a.2.mp4", "a.2.nfo", "a.notes.txt", "b.en.srt", "b.mp4", "tvshow.nfo"}
+	if !reflect.DeepEqual(left, want) {
+		t.Errorf("left %q, want %q", left, want)
+	}
+
+	list, err := l.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slices.ContainsFunc(list, func(item Item) bool { return item.Title == "a" }) || len(list) != 2 {
+		t.Errorf("index after Evict = %+v", list)
+	}
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"kino/download"
+	"kino/history"
+	"kino/library"
+	"kino/player"
+	"kino/subtitles"
+	"kino/ui"
+)
+
+const libraryUsage = `usage: kino library            browse and play downloads
+       kino library list
+       kino library verify [-checksum] [-prune]
+       kino library remove <id>...
+       kino library pin|unpin <id>...
+       kino library evict [-dry-run]`
+
+// localProvider is recorded in the watch history for library playback.
+const localProvider = "local"
+
+func runLibrary(args []string) error {
+	lib, err := library.Open()
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 0 {
+		return browseLibrary(lib)
+	}
+
+	cmd, args := args[0], args[1:]
+	switch cmd {
+	case "list":
+		items, err := lib.List()
+		if err != nil {
+			return err
+		}
+		printLibrary(items)
+
+	case "verify":
+		fs := flag.NewFlagSet("library verify", flag.ContinueOnError)
+		checksums := fs.Bool("checksum", false, "Also compare file checksums (reads every file)")
+		prune := fs.Bool("prune", false, "Remove entries whose files are missing or changed")
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		return verifyLibrary(lib, *checksums, *prune)
+
+	case "remove":
+		ids, err := parseItemIDs(args)
+		if err != nil {
+			return err
+		}
+		removed, err := lib.Remove(ids...)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Removed %d items (files were left in place).\n", removed)
+
+	case "pin", "unpin":
+		ids, err := parseItemIDs(args)
+		if err != nil {
+			return err
+		}
+		changed, err := lib.SetPinned(cmd == "pin", ids...)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%sned %d items.\n", strings.ToUpper(cmd[:1])+cmd[1:], changed)
+
+	case "evict":
+		fs := flag.NewFlagSet("library evict", flag.ContinueOnError)
+		dryRun := fs.Bool("dry-run", false, "List what would be deleted without deleting it")
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		if cfg.Library.MaxSize == "" {
+			return fmt.Errorf("no library size cap is configured (library.max_size)")
+		}
+		return enforceLibraryQuota(lib, *dryRun)
+
+	default:
+		return fmt.Errorf("unknown library command %q\n%s", cmd, libraryUsage)
+	}
+
+	return nil
+}
+
+func parseItemIDs(args []string) ([]int, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("missing item id\n%s", libraryUsage)
+	}
+	ids := make([]int, len(args))
+	for i, arg := range args {
+		id, err := strconv.Atoi(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid item id %q", arg)
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
+
+func printLibrary(items []library.Item) {
+	if len(items) == 0 {
+		fmt.Println("The library is empty.")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTITLE\tVARIANT\tSIZE\tPINNED\tPATH")
+	for _, item := range items {
+		pinned := ""
+		if item.Pinned {
+			pinned = "yes"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n",
+			item.ID, item.DisplayTitle(), ui.FormatResolution(item.Variant), ui.FormatBytes(item.Size), pinned, item.Path)
+	}
+	w.Flush()
+	fmt.Printf("\nTotal: %s\n", ui.FormatBytes(library.Size(items)))
+}
+
+func verifyLibrary(lib *library.Library, checksums, prune bool) error {
+	problems, err := lib.Verify(checksums)
+	if err != nil {
+		return err
+	}
+	if len(problems) == 0 {
+		fmt.Println("All library files are present.")
+		return nil
+	}
+
+	ids := make([]int, len(problems))
+	for i, p := range problems {
+		fmt.Printf("%d\t%s\t%s (%s)\n", p.Item.ID, p.Item.DisplayTitle(), p.Item.Path, p.Reason)
+		ids[i] = p.Item.ID
+	}
+
+	if !prune {
+		fmt.Println("\nRun with -prune to remove these entries.")
+		return nil
+	}
+	removed, err := lib.Remove(ids...)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("\nRemoved %d stale entries.\n", removed)
+	return nil
+}
+
+// enforceLibraryQuota deletes watched downloads until the library fits in
+// library.max_size, or with dryRun only lists them.
+func enforceLibraryQuota(lib *library.Library, dryRun bool) error {
+	maxSize, err := download.ParseSize(cfg.Library.MaxSize)
+	if err != nil {
+		return fmt.Errorf("library.max_size: %w", err)
+	}
+
+	watches, err := watchHistory()
+	if err != nil {
+		return err
+	}
+	plan, size, err := lib.EvictionPlan(maxSize, func(item library.Item) library.Watch {
+		return watches[watchKey{item.IMDbID, item.Season, item.Episode}]
+	})
+	if err != nil {
+		return err
+	}
+
+	verb := "Deleting"
+	if dryRun {
+		verb = "Would delete"
+	}
+	for _, item := range plan {
+		fmt.Printf("%s %s (%s)\n", verb, item.DisplayTitle(), ui.FormatBytes(item.Size))
+	}
+	if size > maxSize {
+		fmt.Printf("The library is %s, over its %s cap; pin fewer items or watch some downloads.\n",
+			ui.FormatBytes(size), ui.FormatBytes(maxSize))
+	}
+	if dryRun || len(plan) == 0 {
+		return nil
+	}
+	return lib.Evict(plan)
+}
+
+type watchKey struct {
+	imdbID          string
+	season, episode int
+}
+
+// watchHistory summarises the watch history per title: when it was last
+// played and whether it was ever watched to the end.
+func watchHistory() (map[watchKey]library.Watch, error) {
+	store, err := history.Open()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+
+	watches := make(map[watchKey]library.Watch)
+	for _, e := range entries {
+		key := watchKey{e.IMDbID, e.Season, e.Episode}
+		w := watches[key]
+		if e.StartedAt.After(w.LastWatched) {
+			w.LastWatched = e.StartedAt
+		}
+		w.Finished = w.Finished || e.Finished
+		watches[key] = w
+	}
+	return watches, nil
+}
+
+// browseLibrary picks a downloaded title, then its season and episode, with
+// the same pickers as online search, and plays it.
+func browseLibrary(lib *library.Library) error {
+	items, err := lib.List()
+	if err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		return fmt.Errorf("the library is empty; use kino download first")
+	}
+
+	// One entry per title; the episode is chosen afterwards.
+	var titles []library.Item
+	seen := make(map[string]bool)
+	for _, item := range items {
+		if !seen[item.IMDbID] {
+			seen[item.IMDbID] = true
+			titles = append(titles, item)
+		}
+	}
+
+	selected, err := ui.SelectLibraryTitle(titles)
+	if err != nil {
+		return err
+	}
+
+	if selected.Season == 0 {
+		return playLibraryItem(*selected)
+	}
+
+	var episodes []library.Item
+	for _, item := range items {
+		if item.IMDbID == selected.IMDbID {
+			episodes = append(episodes, item)
+		}
+	}
+
+	for {
+		var seasons []int
+		for _, item := range episodes {
+			if len(seasons) == 0 || seasons[len(seasons)-1] != item.Season {
+				seasons = append(seasons, item.Season)
+			}
+		}
+		season, err := ui.SelectSeason(seasons)
+		if err != nil {
+			return err
+		}
+
+		var numbers []int
+		for _, item := range episodes {
+			if item.Season == season {
+				numbers = append(numbers, item.Episode)
+			}
+		}
+		episode, err := ui.SelectEpisode(numbers)
+		if err != nil {
+			if err.Error() == "abort" {
+				continue
+			}
+			return err
+		}
+
+		return handleStreamingSelection(fmt.Sprintf("%s/%d-%d", selected.IMDbID, season, episode), "")
+	}
+}
+
+func playLibraryItem(item library.Item) error {
+	if ok, err := playerReady(); !ok {
+		return err
+	}
+	_, err := playLocal(item)
+	return err
+}
+
+// localSubtitles returns the subtitle files saved next to a download in the
+// chosen languages. MP4 downloads carry theirs inside.
+func localSubtitles(item library.Item) []string {
+	var paths []string
+	for _, code := range languageCodes(subtitleLanguages()) {
+		for _, ext := range []string{".srt", ".vtt"} {
+			path := download.SidecarPath(item.Path, subtitles.File{Path: "sub" + ext, Language: code})
+			if _, err := os.Stat(path); err == nil {
+				paths = append(paths, path)
+				break
+			}
+		}
+	}
+	return paths
+}
+
+// findLocalCopy returns the library item for imdbID (as used by
+// handleStreamingSelection) if it has been downloaded.
+func findLocalCopy(imdbID string, season, episode int) (library.Item, bool) {
+	lib, err := library.Open()
+	if err != nil {
+		return library.Item{}, false
+	}
+	item, ok, err := lib.Find(strings.Split(imdbID, "/")[0], season, episode)
+	if err != nil {
+		log.Printf("Warning: Could not read library: %v", err)
+		return library.Item{}, false
+	}
+	return item, ok
+}
+
+// playLocal plays a downloaded file without touching the network and records
+// it in the watch history.
+func playLocal(item library.Item) (player.Status, error) {
+	fmt.Printf("\nPlaying %s from the library...\n", item.DisplayTitle())
+
+	backend, err := playerBackend()
+	if err != nil {
+		return player.Status{}, fmt.Errorf("failed to create player: %w", err)
+	}
+
+	opts := player.Options{
+		CacheSize: *cacheSize,
+		Start:     resumePosition(item.IMDbID, item.Season, item.Episode),
+	}
+
+	entryID := recordHistory(history.Entry{
+		IMDbID:    item.IMDbID,
+		Title:     item.Title,
+		Season:    item.Season,
+		Episode:   item.Episode,
+		Provider:  localProvider,
+		Variant:   item.Variant,
+		StartedAt: time.Now(),
+	})
+
+	media := player.Media{URL: item.Path, Title: item.DisplayTitle(), Subtitles: localSubtitles(item)}
+	status, err := backend.Play(context.Background(), media, opts)
+	updateHistory(entryID, status)
+	if err != nil {
+		return status, fmt.Errorf("failed to play %s: %w", item.Path, err)
+	}
+	return status, nil
+}
@@ -1,7 +1,8 @@
 package main
 
 import (
-	"bufio"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
@@ -10,8 +11,12 @@ import (
 	"os/signal"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
+	"time"
 
+	"kino/config"
+	"kino/history"
 	"kino/internal/client"
 	"kino/player"
 	"kino/stream"
@@ -21,12 +26,43 @@ import (
 )
 
 var cacheSize = flag.String("cache", "12MiB", "Cache size limit for mpv (e.g., 30MiB, 50MiB)")
+var playerName = flag.String("player", "", "Player to use: mpv, vlc, ffplay, command, or a command template like \"myplayer {url}\"")
+var subs = flag.String("subs", "", "Subtitle languages to load, e.g. en,de (default from config)")
+var noSubs = flag.Bool("no-subs", false, "Do not load subtitles")
+var quality = flag.String("quality", "", "Stream quality to play without asking: 720p, best, worst, <=720p or auto")
+
+// minResumePosition is how far into a title playback must have got before
+// kino offers to resume it.
+const minResumePosition = 30 * time.Second
+
+var cfg = config.Default()
+
+// debugf logs diagnostics for tuning heuristics when DEBUG=1 is set.
+func debugf(format string, v ...any) {
+	if os.Getenv("DEBUG") == "1" {
+		log.Printf("[DEBUG] "+format, v...)
+	}
+}
 
 func main() {
 	flag.Parse()
 
+	if loaded, err := config.Load(); err != nil {
+		log.Printf("Warning: Could not load config: %v", err)
+	} else {
+		cfg = loaded
+	}
+
 	client := client.New()
 
+	if run := subcommand(client, flag.Arg(0)); run != nil {
+		if err := run(flag.Args()[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	if flag.NArg() == 0 {
 		interactiveSearch(client)
 		return
@@ -50,7 +86,7 @@ func main() {
 		os.Exit(4)
 	}
 
-	finalID, err := handleTitleSelection(client, selectedTitle)
+	finalID, playSeason, err := handleTitleSelection(client, selectedTitle)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(5)
@@ -58,20 +94,65 @@ func main() {
 
 	fmt.Printf("\nSelected IMDb ID: %s\n\n", finalID)
 
-	err = handleStreamingSelection(finalID)
+	err = startPlayback(client, finalID, playSeason)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(6)
 	}
 }
 
+// subcommand returns the handler for a subcommand name, or nil if name is a search query.
+func subcommand(client *http.Client, name string) func(args []string) error {
+	switch name {
+	case "history":
+		return runHistory
+	case "continue":
+		return func([]string) error { return runContinue(client) }
+	case "download":
+		return func(args []string) error { return runDownload(client, args) }
+	case "downloads":
+		return runDownloads
+	case "library":
+		return runLibrary
+	case "proxy":
+		return func(args []string) error { return runProxy(client, args) }
+	case "subs":
+		return runSubs
+	default:
+		return nil
+	}
+}
+
 func interactiveSearch(client *http.Client) {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 
-	reader := bufio.NewReader(os.Stdin)
+	reader := ui.Stdin
 
 	for {
+		if hasContinueItems() {
+			continueWatching, err := ui.SelectMainMenu()
+			if err != nil {
+				fmt.Println("\nGoodbye!")
+				return
+			}
+			if continueWatching {
+				err := runContinue(client)
+				if err != nil {
+					if err.Error() == "abort" {
+						fmt.Println("Continue cancelled.")
+						fmt.Println()
+						continue
+					}
+					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+					continue
+				}
+				fmt.Println("Playback finished.")
+				fmt.Println()
+				continue
+			}
+		}
+
 		fmt.Print("Search kino: ")
 
 		input, err := reader.ReadString('\n')
@@ -112,7 +193,7 @@ func interactiveSearch(client *http.Client) {
 			continue
 		}
 
-		finalID, err := handleTitleSelection(client, selectedTitle)
+		finalID, playSeason, err := handleTitleSelection(client, selectedTitle)
 		if err != nil {
 			if err.Error() == "abort" {
 				fmt.Println("Selection cancelled.")
@@ -125,7 +206,7 @@ func interactiveSearch(client *http.Client) {
 
 		fmt.Printf("\nSelected IMDb ID: %s\n\n", finalID)
 
-		err = handleStreamingSelection(finalID)
+		err = startPlayback(client, finalID, playSeason)
 		if err != nil {
 			if err.Error() == "abort" {
 				fmt.Println("Streaming cancelled.")
@@ -141,17 +222,27 @@ func interactiveSearch(client *http.Client) {
 	}
 }
 
-func handleTitleSelection(client *http.Client, title *imdb.Title) (string, error) {
+// handleTitleSelection returns the ID to play for title and whether the rest
+// of the season from that episode should be queued.
+func handleTitleSelection(client *http.Client, title *imdb.Title) (string, bool, error) {
 	fullTitle, err := imdb.NewTitle(client, title.ID)
 	if err != nil {
-		return "", fmt.Errorf("error getting title details: %v", err)
+		return "", false, fmt.Errorf("error getting title details: %v", err)
 	}
 
 	if isTVShow(fullTitle) {
 		return handleTVShowSelection(client, fullTitle)
 	}
 
-	return title.ID, nil
+	return title.ID, false, nil
+}
+
+// startPlayback plays the selected ID, or the season from it when playSeason is set.
+func startPlayback(client *http.Client, imdbID string, playSeason bool) error {
+	if playSeason {
+		return handleSeasonPlayback(client, imdbID)
+	}
+	return handleStreamingSelection(imdbID, "")
 }
 
 func isTVShow(title *imdb.Title) bool {
@@ -159,37 +250,37 @@ func isTVShow(title *imdb.Title) bool {
 		title.SeasonCount > 0
 }
 
-func handleTVShowSelection(client *http.Client, title *imdb.Title) (string, error) {
+func handleTVShowSelection(client *http.Client, title *imdb.Title) (string, bool, error) {
 	fmt.Println("\nTV Series detected!")
 
 	for {
 		seasons, err := getSeasons(client, title)
 		if err != nil {
-			return "", fmt.Errorf("error getting seasons: %v", err)
+			return "", false, fmt.Errorf("error getting seasons: %v", err)
 		}
 
 		if len(seasons) == 0 {
 			fmt.Println("No seasons found. Returning main title ID.")
-			return title.ID, nil
+			return title.ID, false, nil
 		}
 
 		selectedSeason, err := ui.SelectSeason(seasons)
 		if err != nil {
 			if err.Error() == "abort" {
-				return "", fmt.Errorf("abort")
+				return "", false, fmt.Errorf("abort")
 			}
-			return "", err
+			return "", false, err
 		}
 
 		for {
 			episodes, err := getEpisodes(client, title.ID, selectedSeason)
 			if err != nil {
-				return "", fmt.Errorf("error getting episodes: %v", err)
+				return "", false, fmt.Errorf("error getting episodes: %v", err)
 			}
 
 			if len(episodes) == 0 {
 				fmt.Println("No episodes found. Returning season ID.")
-				return fmt.Sprintf("%s/%d-1", title.ID, selectedSeason), nil
+				return fmt.Sprintf("%s/%d-1", title.ID, selectedSeason), false, nil
 			}
 
 			selectedEpisode, err := ui.SelectEpisode(episodes)
@@ -197,10 +288,21 @@ func handleTVShowSelection(client *http.Client, title *imdb.Title) (string, erro
 				if err.Error() == "abort" {
 					break
 				}
-				return "", err
+				return "", false, err
+			}
+
+			playSeason := false
+			if _, hasMore := firstEpisodeAfter(episodes, selectedEpisode); hasMore {
+				playSeason, err = ui.SelectEpisodeAction()
+				if err != nil {
+					if err.Error() == "abort" {
+						continue
+					}
+					return "", false, err
+				}
 			}
 
-			return fmt.Sprintf("%s/%d-%d", title.ID, selectedSeason, selectedEpisode), nil
+			return fmt.Sprintf("%s/%d-%d", title.ID, selectedSeason, selectedEpisode), playSeason, nil
 		}
 	}
 }
@@ -236,51 +338,351 @@ func getEpisodes(client *http.Client, titleID string, season int) ([]int, error)
 	return episodes, nil
 }
 
-func handleStreamingSelection(imdbID string) error {
-	if !player.IsAvailable() {
-		fmt.Println("\nWarning: mpv not found in PATH")
-		fmt.Println("Please install mpv to enable streaming playback")
-		fmt.Println("On Ubuntu/Debian: sudo apt install mpv")
-		fmt.Println("On macOS: brew install mpv")
-		fmt.Println("On Windows: choco install mpv")
-		return nil
+// nextEpisode returns the episode following season/episode, rolling over
+// into the next season. ok is false after the last episode of the show.
+func nextEpisode(client *http.Client, titleID string, season, episode int) (int, int, bool, error) {
+	episodes, err := getEpisodes(client, titleID, season)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	if next, found := firstEpisodeAfter(episodes, episode); found {
+		return season, next, true, nil
+	}
+
+	title, err := imdb.NewTitle(client, titleID)
+	if err != nil {
+		return 0, 0, false, fmt.Errorf("error getting title details: %v", err)
+	}
+	for s := season + 1; s <= title.SeasonCount; s++ {
+		episodes, err := getEpisodes(client, titleID, s)
+		if err != nil {
+			return 0, 0, false, err
+		}
+		if first, found := firstEpisodeAfter(episodes, 0); found {
+			return s, first, true, nil
+		}
+	}
+
+	return 0, 0, false, nil
+}
+
+// firstEpisodeAfter returns the lowest episode number greater than after.
+func firstEpisodeAfter(episodes []int, after int) (int, bool) {
+	next, found := 0, false
+	for _, ep := range episodes {
+		if ep > after && (!found || ep < next) {
+			next, found = ep, true
+		}
+	}
+	return next, found
+}
+
+// handleStreamingSelection plays imdbID, from the library if it has been
+// downloaded and otherwise by resolving a stream. If quality names a variant
+// resolution that is available it is played without asking. TV episodes that
+// play to the end are followed by the next one in binge mode.
+func handleStreamingSelection(imdbID, quality string) error {
+	if ok, err := playerReady(); !ok {
+		return err
 	}
 
 	mediaType, season, episode := parseIMDbID(imdbID)
+	var variants []stream.StreamVariant
+
+	for played := 1; ; played++ {
+		local, isLocal := findLocalCopy(imdbID, season, episode)
+
+		var selectedVariant *stream.StreamVariant
+		if !isLocal {
+			if variants == nil {
+				fmt.Println("\nFetching streaming options...")
+				var err error
+				variants, err = stream.GetStreamVariants(imdbID, mediaType, season, episode)
+				if err != nil {
+					return fmt.Errorf("failed to get streaming variants: %w", err)
+				}
+			}
+
+			if len(variants) == 0 {
+				return fmt.Errorf("no streaming variants found")
+			}
+
+			var err error
+			selectedVariant, err = selectVariant(variants, quality)
+			if err != nil {
+				return err
+			}
+			quality = selectedVariant.Resolution
+		}
+
+		var next *nextEpisodeFetch
+		if mediaType == stream.TV && cfg.Binge.Enabled && played < cfg.Binge.MaxEpisodes {
+			next = prefetchNextEpisode(imdbID, season, episode)
+		}
+
+		var status player.Status
+		var err error
+		if isLocal {
+			status, err = playLocal(local)
+		} else {
+			status, err = playVariant(imdbID, mediaType, season, episode, selectedVariant)
+		}
+		if err != nil {
+			return err
+		}
+
+		// Only keep going when the episode played to its end; quitting mpv
+		// means the user wants to stop.
+		if next == nil || !status.EOF {
+			return nil
+		}
 
-	fmt.Println("\nFetching streaming options...")
-	variants, err := stream.GetStreamVariants(imdbID, mediaType, season, episode)
+		result := next.wait()
+		if result.err != nil {
+			log.Printf("Warning: Could not prepare next episode: %v", result.err)
+			return nil
+		}
+		if result.imdbID == "" {
+			fmt.Println("\nThat was the last episode.")
+			return nil
+		}
+
+		_, nextSeason, nextEp := parseIMDbID(result.imdbID)
+		if !countdown(fmt.Sprintf("S%02dE%02d", nextSeason, nextEp), time.Duration(cfg.Binge.Countdown)) {
+			return nil
+		}
+
+		imdbID, season, episode = result.imdbID, nextSeason, nextEp
+		variants = result.variants
+	}
+}
+
+// errNoPlayer means the chosen player, or every supported one, is missing.
+var errNoPlayer = errors.New("no player found in PATH")
+
+// playerBackend returns the player chosen with -player or in the config,
+// or else the first supported one that is installed.
+func playerBackend() (player.Backend, error) {
+	name := *playerName
+	if name == "" {
+		name = cfg.Player.Backend
+	}
+	if name == "" {
+		if b := player.Detect(); b != nil {
+			return b, nil
+		}
+		return nil, errNoPlayer
+	}
+
+	b, err := player.ByName(name, cfg.Player.Command)
+	if err != nil {
+		return nil, err
+	}
+	if !b.Available() {
+		return nil, fmt.Errorf("%s: %w", b.Name(), errNoPlayer)
+	}
+	return b, nil
+}
+
+// playerReady reports whether there is a player to play with, printing
+// install instructions if not.
+func playerReady() (bool, error) {
+	_, err := playerBackend()
+	if errors.Is(err, errNoPlayer) {
+		warnPlayerMissing()
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func warnPlayerMissing() {
+	fmt.Println("\nWarning: player not found in PATH")
+	fmt.Println("Please install mpv (or VLC or ffplay) to enable streaming playback")
+	fmt.Println("On Ubuntu/Debian: sudo apt install mpv")
+	fmt.Println("On macOS: brew install mpv")
+	fmt.Println("On Windows: choco install mpv")
+}
+
+// selectVariant returns the variant matching want, or else the -quality
+// flag or configured default, and only asks if none of them match. An
+// adaptive "Auto" entry is offered alongside the fixed variants.
+func selectVariant(variants []stream.StreamVariant, want string) (*stream.StreamVariant, error) {
+	prefs := qualityPreferences()
+	for _, q := range []string{want, *quality, cfg.Quality.Default} {
+		if q == "" {
+			continue
+		}
+		if stream.Adaptive(q) {
+			prefs = probedPreferences(variants, prefs)
+		}
+		if v := stream.Select(stream.WithAuto(variants, prefs), q, prefs); v != nil {
+			return v, nil
+		}
+	}
+	return ui.SelectStreamVariant(stream.WithAuto(variants, prefs))
+}
+
+// throughputTTL is how long a measured throughput is reused, so binge and
+// season playback do not probe before every episode.
+const throughputTTL = 10 * time.Minute
+
+var throughput struct {
+	sync.Mutex
+	bps int
+	at  time.Time
+}
+
+// probedPreferences returns prefs limited to the variants the connection
+// can carry, measuring it if the last measurement is stale. If none of the
+// variants fit, the slowest allowed one is kept.
+func probedPreferences(variants []stream.StreamVariant, prefs stream.Preferences) stream.Preferences {
+	if !cfg.Quality.Probe {
+		return prefs
+	}
+	throughput.Lock()
+	defer throughput.Unlock()
+	if time.Since(throughput.at) > throughputTTL {
+		bps, err := stream.Probe(context.Background(), client.NewMedia(0), variants)
+		if err != nil {
+			log.Printf("Warning: Could not measure throughput: %v", err)
+			return prefs
+		}
+		log.Printf("Measured throughput: %.1f Mbit/s", float64(bps)/1e6)
+		throughput.bps, throughput.at = bps, time.Now()
+	}
+
+	probed := prefs.WithThroughput(throughput.bps)
+	if stream.Select(variants, "best", probed) == nil {
+		if worst := stream.Select(variants, "worst", prefs); worst != nil {
+			probed.MaxBandwidth, _ = strconv.Atoi(worst.Bandwidth)
+		}
+	}
+	return probed
+}
+
+// qualityPreferences returns the configured limits on automatic choices.
+func qualityPreferences() stream.Preferences {
+	maxHeight, _ := strconv.Atoi(strings.TrimSuffix(strings.ToLower(cfg.Quality.MaxResolution), "p"))
+	return stream.Preferences{
+		MaxHeight:    maxHeight,
+		MaxBandwidth: cfg.Quality.MaxBandwidth,
+		AvoidCodecs:  cfg.Quality.AvoidCodecs,
+	}
+}
+
+// playVariant plays a resolved variant and records it in the watch history.
+func playVariant(imdbID string, mediaType stream.MediaType, season, episode int, variant *stream.StreamVariant) (player.Status, error) {
+	fmt.Printf("\nPlaying %s...\n", ui.FormatVariantDisplay(*variant))
+
+	title, name := getTitleForPlayer(imdbID, mediaType, season, episode)
+
+	backend, err := playerBackend()
 	if err != nil {
-		return fmt.Errorf("failed to get streaming variants: %w", err)
+		return player.Status{}, fmt.Errorf("failed to create player: %w", err)
 	}
 
-	if len(variants) == 0 {
-		return fmt.Errorf("no streaming variants found")
+	baseID := strings.Split(imdbID, "/")[0]
+	opts := player.Options{
+		CacheSize:  *cacheSize,
+		HLSBitrate: autoBitrate(variant),
+		Start:      resumePosition(baseID, season, episode),
 	}
 
-	selectedVariant, err := ui.SelectStreamVariant(variants)
+	entryID := recordHistory(history.Entry{
+		IMDbID:    baseID,
+		Title:     name,
+		Season:    season,
+		Episode:   episode,
+		Provider:  stream.Provider,
+		Variant:   variant.Resolution,
+		StartedAt: time.Now(),
+	})
+
+	url, release := playbackURL(variant, stream.ResolveOptions{
+		IMDBID:  imdbID,
+		Type:    mediaType,
+		Season:  season,
+		Episode: episode,
+	})
+	defer release()
+	media := player.Media{
+		URL:       url,
+		Title:     title,
+		Header:    stream.VariantHeader(variant),
+		Subtitles: subtitlePaths(fetchSubtitles(imdbID, season, episode, variant, subtitleLanguages())),
+	}
+	status, err := backend.Play(context.Background(), media, opts)
+	updateHistory(entryID, status)
 	if err != nil {
-		return err
+		return status, fmt.Errorf("failed to play stream: %w", err)
 	}
 
-	fmt.Printf("\nPlaying %s...\n", ui.FormatVariantDisplay(*selectedVariant))
+	return status, nil
+}
 
-	title := getTitleForPlayer(imdbID, mediaType, season, episode)
+// recordHistory adds e to the watch history and returns its ID, or 0 if it
+// could not be recorded. Failing to record is not worth interrupting playback
+// for, so errors are only logged.
+func recordHistory(e history.Entry) int {
+	store, err := history.Open()
+	if err != nil {
+		log.Printf("Warning: Could not open watch history: %v", err)
+		return 0
+	}
+	e, err = store.Add(e)
+	if err != nil {
+		log.Printf("Warning: Could not record watch history: %v", err)
+		return 0
+	}
+	return e.ID
+}
 
-	player, err := player.New()
+// resumePosition offers to continue an unfinished title from where it was
+// last left off and returns the position to start at.
+func resumePosition(imdbID string, season, episode int) time.Duration {
+	store, err := history.Open()
 	if err != nil {
-		return fmt.Errorf("failed to create player: %w", err)
+		return 0
+	}
+	last, ok, err := store.Last(imdbID, season, episode)
+	if err != nil || !ok || last.Finished || last.Position < minResumePosition {
+		return 0
+	}
+
+	if !ui.Confirm(fmt.Sprintf("Resume from %s?", ui.FormatClock(last.Position)), true) {
+		return 0
 	}
+	return last.Position
+}
 
-	player.CacheSize = *cacheSize
-	player.Title = title
+// updateHistory stores the final playback position reported by the player.
+func updateHistory(id int, status player.Status) {
+	if id == 0 || status.Duration == 0 {
+		return
+	}
 
-	err = player.Play(selectedVariant.URL)
+	store, err := history.Open()
+	if err != nil {
+		log.Printf("Warning: Could not open watch history: %v", err)
+		return
+	}
+	err = store.Update(id, func(e *history.Entry) {
+		e.Position = status.Position
+		e.Duration = status.Duration
+		e.Finished = status.EOF || cfg.IsFinished(status.Position, status.Duration)
+	})
 	if err != nil {
-		return fmt.Errorf("failed to play stream: %w", err)
+		log.Printf("Warning: Could not update watch history: %v", err)
 	}
+}
 
-	return nil
+// autoBitrate returns the bitrate cap for an adaptive variant, or 0.
+func autoBitrate(variant *stream.StreamVariant) int {
+	if variant.Resolution != stream.Auto {
+		return 0
+	}
+	n, _ := strconv.Atoi(variant.Bandwidth)
+	return n
 }
 
 func parseIMDbID(imdbID string) (stream.MediaType, int, int) {
@@ -297,7 +699,8 @@ func parseIMDbID(imdbID string) (stream.MediaType, int, int) {
 	return stream.Movie, 0, 0
 }
 
-func getTitleForPlayer(imdbID string, mediaType stream.MediaType, season, episode int) string {
+// getTitleForPlayer returns the window title for the player and the plain title name.
+func getTitleForPlayer(imdbID string, mediaType stream.MediaType, season, episode int) (string, string) {
 	client := client.New()
 
 	baseID := imdbID
@@ -308,7 +711,7 @@ func getTitleForPlayer(imdbID string, mediaType stream.MediaType, season, episod
 	titleInfo, err := imdb.NewTitle(client, baseID)
 	if err != nil {
 		log.Printf("Warning: Could not fetch title info: %v", err)
-		return "Kino Player"
+		return "Kino Player", baseID
 	}
 
 	playerTitle := titleInfo.Name
@@ -325,5 +728,5 @@ func getTitleForPlayer(imdbID string, mediaType stream.MediaType, season, episod
 		playerTitle = fmt.Sprintf("%s (%d)", playerTitle, titleInfo.Year)
 	}
 
-	return playerTitle
+	return playerTitle, titleInfo.Name
 }
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+
+	"kino/stream"
+)
+
+func TestSelectVariant(t *testing.T) {
+	variants := []stream.StreamVariant{
+		{Resolution: "1920x1080", Bandwidth: "5000000"},
+		{Resolution: "1280x720", Bandwidth: "2800000"},
+		{Resolution: "640x360", Bandwidth: "800000"},
+	}
+	tests := []struct {
+		name                 string
+		want, flag, fallback string
+		pick                 string
+	}{
+		{"asked for", "720p", "360p", "1080p", "1280x720"},
+		{"flag after a missing request", "2160p", "360p", "1080p", "640x360"},
+		{"flag without a request", "", "720p", "", "1280x720"},
+		{"default after a missing flag", "", "1440p", "360p", "640x360"},
+		{"default after missing request and flag", "2160p", "1440p", "best", "1920x1080"},
+	}
+	savedFlag, savedCfg := *quality, cfg
+	t.Cleanup(func() { *quality, cfg = savedFlag, savedCfg })
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			*quality = tt.flag
+			conf := *savedCfg
+			cfg = &conf
+			cfg.Quality.Default = tt.fallback
+			cfg.Quality.Probe = false
+			v, err := selectVariant(variants, tt.want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v.Resolution != tt.pick {
+				t.Errorf("selectVariant = %s, want %s", v.Resolution, tt.pick)
+			}
+		})
+	}
+}
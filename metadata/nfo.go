@@ -0,0 +1,139 @@
+package metadata
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/StalkR/imdb"
+)
+
+// uniqueID is how Kodi records an item's ID in a given database.
+type uniqueID struct {
+	Type    string `xml:"type,attr"`
+	Default bool   `xml:"default,attr,omitempty"`
+	Value   string `xml:",chardata"`
+}
+
+type movieNFO struct {
+	XMLName  xml.Name `xml:"movie"`
+	Title    string   `xml:"title"`
+	Year     int      `xml:"year,omitempty"`
+	Plot     string   `xml:"plot,omitempty"`
+	Rating   string   `xml:"rating,omitempty"`
+	Genres   []string `xml:"genre"`
+	UniqueID uniqueID `xml:"uniqueid"`
+}
+
+type tvShowNFO struct {
+	XMLName  xml.Name `xml:"tvshow"`
+	Title    string   `xml:"title"`
+	Year     int      `xml:"year,omitempty"`
+	Plot     string   `xml:"plot,omitempty"`
+	Rating   string   `xml:"rating,omitempty"`
+	Genres   []string `xml:"genre"`
+	UniqueID uniqueID `xml:"uniqueid"`
+}
+
+type episodeNFO struct {
+	XMLName   xml.Name  `xml:"episodedetails"`
+	Title     string    `xml:"title"`
+	ShowTitle string    `xml:"showtitle"`
+	Season    int       `xml:"season"`
+	Episode   int       `xml:"episode"`
+	Year      int       `xml:"year,omitempty"`
+	Plot      string    `xml:"plot,omitempty"`
+	Rating    string    `xml:"rating,omitempty"`
+	UniqueID  *uniqueID `xml:"uniqueid"`
+}
+
+// WriteMovieNFO writes the .nfo for a movie saved at mediaPath.
+func WriteMovieNFO(mediaPath string, title *imdb.Title) error {
+	return writeNFO(nfoPath(mediaPath), movieNFO{
+		Title:    title.Name,
+		Year:     title.Year,
+		Plot:     title.Description,
+		Rating:   title.Rating,
+		Genres:   title.Genres,
+		UniqueID: imdbID(title.ID),
+	})
+}
+
+// WriteTVShowNFO writes tvshow.nfo into the show folder of an episode saved
+// at mediaPath, unless one is already there.
+func WriteTVShowNFO(mediaPath string, show *imdb.Title) error {
+	path := filepath.Join(ShowDir(mediaPath), "tvshow.nfo")
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	return writeNFO(path, tvShowNFO{
+		Title:    show.Name,
+		Year:     show.Year,
+		Plot:     show.Description,
+		Rating:   show.Rating,
+		Genres:   show.Genres,
+		UniqueID: imdbID(show.ID),
+	})
+}
+
+// WriteEpisodeNFO writes the .nfo for an episode saved at mediaPath. details
+// holds the episode's own IMDb page, if it could be fetched, for its plot,
+// rating and year.
+func WriteEpisodeNFO(mediaPath string, show *imdb.Title, episode imdb.Episode, details *imdb.Title) error {
+	nfo := episodeNFO{
+		Title:     episode.Name,
+		ShowTitle: show.Name,
+		Season:    episode.SeasonNumber,
+		Episode:   episode.EpisodeNumber,
+	}
+	if episode.ID != "" {
+		id := imdbID(episode.ID)
+		nfo.UniqueID = &id
+	}
+	if details != nil {
+		nfo.Year = details.Year
+		nfo.Plot = details.Description
+		nfo.Rating = details.Rating
+	}
+	return writeNFO(nfoPath(mediaPath), nfo)
+}
+
+var seasonDirPattern = regexp.MustCompile(`(?i)^(season\s*\d+|specials)$`)
+
+// ShowDir returns the show folder for an episode saved at mediaPath: its
+// directory, or the one above if that is a "Season NN" folder.
+func ShowDir(mediaPath string) string {
+	dir := filepath.Dir(mediaPath)
+	if seasonDirPattern.MatchString(filepath.Base(dir)) {
+		return filepath.Dir(dir)
+	}
+	return dir
+}
+
+func nfoPath(mediaPath string) string {
+	return strings.TrimSuffix(mediaPath, filepath.Ext(mediaPath)) + ".nfo"
+}
+
+func imdbID(id string) uniqueID {
+	return uniqueID{Type: "imdb", Default: true, Value: id}
+}
+
+func writeNFO(path string, v any) error {
+	data, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding %s: %w", path, err)
+	}
+	data = append([]byte(xml.Header), data...)
+	data = append(data, '\n')
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("creating %s: %w", filepath.Dir(path), err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	return nil
+}
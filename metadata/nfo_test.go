@@ -0,0 +1,150 @@
+package metadata
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/StalkR/imdb"
+)
+
+var testShow = &imdb.Title{
+	ID:          "tt0386676",
+	Name:        "The Office",
+	Year:        2005,
+	Rating:      "9.0",
+	Description: "A mockumentary on a group of office workers.",
+	Genres:      []string{"Comedy"},
+}
+
+func readNFO(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestWriteMovieNFO(t *testing.T) {
+	media := filepath.Join(t.TempDir(), "Heat (1995).mp4")
+	err := WriteMovieNFO(media, &imdb.Title{
+		ID:          "tt0113277",
+		Name:        "Heat",
+		Year:        1995,
+		Rating:      "8.3",
+		Description: "A group of high-end professional thieves & a detective.",
+		Genres:      []string{"Action", "Crime"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `<?xml version="1.0" encoding="UTF-8"?>
+<movie>
+  <title>Heat</title>
+  <year>1995</year>
+  <plot>A group of high-end professional thieves &amp; a detective.</plot>
+  <rating>8.3</rating>
+  <genre>Action</genre>
+  <genre>Crime</genre>
+  <uniqueid type="imdb" default="true">tt0113277</uniqueid>
+</movie>
+`
+	if got := readNFO(t, filepath.Join(filepath.Dir(media), "Heat (1995).nfo")); got != want {
+		t.Errorf("movie NFO:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestWriteTVShowNFO(t *testing.T) {
+	dir := t.TempDir()
+	media := filepath.Join(dir, "The Office", "Season 02", "The Office - S02E03.mp4")
+	if err := WriteTVShowNFO(media, testShow); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "The Office", "tvshow.nfo")
+	want := `<?xml version="1.0" encoding="UTF-8"?>
+<tvshow>
+  <title>The Office</title>
+  <year>2005</year>
+  <plot>A mockumentary on a group of office workers.</plot>
+  <rating>9.0</rating>
+  <genre>Comedy</genre>
+  <uniqueid type="imdb" default="true">tt0386676</uniqueid>
+</tvshow>
+`
+	if got := readNFO(t, path); got != want {
+		t.Errorf("tvshow.nfo:\n%s\nwant:\n%s", got, want)
+	}
+
+	// An existing tvshow.nfo, perhaps edited by hand, is left alone.
+	if err := os.WriteFile(path, []byte("mine"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteTVShowNFO(media, testShow); err != nil {
+		t.Fatal(err)
+	}
+	if got := readNFO(t, path); got != "mine" {
+		t.Errorf("tvshow.nfo was overwritten with:\n%s", got)
+	}
+}
+
+func TestWriteEpisodeNFO(t *testing.T) {
+	episode := imdb.Episode{SeasonNumber: 2, EpisodeNumber: 3, ID: "tt0664521", Name: "Office Olympics"}
+	tests := []struct {
+		name    string
+		episode imdb.Episode
+		details *imdb.Title
+		want    string
+	}{
+		{"with details", episode, &imdb.Title{Year: 2005, Rating: "8.2", Description: "Michael & Dwight look at a condo."}, `<?xml version="1.0" encoding="UTF-8"?>
+<episodedetails>
+  <title>Office Olympics</title>
+  <showtitle>The Office</showtitle>
+  <season>2</season>
+  <episode>3</episode>
+  <year>2005</year>
+  <plot>Michael &amp; Dwight look at a condo.</plot>
+  <rating>8.2</rating>
+  <uniqueid type="imdb" default="true">tt0664521</uniqueid>
+</episodedetails>
+`},
+		{"without details or ID", imdb.Episode{SeasonNumber: 2, EpisodeNumber: 3}, nil, `<?xml version="1.0" encoding="UTF-8"?>
+<episodedetails>
+  <title></title>
+  <showtitle>The Office</showtitle>
+  <season>2</season>
+  <episode>3</episode>
+</episodedetails>
+`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			media := filepath.Join(t.TempDir(), "The Office - S02E03.ts")
+			if err := WriteEpisodeNFO(media, testShow, tt.episode, tt.details); err != nil {
+				t.Fatal(err)
+			}
+			if got := readNFO(t, nfoPath(media)); got != tt.want {
+				t.Errorf("episode NFO:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestShowDir(t *testing.T) {
+	tests := []struct {
+		media, want string
+	}{
+		{"tv/The Office/Season 02/e.mp4", "tv/The Office"},
+		{"tv/The Office/season2/e.mp4", "tv/The Office"},
+		{"tv/The Office/Specials/e.mp4", "tv/The Office"},
+		{"tv/The Office/e.mp4", "tv/The Office"},
+		{"tv/Seasoned/e.mp4", "tv/Seasoned"},
+	}
+	for _, tt := range tests {
+		if got := ShowDir(filepath.FromSlash(tt.media)); got != filepath.FromSlash(tt.want) {
+			t.Errorf("ShowDir(%q) = %q, want %q", tt.media, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+// Package metadata names downloaded files and writes the .nfo files media
+// servers such as Kodi and Jellyfin read alongside them.
+package metadata
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Fields are the values available to an output template.
+type Fields struct {
+	// Title is the movie or show name; {show} is an alias for it.
+	Title        string
+	Year         int
+	Season       int
+	Episode      int
+	EpisodeTitle string
+	IMDbID       string
+}
+
+var placeholderPattern = regexp.MustCompile(`\{([a-z_]+)(?::(0?)(\d+))?\}`)
+
+// Expand fills in a template such as
+//
+//	{show}/Season {season:02}/{show} - S{season:02}E{episode:02} - {episode_title}
+//
+// Placeholders are {title}, {show}, {year}, {season}, {episode},
+// {episode_title} and {imdb_id}; a ":02" suffix zero-pads numbers. Slashes in
+// the template separate directories, while values are made safe to use as a
+// single path element. Brackets left empty by missing values are dropped.
+func Expand(template string, f Fields) (string, error) {
+	var unknown string
+	expanded := placeholderPattern.ReplaceAllStringFunc(template, func(m string) string {
+		sub := placeholderPattern.FindStringSubmatch(m)
+		name, pad, width := sub[1], sub[2], sub[3]
+
+		var value string
+		number := -1
+		switch name {
+		case "title", "show":
+			value = f.Title
+		case "episode_title":
+			value = f.EpisodeTitle
+		case "imdb_id":
+			value = f.IMDbID
+		case "year":
+			number = f.Year
+		case "season":
+			number = f.Season
+		case "episode":
+			number = f.Episode
+		default:
+			unknown = m
+			return m
+		}
+
+		if number > 0 {
+			value = strconv.Itoa(number)
+			if width != "" {
+				w, _ := strconv.Atoi(width)
+				value = fmt.Sprintf("%"+pad+"*d", w, number)
+			}
+		}
+		return SanitizeFileName(value)
+	})
+	if unknown != "" {
+		return "", fmt.Errorf("unknown placeholder %s in output template %q", unknown, template)
+	}
+
+	parts := strings.Split(filepath.ToSlash(expanded), "/")
+	kept := parts[:0]
+	for _, part := range parts {
+		if part = tidy(part); part != "" {
+			kept = append(kept, part)
+		}
+	}
+	if len(kept) == 0 {
+		return "", fmt.Errorf("output template %q expands to an empty path", template)
+	}
+	return filepath.Join(kept...), nil
+}
+
+var (
+	extension     = regexp.MustCompile(`\.[A-Za-z0-9]{2,4}$`)
+	emptyBrackets = regexp.MustCompile(`\(\s*\)|\[\s*\]`)
+	danglingDash  = regexp.MustCompile(`(\s+-)+\s*$|^\s*(-\s+)+`)
+	spaces        = regexp.MustCompile(`\s{2,}`)
+)
+
+// tidy cleans up what missing values leave behind in a path element, such as
+// "Film ()" or "Show - S01E02 - .mp4".
+func tidy(s string) string {
+	ext := extension.FindString(s)
+	s = strings.TrimSuffix(s, ext)
+	s = emptyBrackets.ReplaceAllString(s, "")
+	s = spaces.ReplaceAllString(s, " ")
+	s = danglingDash.ReplaceAllString(s, "")
+	if s = strings.TrimSpace(s); s == "" {
+		return ""
+	}
+	return s + ext
+}
+
+var unsafeFileChars = strings.NewReplacer(
+	"/", "-", "\\", "-", ":", " -", "*", "", "?", "", "\"", "'", "<", "", ">", "", "|", "-",
+)
+
+// SanitizeFileName makes name safe to use as a file or directory name.
+func SanitizeFileName(name string) string {
+	return strings.TrimSpace(unsafeFileChars.Replace(name))
+}
@@ -0,0 +1,77 @@
+package metadata
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+const (
+	episodeTemplate = "{show}/Season {season:02}/{show} - S{season:02}E{episode:02} - {episode_title}.mp4"
+	movieTemplate   = "{title} ({year})"
+)
+
+func TestExpand(t *testing.T) {
+	office := Fields{Title: "The Office", Year: 2005, Season: 2, Episode: 3, EpisodeTitle: "Office Olympics", IMDbID: "tt0386676"}
+	tests := []struct {
+		name     string
+		template string
+		fields   Fields
+		want     string // slash-separated; "" for an error
+	}{
+		{"episode", episodeTemplate, office,
+			"The Office/Season 02/The Office - S02E03 - Office Olympics.mp4"},
+		{"two-digit numbers are not cut", episodeTemplate, Fields{Title: "Show", Season: 12, Episode: 104, EpisodeTitle: "Finale"},
+			"Show/Season 12/Show - S12E104 - Finale.mp4"},
+		{"missing episode title", episodeTemplate, Fields{Title: "The Office", Season: 2, Episode: 3},
+			"The Office/Season 02/The Office - S02E03.mp4"},
+		{"missing season", episodeTemplate, Fields{Title: "Show", Episode: 3, EpisodeTitle: "Pilot"},
+			"Show/Season/Show - SE03 - Pilot.mp4"},
+		{"movie", movieTemplate, Fields{Title: "Heat", Year: 1995}, "Heat (1995)"},
+		{"movie without a year", movieTemplate, Fields{Title: "Heat"}, "Heat"},
+		{"unpadded", "{show} {season}x{episode}", office, "The Office 2x3"},
+		{"imdb id", "{title} [{imdb_id}]", office, "The Office [tt0386676]"},
+		{"missing imdb id", "{title} [{imdb_id}]", Fields{Title: "Heat"}, "Heat"},
+		{"unsafe characters", movieTemplate, Fields{Title: `Mission: Impossible / Fallout?`, Year: 2018},
+			"Mission - Impossible - Fallout (2018)"},
+		{"dots in titles are kept", movieTemplate, Fields{Title: "Mr. Robot"}, "Mr. Robot"},
+		{"leading dash", "{episode_title} - {title}", Fields{Title: "Heat"}, "Heat"},
+		{"empty directories are dropped", "{show}/{episode_title}/{title}", Fields{Title: "Heat"}, "Heat/Heat"},
+		{"nothing left", movieTemplate, Fields{}, ""},
+		{"unknown placeholder", "{title} {director}", office, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Expand(tt.template, tt.fields)
+			if tt.want == "" {
+				if err == nil {
+					t.Errorf("Expand(%q) = %q, want an error", tt.template, got)
+				}
+				return
+			}
+			if err != nil || got != filepath.FromSlash(tt.want) {
+				t.Errorf("Expand(%q) = %q, %v; want %q", tt.template, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestTidy(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"Film ()", "Film"},
+		{"Film ( ) [ ]", "Film"},
+		{"Show - S01E02 - ", "Show - S01E02"},
+		{"Show - S01E02 - .mp4", "Show - S01E02.mp4"},
+		{"Show -  - S01E02", "Show - - S01E02"},
+		{" - Pilot", "Pilot"},
+		{"Film  (2001)", "Film (2001)"},
+		{".mp4", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := tidy(tt.in); got != tt.want {
+			t.Errorf("tidy(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package player
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Media is what a backend plays.
+type Media struct {
+	// URL is a stream URL or a local file path.
+	URL   string
+	Title string
+	// Header is sent with the player's HTTP requests, e.g. the Referer and
+	// User-Agent a stream host checks.
+	Header http.Header
+	// Subtitles are paths of subtitle files to load alongside, the first
+	// selected.
+	Subtitles []string
+}
+
+// Options control how a backend plays media. Backends ignore options they
+// have no equivalent for.
+type Options struct {
+	// Start is the position playback begins at.
+	Start time.Duration
+	// CacheSize limits the player's demuxer cache, e.g. "30MiB".
+	CacheSize string
+	// HLSBitrate, if set, makes the player pick the best HLS variant within
+	// this many bits per second from a master playlist.
+	HLSBitrate int
+
+	// OnControl, if set, is called once the player can be controlled. It
+	// runs in its own goroutine and can keep using the controller until the
+	// player exits; Play does not return before it does. Backends without a
+	// control interface never call it.
+	OnControl func(Controller)
+}
+
+// Backend is a media player kino can hand streams to.
+type Backend interface {
+	// Name is what the backend is selected by, e.g. "mpv".
+	Name() string
+	// Available reports whether the player is installed.
+	Available() bool
+	// Play runs the player until it exits and returns the last playback
+	// state it reported. The status is zero if the backend cannot report
+	// one.
+	Play(ctx context.Context, m Media, opts Options) (Status, error)
+}
+
+// Controller drives a running player.
+type Controller interface {
+	Seek(position time.Duration) error
+	SetPause(paused bool) error
+	// Status returns the latest playback state.
+	Status() Status
+	// Done is closed once the player is gone.
+	Done() <-chan struct{}
+}
+
+// sortedKeys returns the canonical names in header in order, so players
+// are started with the same arguments every time.
+func sortedKeys(header http.Header) []string {
+	keys := make([]string, 0, len(header))
+	for k := range header {
+		keys = append(keys, http.CanonicalHeaderKey(k))
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Names lists the built-in backends in the order Detect tries them.
+var Names = []string{"mpv", "vlc", "ffplay"}
+
+// ByName returns the backend called name: "mpv", "vlc", "ffplay", or
+// "command" to run command. A name containing "{url}" is taken as a command
+// template itself.
+func ByName(name, command string) (Backend, error) {
+	switch {
+	case name == "mpv":
+		return &MPV{}, nil
+	case name == "vlc":
+		return &VLC{}, nil
+	case name == "ffplay":
+		return &FFplay{}, nil
+	case name == "command":
+		if command == "" {
+			return nil, fmt.Errorf("player command is not set")
+		}
+		return NewCommand(command)
+	case strings.Contains(name, "{url}"):
+		return NewCommand(name)
+	}
+	return nil, fmt.Errorf("unknown player %q (want %s or command)", name, strings.Join(Names, ", "))
+}
+
+// Detect returns the first installed built-in backend, or nil if there is
+// none.
+func Detect() Backend {
+	for _, name := range Names {
+		b, _ := ByName(name, "")
+		if b.Available() {
+			return b
+		}
+	}
+	return nil
+}
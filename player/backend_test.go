@@ -0,0 +1,66 @@
+package player
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestByName(t *testing.T) {
+	tests := []struct {
+		name, command string
+		want          string // backend name, or "" for an error
+	}{
+		{"mpv", "", "mpv"},
+		{"vlc", "", "vlc"},
+		{"ffplay", "", "ffplay"},
+		{"command", "myplayer {url}", "command"},
+		{"command", "", ""},
+		{"myplayer --fs {url}", "", "command"},
+		{"mplayer", "", ""},
+	}
+	for _, tt := range tests {
+		b, err := ByName(tt.name, tt.command)
+		switch {
+		case tt.want == "" && err == nil:
+			t.Errorf("ByName(%q, %q) = %s, want error", tt.name, tt.command, b.Name())
+		case tt.want != "" && err != nil:
+			t.Errorf("ByName(%q, %q): %v", tt.name, tt.command, err)
+		case tt.want != "" && b.Name() != tt.want:
+			t.Errorf("ByName(%q, %q) = %s, want %s", tt.name, tt.command, b.Name(), tt.want)
+		}
+	}
+}
+
+func TestDetect(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("fake players are shell scripts, and VLC is also looked for outside PATH on macOS")
+	}
+	tests := []struct {
+		installed []string
+		want      string
+	}{
+		{[]string{"ffplay", "vlc", "mpv"}, "mpv"},
+		{[]string{"ffplay", "vlc"}, "vlc"},
+		{[]string{"ffplay"}, "ffplay"},
+		{nil, ""},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		for _, name := range tt.installed {
+			if err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"), 0755); err != nil {
+				t.Fatal(err)
+			}
+		}
+		t.Setenv("PATH", dir)
+
+		got := ""
+		if b := Detect(); b != nil {
+			got = b.Name()
+		}
+		if got != tt.want {
+			t.Errorf("with %v installed, Detect = %q, want %q", tt.installed, got, tt.want)
+		}
+	}
+}
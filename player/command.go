@@ -0,0 +1,116 @@
+package player
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Command plays media by running a user-supplied command template such as
+// "myplayer --title {title} {url}". The placeholders are {url}, {title},
+// {start}, the start position in whole seconds, {referer} and
+// {user_agent}, the headers the stream host expects, and {subs}, the first
+// subtitle file. The URL is appended if the template has no {url}. It
+// cannot be controlled or report its position.
+type Command struct {
+	words []string
+}
+
+// NewCommand parses template into words, honouring single and double
+// quotes. Placeholders are expanded within a word, so a title with spaces
+// stays one argument.
+func NewCommand(template string) (*Command, error) {
+	words, err := splitWords(template)
+	if err != nil {
+		return nil, fmt.Errorf("parsing player command %q: %w", template, err)
+	}
+	if len(words) == 0 {
+		return nil, errors.New("player command is empty")
+	}
+	if !strings.Contains(template, "{url}") {
+		words = append(words, "{url}")
+	}
+	return &Command{words: words}, nil
+}
+
+func (c *Command) Name() string { return "command" }
+
+func (c *Command) Available() bool {
+	_, err := exec.LookPath(c.words[0])
+	return err == nil
+}
+
+// Play runs the command for m until it exits.
+func (c *Command) Play(ctx context.Context, m Media, opts Options) (Status, error) {
+	args := c.args(m, opts)
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Stdin = os.Stdin
+	return Status{}, cmd.Run()
+}
+
+// args expands the placeholders in the command's words for m.
+func (c *Command) args(m Media, opts Options) []string {
+	r := strings.NewReplacer(
+		"{url}", m.URL,
+		"{title}", m.Title,
+		"{start}", strconv.Itoa(int(opts.Start.Seconds())),
+		"{referer}", m.Header.Get("Referer"),
+		"{user_agent}", m.Header.Get("User-Agent"),
+		"{subs}", firstOr(m.Subtitles, ""),
+	)
+	args := make([]string, len(c.words))
+	for i, w := range c.words {
+		args[i] = r.Replace(w)
+	}
+	return args
+}
+
+func firstOr(s []string, fallback string) string {
+	if len(s) == 0 {
+		return fallback
+	}
+	return s[0]
+}
+
+// splitWords splits s at unquoted whitespace, removing the quotes.
+func splitWords(s string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	for _, r := range s {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
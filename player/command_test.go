@@ -0,0 +1,87 @@
+package player
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSplitWords(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"mpv {url}", []string{"mpv", "{url}"}},
+		{"  myplayer\t--fs  {url} ", []string{"myplayer", "--fs", "{url}"}},
+		{`player --title "{title}" {url}`, []string{"player", "--title", "{title}", "{url}"}},
+		{`player '--opt=a b' "it's"`, []string{"player", "--opt=a b", "it's"}},
+		{`player --title="My {title}"`, []string{"player", "--title=My {title}"}},
+		{`player ""`, []string{"player", ""}},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		got, err := splitWords(tt.in)
+		if err != nil {
+			t.Errorf("splitWords(%q): %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitWords(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+
+	for _, in := range []string{`player "{url}`, `player 'x`} {
+		if _, err := splitWords(in); err == nil {
+			t.Errorf("splitWords(%q): want error for the unterminated quote", in)
+		}
+	}
+}
+
+func TestCommandArgs(t *testing.T) {
+	media := Media{
+		URL:       "https://cdn.example/master.m3u8",
+		Title:     "Breaking Bad S01E01 - Pilot",
+		Header:    http.Header{"Referer": {"https://host.example/"}, "User-Agent": {"Mozilla/5.0 (X11)"}},
+		Subtitles: []string{"/tmp/en.srt", "/tmp/de.srt"},
+	}
+	opts := Options{Start: 90*time.Second + 500*time.Millisecond}
+	tests := []struct {
+		template string
+		want     []string
+	}{
+		{"myplayer", []string{"myplayer", media.URL}},
+		{"myplayer --title {title} {url}", []string{"myplayer", "--title", media.Title, media.URL}},
+		{"myplayer --title={title} --start {start} {url}", []string{"myplayer", "--title=" + media.Title, "--start", "90", media.URL}},
+		{`myplayer "--meta={title} ({start}s)" {url}`, []string{"myplayer", "--meta=" + media.Title + " (90s)", media.URL}},
+		{"curl -e {referer} -A {user_agent} {url}", []string{"curl", "-e", "https://host.example/", "-A", "Mozilla/5.0 (X11)", media.URL}},
+		{"myplayer --sub {subs} {url}", []string{"myplayer", "--sub", "/tmp/en.srt", media.URL}},
+	}
+	for _, tt := range tests {
+		c, err := NewCommand(tt.template)
+		if err != nil {
+			t.Errorf("NewCommand(%q): %v", tt.template, err)
+			continue
+		}
+		if got := c.args(media, opts); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q expands to %q, want %q", tt.template, got, tt.want)
+		}
+	}
+
+	c, err := NewCommand("myplayer --sub {subs} {url}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := c.args(Media{URL: "film.mkv"}, Options{})
+	if want := []string{"myplayer", "--sub", "", "film.mkv"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("without subtitles, args = %q, want %q", got, want)
+	}
+}
+
+func TestNewCommandErrors(t *testing.T) {
+	for _, template := range []string{"", "   ", `myplayer "{url}`} {
+		if _, err := NewCommand(template); err == nil {
+			t.Errorf("NewCommand(%q): want error", template)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package player
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// FFplay plays media with ffplay, which ships with ffmpeg. It cannot be
+// controlled or report its position.
+type FFplay struct{}
+
+func (*FFplay) Name() string { return "ffplay" }
+
+func (*FFplay) Available() bool {
+	_, err := exec.LookPath("ffplay")
+	return err == nil
+}
+
+// Play runs ffplay on m until it exits. Of the options, only Start is
+// supported, and m.Subtitles are not shown.
+func (*FFplay) Play(ctx context.Context, m Media, opts Options) (Status, error) {
+	playerPath, err := exec.LookPath("ffplay")
+	if err != nil {
+		return Status{}, fmt.Errorf("ffplay not found in PATH: %w", err)
+	}
+
+	args := []string{"-autoexit", "-loglevel", "warning"}
+	if m.Title != "" {
+		args = append(args, "-window_title", m.Title)
+	}
+	if opts.Start > 0 {
+		args = append(args, "-ss", fmt.Sprintf("%d", int(opts.Start.Seconds())))
+	}
+	if len(m.Header) > 0 {
+		args = append(args, "-headers", ffmpegHeaders(m.Header))
+	}
+	args = append(args, m.URL)
+
+	cmd := exec.CommandContext(ctx, playerPath, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Stdin = os.Stdin
+	return Status{}, cmd.Run()
+}
+
+// ffmpegHeaders formats header for ffmpeg's -headers option.
+func ffmpegHeaders(header http.Header) string {
+	var b strings.Builder
+	for _, k := range sortedKeys(header) {
+		fmt.Fprintf(&b, "%s: %s\r\n", k, header.Get(k))
+	}
+	return b.String()
+}
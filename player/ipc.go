@@ -0,0 +1,288 @@
+package player
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Properties observed on every IPC connection.
+var observedProperties = []string{"time-pos", "duration", "pause", "eof-reached"}
+
+// Status is the playback state last reported by mpv.
+type Status struct {
+	Position time.Duration
+	Duration time.Duration
+	Paused   bool
+	// EOF is set when the file played to its end rather than being quit.
+	EOF bool
+}
+
+// Event is an asynchronous message from mpv, such as a property change or end-file.
+type Event struct {
+	Name   string          `json:"event"`
+	ID     int             `json:"id"`
+	Prop   string          `json:"name"`
+	Data   json.RawMessage `json:"data"`
+	Reason string          `json:"reason"`
+}
+
+type ipcMessage struct {
+	Event
+	RequestID int             `json:"request_id"`
+	Error     string          `json:"error"`
+	Data      json.RawMessage `json:"data"`
+}
+
+type ipcReply struct {
+	data json.RawMessage
+	err  error
+}
+
+// IPCClient talks to mpv over its JSON IPC socket (--input-ipc-server).
+type IPCClient struct {
+	conn net.Conn
+
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	nextID  int
+	pending map[int]chan ipcReply
+	status  Status
+
+	events chan Event
+	done   chan struct{}
+}
+
+// DialIPC connects to the mpv socket at path and starts reading from it.
+func DialIPC(path string) (*IPCClient, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to mpv socket %q: %w", path, err)
+	}
+
+	c := &IPCClient{
+		conn:    conn,
+		pending: make(map[int]chan ipcReply),
+		events:  make(chan Event, 64),
+		done:    make(chan struct{}),
+	}
+	go c.readLoop()
+	return c, nil
+}
+
+// Command sends a raw mpv command (e.g. "seek", 10, "relative") and waits for its reply.
+func (c *IPCClient) Command(args ...any) (json.RawMessage, error) {
+	c.mu.Lock()
+	c.nextID++
+	id := c.nextID
+	reply := make(chan ipcReply, 1)
+	c.pending[id] = reply
+	c.mu.Unlock()
+
+	payload, err := json.Marshal(map[string]any{"command": args, "request_id": id})
+	if err != nil {
+		c.forget(id)
+		return nil, fmt.Errorf("encoding mpv command: %w", err)
+	}
+
+	c.writeMu.Lock()
+	_, err = c.conn.Write(append(payload, '\n'))
+	c.writeMu.Unlock()
+	if err != nil {
+		c.forget(id)
+		return nil, fmt.Errorf("sending mpv command: %w", err)
+	}
+
+	select {
+	case r := <-reply:
+		return r.data, r.err
+	case <-c.done:
+		return nil, fmt.Errorf("mpv connection closed")
+	}
+}
+
+// ObserveProperty asks mpv to report changes of the named property.
+func (c *IPCClient) ObserveProperty(name string) error {
+	c.mu.Lock()
+	c.nextID++
+	id := c.nextID
+	c.mu.Unlock()
+
+	_, err := c.Command("observe_property", id, name)
+	return err
+}
+
+// PlaylistPos returns the index of the current playlist entry.
+func (c *IPCClient) PlaylistPos() (int, error) {
+	data, err := c.Command("get_property", "playlist-pos")
+	if err != nil {
+		return 0, err
+	}
+	var pos int
+	if err := json.Unmarshal(data, &pos); err != nil {
+		return 0, fmt.Errorf("decoding playlist-pos: %w", err)
+	}
+	return pos, nil
+}
+
+// Seek jumps to an absolute position.
+func (c *IPCClient) Seek(position time.Duration) error {
+	_, err := c.Command("seek", position.Seconds(), "absolute")
+	return err
+}
+
+// SetPause pauses or unpauses playback.
+func (c *IPCClient) SetPause(paused bool) error {
+	_, err := c.Command("set_property", "pause", paused)
+	return err
+}
+
+// LoadFile loads url. mode is one of mpv's loadfile flags: "replace", "append" or "append-play".
+func (c *IPCClient) LoadFile(url, mode string) error {
+	_, err := c.Command("loadfile", url, mode)
+	return err
+}
+
+// AppendFile adds url to the end of the playlist with per-entry options such
+// as force-media-title.
+func (c *IPCClient) AppendFile(url string, options map[string]string) error {
+	opts := make([]string, 0, len(options))
+	for k, v := range options {
+		// %len% quoting keeps commas in values from splitting the list.
+		opts = append(opts, fmt.Sprintf("%s=%%%d%%%s", k, len(v), v))
+	}
+	joined := strings.Join(opts, ",")
+
+	// mpv 0.38 added an insertion index before the options argument; older
+	// versions reject it, so retry without.
+	_, err := c.Command("loadfile", url, "append", -1, joined)
+	if err != nil {
+		_, err = c.Command("loadfile", url, "append", joined)
+	}
+	return err
+}
+
+// SubAdd adds an external subtitle file and selects it.
+func (c *IPCClient) SubAdd(path string) error {
+	_, err := c.Command("sub-add", path, "select")
+	return err
+}
+
+// Status returns the latest playback state.
+func (c *IPCClient) Status() Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.status
+}
+
+// Events delivers property changes and other mpv events. Events are dropped
+// if the channel is not drained.
+func (c *IPCClient) Events() <-chan Event {
+	return c.events
+}
+
+// Done is closed once the connection to mpv is gone.
+func (c *IPCClient) Done() <-chan struct{} {
+	return c.done
+}
+
+// Close disconnects from mpv.
+func (c *IPCClient) Close() error {
+	return c.conn.Close()
+}
+
+func (c *IPCClient) forget(id int) {
+	c.mu.Lock()
+	delete(c.pending, id)
+	c.mu.Unlock()
+}
+
+func (c *IPCClient) readLoop() {
+	defer close(c.done)
+	defer close(c.events)
+
+	scanner := bufio.NewScanner(c.conn)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var msg ipcMessage
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			continue
+		}
+
+		if msg.Event.Name == "" {
+			c.resolve(msg)
+			continue
+		}
+
+		msg.Event.Data = msg.Data
+		c.apply(msg.Event)
+		select {
+		case c.events <- msg.Event:
+		default:
+		}
+	}
+}
+
+func (c *IPCClient) resolve(msg ipcMessage) {
+	c.mu.Lock()
+	reply, ok := c.pending[msg.RequestID]
+	delete(c.pending, msg.RequestID)
+	c.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	if msg.Error != "" && msg.Error != "success" {
+		reply <- ipcReply{err: fmt.Errorf("mpv: %s", msg.Error)}
+		return
+	}
+	reply <- ipcReply{data: msg.Data}
+}
+
+// apply folds an event into the tracked status.
+func (c *IPCClient) apply(e Event) {
+	c.mu.Lock()
+	c.status.Update(e)
+	c.mu.Unlock()
+}
+
+// Update folds an mpv event into the status. Callers reading Events can use
+// it to track the state of each playlist entry in order.
+func (s *Status) Update(e Event) {
+	switch e.Name {
+	case "property-change":
+		switch e.Prop {
+		case "time-pos":
+			// time-pos becomes null once the file is unloaded; keep the last position.
+			var secs float64
+			if json.Unmarshal(e.Data, &secs) == nil && string(e.Data) != "null" {
+				s.Position = seconds(secs)
+			}
+		case "duration":
+			var secs float64
+			if json.Unmarshal(e.Data, &secs) == nil && string(e.Data) != "null" {
+				s.Duration = seconds(secs)
+			}
+		case "pause":
+			json.Unmarshal(e.Data, &s.Paused)
+		case "eof-reached":
+			var eof bool
+			if json.Unmarshal(e.Data, &eof) == nil && eof {
+				s.EOF = true
+			}
+		}
+	case "start-file":
+		*s = Status{}
+	case "end-file":
+		s.EOF = e.Reason == "eof"
+	}
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,237 @@
+package player
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeMPV is a stand-in for mpv's IPC socket. It answers get_property from
+// props and observe_property by reporting the property's current value, as
+// mpv does, and sends any events written to its events channel.
+type fakeMPV struct {
+	path   string
+	props  map[string]any
+	events chan map[string]any
+	ln     net.Listener
+}
+
+func startFakeMPV(t *testing.T, props map[string]any) *fakeMPV {
+	t.Helper()
+	// Unix socket paths are limited to about 100 bytes, which t.TempDir
+	// can exceed.
+	dir, err := os.MkdirTemp("", "kino-ipc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	f := &fakeMPV{
+		path:   filepath.Join(dir, "mpv.sock"),
+		props:  props,
+		events: make(chan map[string]any, 8),
+	}
+	f.ln, err = net.Listen("unix", f.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.ln.Close() })
+	go f.serve()
+	return f
+}
+
+func (f *fakeMPV) serve() {
+	conn, err := f.ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	out := make(chan map[string]any, 16)
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		enc := json.NewEncoder(conn)
+		for msg := range out {
+			if enc.Encode(msg) != nil {
+				return
+			}
+		}
+	}()
+	// Flush pending replies and events before hanging up.
+	defer func() {
+		close(out)
+		<-written
+	}()
+
+	requests := make(chan map[string]any)
+	go func() {
+		defer close(requests)
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			var req map[string]any
+			if json.Unmarshal(scanner.Bytes(), &req) == nil {
+				requests <- req
+			}
+		}
+	}()
+
+	for {
+		select {
+		case req, ok := <-requests:
+			if !ok {
+				return
+			}
+			f.handle(req, out)
+		case ev, ok := <-f.events:
+			if !ok {
+				return // closing events hangs up, like mpv quitting
+			}
+			out <- ev
+		}
+	}
+}
+
+func (f *fakeMPV) handle(req map[string]any, out chan<- map[string]any) {
+	args := req["command"].([]any)
+	reply := map[string]any{"request_id": req["request_id"], "error": "success"}
+	switch args[0] {
+	case "get_property":
+		v, ok := f.props[args[1].(string)]
+		if !ok {
+			reply["error"] = "property unavailable"
+		} else {
+			reply["data"] = v
+		}
+		out <- reply
+	case "observe_property":
+		out <- reply
+		name := args[2].(string)
+		if v, ok := f.props[name]; ok {
+			out <- map[string]any{"event": "property-change", "id": args[1], "name": name, "data": v}
+		}
+	default:
+		out <- reply
+	}
+}
+
+// waitEvent reads events from c until one named name arrives.
+func waitEvent(t *testing.T, c *IPCClient, name string) Event {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case e, ok := <-c.Events():
+			if !ok {
+				t.Fatalf("events closed before %s", name)
+			}
+			if e.Name == name {
+				return e
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for %s", name)
+		}
+	}
+}
+
+func TestIPCGetProperty(t *testing.T) {
+	f := startFakeMPV(t, map[string]any{"duration": 5400.5, "pause": true, "playlist-pos": 2})
+	c, err := DialIPC(f.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	data, err := c.Command("get_property", "duration")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var duration float64
+	if err := json.Unmarshal(data, &duration); err != nil || duration != 5400.5 {
+		t.Errorf("duration = %s, want 5400.5", data)
+	}
+
+	if _, err := c.Command("get_property", "chapter"); err == nil {
+		t.Error("reading a missing property: want error")
+	}
+	if pos, err := c.PlaylistPos(); err != nil || pos != 2 {
+		t.Errorf("PlaylistPos = %d, %v, want 2", pos, err)
+	}
+}
+
+func TestIPCEvents(t *testing.T) {
+	f := startFakeMPV(t, map[string]any{"time-pos": 42.0, "duration": 100.0})
+	c, err := DialIPC(f.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	for _, prop := range []string{"time-pos", "duration"} {
+		if err := c.ObserveProperty(prop); err != nil {
+			t.Fatal(err)
+		}
+		if e := waitEvent(t, c, "property-change"); e.Prop != prop {
+			t.Errorf("property-change for %q, want %q", e.Prop, prop)
+		}
+	}
+	if s := c.Status(); s.Position != 42*time.Second || s.Duration != 100*time.Second {
+		t.Errorf("status = %+v, want position 42s and duration 100s", s)
+	}
+
+	f.events <- map[string]any{"event": "end-file", "reason": "eof"}
+	if e := waitEvent(t, c, "end-file"); e.Reason != "eof" {
+		t.Errorf("end-file reason = %q, want eof", e.Reason)
+	}
+	if !c.Status().EOF {
+		t.Error("status after end-file: EOF not set")
+	}
+}
+
+func TestIPCShutdown(t *testing.T) {
+	t.Run("mpv exits", func(t *testing.T) {
+		f := startFakeMPV(t, nil)
+		c, err := DialIPC(f.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+
+		f.events <- map[string]any{"event": "end-file", "reason": "quit"}
+		close(f.events)
+		waitEvent(t, c, "end-file")
+
+		select {
+		case <-c.Done():
+		case <-time.After(2 * time.Second):
+			t.Fatal("Done not closed after mpv hung up")
+		}
+		if _, ok := <-c.Events(); ok {
+			t.Error("events still open after mpv hung up")
+		}
+		if c.Status().EOF {
+			t.Error("quitting mpv reported EOF")
+		}
+		if _, err := c.Command("get_property", "pause"); err == nil {
+			t.Error("command after hang-up: want error")
+		}
+	})
+
+	t.Run("client closes", func(t *testing.T) {
+		f := startFakeMPV(t, nil)
+		c, err := DialIPC(f.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Close()
+		select {
+		case <-c.Done():
+		case <-time.After(2 * time.Second):
+			t.Fatal("Done not closed after Close")
+		}
+	})
+}
@@ -1,60 +1,182 @@
 package player
 
 import (
+	"context"
 	"fmt"
+	"net/http"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
 )
 
-type Player struct {
-	playerPath string
-	CacheSize  string
-	Title      string
+// How long to wait for mpv to open its IPC socket.
+const (
+	ipcDialTimeout  = 5 * time.Second
+	ipcDialInterval = 50 * time.Millisecond
+)
+
+// MPV plays media with mpv, controlled over its JSON IPC socket. The
+// controller handed to OnControl is an *IPCClient.
+type MPV struct{}
+
+func (*MPV) Name() string { return "mpv" }
+
+func (*MPV) Available() bool {
+	_, err := exec.LookPath("mpv")
+	return err == nil
 }
 
-func New() (*Player, error) {
+// Play runs mpv on m until it exits and returns the last playback state it
+// reported over IPC. The status is zero if the IPC connection failed.
+func (*MPV) Play(ctx context.Context, m Media, opts Options) (Status, error) {
 	playerPath, err := exec.LookPath("mpv")
 	if err != nil {
-		return nil, fmt.Errorf("mpv not found in PATH: %w", err)
+		return Status{}, fmt.Errorf("mpv not found in PATH: %w", err)
 	}
-	
-	return &Player{
-		playerPath: playerPath,
-	}, nil
-}
 
-func (p *Player) Play(url string) error {
 	args := []string{}
-	
-	if p.CacheSize != "" {
-		args = append(args, fmt.Sprintf("--demuxer-max-bytes=%s", p.CacheSize))
-	}
-	
-	if p.Title != "" {
-		args = append(args, fmt.Sprintf("--title=%s", p.Title))
-		args = append(args, fmt.Sprintf("--force-media-title=%s", p.Title))
-	}
-	
-	args = append(args, url)
-	
-	cmd := exec.Command(p.playerPath, args...)
+
+	if opts.CacheSize != "" {
+		args = append(args, fmt.Sprintf("--demuxer-max-bytes=%s", opts.CacheSize))
+	}
+
+	if m.Title != "" {
+		args = append(args, fmt.Sprintf("--title=%s", m.Title))
+		args = append(args, fmt.Sprintf("--force-media-title=%s", m.Title))
+	}
+
+	if opts.Start > 0 {
+		args = append(args, fmt.Sprintf("--start=%d", int(opts.Start.Seconds())))
+	}
+
+	if opts.HLSBitrate > 0 {
+		args = append(args, fmt.Sprintf("--hls-bitrate=%d", opts.HLSBitrate))
+	}
+
+	args = append(args, mpvHeaderArgs(m.Header)...)
+
+	for _, sub := range m.Subtitles {
+		args = append(args, fmt.Sprintf("--sub-file=%s", sub))
+	}
+
+	// mpv on Windows uses named pipes, which DialIPC does not speak.
+	socketPath := ""
+	if runtime.GOOS != "windows" {
+		socketPath = filepath.Join(os.TempDir(), fmt.Sprintf("kino-mpv-%d-%d.sock", os.Getpid(), time.Now().UnixNano()))
+		args = append(args, fmt.Sprintf("--input-ipc-server=%s", socketPath))
+		defer os.Remove(socketPath)
+	}
+
+	args = append(args, m.URL)
+
+	cmd := exec.CommandContext(ctx, playerPath, args...)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	cmd.Stdin = os.Stdin
-	
-	return cmd.Run()
+
+	if err := cmd.Start(); err != nil {
+		return Status{}, err
+	}
+
+	exited := make(chan struct{})
+	ipc := make(chan *IPCClient, 1)
+	var callbacks sync.WaitGroup
+	if socketPath != "" {
+		go func() {
+			ipc <- connect(socketPath, opts.OnControl, exited, &callbacks)
+		}()
+	} else {
+		ipc <- nil
+	}
+
+	err = cmd.Wait()
+	close(exited)
+
+	client := <-ipc
+	if client == nil {
+		return Status{}, err
+	}
+	// mpv closes the socket on exit; wait for the reader to drain what it sent.
+	<-client.Done()
+	callbacks.Wait()
+	return client.Status(), err
 }
 
-func IsAvailable() bool {
-	_, err := exec.LookPath("mpv")
-	return err == nil
+// connect dials the IPC socket once mpv has created it, subscribes to the
+// playback properties and hands the client to onControl.
+func connect(socketPath string, onControl func(Controller), exited <-chan struct{}, callbacks *sync.WaitGroup) *IPCClient {
+	deadline := time.Now().Add(ipcDialTimeout)
+	for {
+		client, err := DialIPC(socketPath)
+		if err == nil {
+			for _, prop := range observedProperties {
+				client.ObserveProperty(prop)
+			}
+			if onControl != nil {
+				callbacks.Add(1)
+				go func() {
+					defer callbacks.Done()
+					onControl(client)
+				}()
+			}
+			return client
+		}
+
+		if time.Now().After(deadline) {
+			return nil
+		}
+		select {
+		case <-exited:
+			return nil
+		case <-time.After(ipcDialInterval):
+		}
+	}
 }
 
-func PlayURL(url string) error {
-	player, err := New()
-	if err != nil {
-		return err
+var _ Controller = (*IPCClient)(nil)
+
+// mpvHeaderArgs passes header to mpv. User-Agent and Referer have options
+// of their own; the rest are appended one by one, since values may contain
+// the commas --http-header-fields splits on.
+func mpvHeaderArgs(header http.Header) []string {
+	var args []string
+	for _, k := range sortedKeys(header) {
+		v := header.Get(k)
+		switch k {
+		case "User-Agent":
+			args = append(args, fmt.Sprintf("--user-agent=%s", v))
+		case "Referer":
+			args = append(args, fmt.Sprintf("--referrer=%s", v))
+		default:
+			args = append(args, fmt.Sprintf("--http-header-fields-append=%s: %s", k, v))
+		}
 	}
-	
-	return player.Play(url)
-}
\ No newline at end of file
+	return args
+}
+
+// FileOptions returns the per-file mpv options that send header, for
+// playlist entries added with AppendFile. They replace the headers the
+// player was started with, which belong to the first entry's host.
+func FileOptions(header http.Header) map[string]string {
+	opts := make(map[string]string)
+	var fields []string
+	for _, k := range sortedKeys(header) {
+		v := header.Get(k)
+		switch k {
+		case "User-Agent":
+			opts["user-agent"] = v
+		case "Referer":
+			opts["referrer"] = v
+		default:
+			// mpv splits the list on commas not escaped with a backslash.
+			fields = append(fields, strings.ReplaceAll(k+": "+v, ",", `\,`))
+		}
+	}
+	// Per-file options cannot append, so the fields go in as one list.
+	opts["http-header-fields"] = strings.Join(fields, ",")
+	return opts
+}
@@ -0,0 +1,25 @@
+package player
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestFileOptions(t *testing.T) {
+	header := http.Header{
+		"User-Agent": {"Mozilla/5.0"},
+		"Referer":    {"https://host.example/"},
+		"Origin":     {"https://host.example"},
+		"X-Token":    {"abc"},
+		"Accept":     {"text/html, */*"},
+	}
+	want := map[string]string{
+		"user-agent":         "Mozilla/5.0",
+		"referrer":           "https://host.example/",
+		"http-header-fields": `Accept: text/html\, */*,Origin: https://host.example,X-Token: abc`,
+	}
+	if got := FileOptions(header); !reflect.DeepEqual(got, want) {
+		t.Errorf("FileOptions = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,259 @@
+package player
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// vlcPollInterval is how often the HTTP interface is asked for the
+	// playback state.
+	vlcPollInterval = time.Second
+	// vlcEndSlack is how close to the end playback must have got when VLC
+	// exits for the file to count as played to its end.
+	vlcEndSlack = 5 * time.Second
+)
+
+// vlcMacPath is where VLC lives on macOS, where it is not usually in PATH.
+const vlcMacPath = "/Applications/VLC.app/Contents/MacOS/VLC"
+
+// VLC plays media with VLC, controlled and followed through its HTTP
+// interface on a random local port.
+type VLC struct{}
+
+func (*VLC) Name() string { return "vlc" }
+
+func (*VLC) Available() bool {
+	_, err := vlcPath()
+	return err == nil
+}
+
+func vlcPath() (string, error) {
+	path, err := exec.LookPath("vlc")
+	if err == nil {
+		return path, nil
+	}
+	if runtime.GOOS == "darwin" {
+		if _, serr := os.Stat(vlcMacPath); serr == nil {
+			return vlcMacPath, nil
+		}
+	}
+	return "", fmt.Errorf("vlc not found in PATH: %w", err)
+}
+
+// Play runs VLC on m until it exits. VLC has no equivalent of CacheSize or
+// HLSBitrate, of m.Header only sends the User-Agent and Referer, and only
+// loads the first of m.Subtitles.
+func (*VLC) Play(ctx context.Context, m Media, opts Options) (Status, error) {
+	playerPath, err := vlcPath()
+	if err != nil {
+		return Status{}, err
+	}
+	port, err := freePort()
+	if err != nil {
+		return Status{}, err
+	}
+	password, err := randomPassword()
+	if err != nil {
+		return Status{}, err
+	}
+
+	args := []string{
+		"--play-and-exit",
+		"--extraintf=http",
+		"--http-host=127.0.0.1",
+		fmt.Sprintf("--http-port=%d", port),
+		fmt.Sprintf("--http-password=%s", password),
+	}
+	if m.Title != "" {
+		args = append(args, fmt.Sprintf("--meta-title=%s", m.Title))
+	}
+	if opts.Start > 0 {
+		args = append(args, fmt.Sprintf("--start-time=%d", int(opts.Start.Seconds())))
+	}
+	if ua := m.Header.Get("User-Agent"); ua != "" {
+		args = append(args, fmt.Sprintf("--http-user-agent=%s", ua))
+	}
+	if referer := m.Header.Get("Referer"); referer != "" {
+		args = append(args, fmt.Sprintf("--http-referrer=%s", referer))
+	}
+	if len(m.Subtitles) > 0 {
+		args = append(args, fmt.Sprintf("--sub-file=%s", m.Subtitles[0]))
+	}
+	args = append(args, m.URL)
+
+	cmd := exec.CommandContext(ctx, playerPath, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Stdin = os.Stdin
+	if err := cmd.Start(); err != nil {
+		return Status{}, err
+	}
+
+	c := &vlcController{
+		base:     fmt.Sprintf("http://127.0.0.1:%d/requests/status.json", port),
+		password: password,
+		client:   &http.Client{Timeout: vlcPollInterval},
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	var callbacks sync.WaitGroup
+	go c.poll(opts.OnControl, &callbacks)
+
+	err = cmd.Wait()
+	close(c.done)
+	// poll may still be starting onControl until it returns.
+	<-c.stopped
+	callbacks.Wait()
+	return c.endStatus(), err
+}
+
+// vlcController talks to VLC's HTTP interface.
+type vlcController struct {
+	base     string
+	password string
+	client   *http.Client
+
+	mu     sync.Mutex
+	status Status
+
+	done chan struct{}
+	// stopped is closed once poll returns.
+	stopped chan struct{}
+}
+
+// vlcStatus is the part of status.json kino reads.
+type vlcStatus struct {
+	Time   float64 `json:"time"`
+	Length float64 `json:"length"`
+	State  string  `json:"state"`
+}
+
+// poll follows the playback state until VLC exits, handing the controller
+// to onControl once the interface first answers.
+func (c *vlcController) poll(onControl func(Controller), callbacks *sync.WaitGroup) {
+	defer close(c.stopped)
+	ticker := time.NewTicker(vlcPollInterval)
+	defer ticker.Stop()
+	up := false
+	for {
+		if err := c.refresh(); err == nil && !up {
+			up = true
+			if onControl != nil {
+				callbacks.Add(1)
+				go func() {
+					defer callbacks.Done()
+					onControl(c)
+				}()
+			}
+		}
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *vlcController) refresh() error {
+	var s vlcStatus
+	if err := c.request(nil, &s); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// VLC reports zeros once the file is unloaded; keep the last position.
+	if s.Length > 0 {
+		c.status.Position = seconds(s.Time)
+		c.status.Duration = seconds(s.Length)
+	}
+	c.status.Paused = s.State == "paused"
+	return nil
+}
+
+func (c *vlcController) request(query url.Values, v any) error {
+	u := c.base
+	if query != nil {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth("", c.password)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("vlc: unexpected status %d", resp.StatusCode)
+	}
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (c *vlcController) Seek(position time.Duration) error {
+	return c.request(url.Values{
+		"command": {"seek"},
+		"val":     {strconv.Itoa(int(position.Seconds()))},
+	}, nil)
+}
+
+func (c *vlcController) SetPause(paused bool) error {
+	command := "pl_forceresume"
+	if paused {
+		command = "pl_forcepause"
+	}
+	return c.request(url.Values{"command": {command}}, nil)
+}
+
+// endStatus returns the last state VLC reported, counting playback that got
+// within vlcEndSlack of the end as finished.
+func (c *vlcController) endStatus() Status {
+	status := c.Status()
+	status.EOF = status.Duration > 0 && status.Position >= status.Duration-vlcEndSlack
+	return status
+}
+
+func (c *vlcController) Status() Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.status
+}
+
+func (c *vlcController) Done() <-chan struct{} {
+	return c.done
+}
+
+// freePort returns a local TCP port that was free a moment ago.
+func freePort() (int, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, fmt.Errorf("finding a free port: %w", err)
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port, nil
+}
+
+func randomPassword() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,135 @@
+package player
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeVLC serves VLC's status.json, recording the commands it is sent.
+type fakeVLC struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	status   string
+	commands []string
+}
+
+func startFakeVLC(t *testing.T, password string) *fakeVLC {
+	f := &fakeVLC{status: `{"time": 42, "length": 100, "state": "playing"}`}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, pw, _ := r.BasicAuth(); pw != password {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/requests/status.json" {
+			http.NotFound(w, r)
+			return
+		}
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if command := r.URL.Query().Get("command"); command != "" {
+			f.commands = append(f.commands, r.URL.RawQuery)
+		}
+		w.Write([]byte(f.status))
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeVLC) setStatus(status string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.status = status
+}
+
+func newVLCController(f *fakeVLC, password string) *vlcController {
+	return &vlcController{
+		base:     f.URL + "/requests/status.json",
+		password: password,
+		client:   f.Client(),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+}
+
+func TestVLCPoll(t *testing.T) {
+	f := startFakeVLC(t, "secret")
+	c := newVLCController(f, "secret")
+
+	controlled := make(chan Status, 2)
+	var callbacks sync.WaitGroup
+	go c.poll(func(ctl Controller) {
+		if err := ctl.Seek(90 * time.Second); err != nil {
+			t.Errorf("Seek: %v", err)
+		}
+		if err := ctl.SetPause(true); err != nil {
+			t.Errorf("SetPause: %v", err)
+		}
+		controlled <- ctl.Status()
+	}, &callbacks)
+
+	select {
+	case status := <-controlled:
+		if status.Position != 42*time.Second || status.Duration != 100*time.Second {
+			t.Errorf("status = %+v, want 42s of 100s", status)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("onControl was not called")
+	}
+
+	// Once the file is unloaded VLC reports zeros; the last position stays.
+	f.setStatus(`{"time": 98, "length": 100, "state": "paused"}`)
+	if err := c.refresh(); err != nil {
+		t.Fatal(err)
+	}
+	f.setStatus(`{"time": 0, "length": 0, "state": "stopped"}`)
+	if err := c.refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	close(c.done)
+	<-c.stopped
+	callbacks.Wait()
+	if len(controlled) != 0 {
+		t.Error("onControl was called more than once")
+	}
+
+	status := c.endStatus()
+	if status.Position != 98*time.Second || !status.EOF {
+		t.Errorf("end status = %+v, want 98s and EOF", status)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	want := []string{"command=seek&val=90", "command=pl_forcepause"}
+	if len(f.commands) != len(want) || f.commands[0] != want[0] || f.commands[1] != want[1] {
+		t.Errorf("commands = %q, want %q", f.commands, want)
+	}
+}
+
+func TestVLCWrongPassword(t *testing.T) {
+	f := startFakeVLC(t, "secret")
+	if err := newVLCController(f, "guess").refresh(); err == nil {
+		t.Error("refresh with the wrong password: want error")
+	}
+}
+
+func TestVLCEndStatus(t *testing.T) {
+	tests := []struct {
+		position, duration time.Duration
+		eof                bool
+	}{
+		{98 * time.Second, 100 * time.Second, true},
+		{95 * time.Second, 100 * time.Second, true},
+		{94 * time.Second, 100 * time.Second, false},
+		{0, 0, false},
+	}
+	for _, tt := range tests {
+		c := &vlcController{status: Status{Position: tt.position, Duration: tt.duration}}
+		if got := c.endStatus().EOF; got != tt.eof {
+			t.Errorf("%v of %v: EOF = %v, want %v", tt.position, tt.duration, got, tt.eof)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"kino/download"
+	"kino/ui"
+)
+
+const progressBarWidth = 24
+
+// progressReporter prints download progress either as a redrawn status line
+// for terminals or as JSON lines for scripts.
+type progressReporter struct {
+	json    bool
+	started time.Time
+	// first is the state at start, so resumed segments do not inflate the rate.
+	first *download.Progress
+}
+
+type progressLine struct {
+	Segments          int     `json:"segments"`
+	TotalSegments     int     `json:"total_segments"`
+	Bytes             int64   `json:"bytes"`
+	BytesPerSecond    float64 `json:"bytes_per_second"`
+	MediaSeconds      float64 `json:"media_seconds"`
+	TotalMediaSeconds float64 `json:"total_media_seconds"`
+	ETASeconds        float64 `json:"eta_seconds,omitempty"`
+}
+
+// newProgressReporter picks the output style for mode "auto", "bar" or
+// "json". Auto uses the status line only when stdout is a terminal.
+func newProgressReporter(mode string) (*progressReporter, error) {
+	switch mode {
+	case "bar":
+		return &progressReporter{}, nil
+	case "json":
+		return &progressReporter{json: true}, nil
+	case "auto", "":
+		return &progressReporter{json: !isTerminal(os.Stdout)}, nil
+	default:
+		return nil, fmt.Errorf("invalid progress mode %q (want auto, bar or json)", mode)
+	}
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func (r *progressReporter) report(p download.Progress) {
+	if r.first == nil {
+		r.first = &p
+		r.started = time.Now()
+	}
+
+	elapsed := time.Since(r.started).Seconds()
+	var speed float64
+	var eta time.Duration
+	if elapsed > 0 {
+		speed = float64(p.Bytes) / elapsed
+		// Estimate from media time fetched per second of wall time, which
+		// copes with segments of uneven length better than counting them.
+		mediaRate := (p.Media - r.first.Media).Seconds() / elapsed
+		if mediaRate > 0 {
+			eta = time.Duration((p.TotalMedia - p.Media).Seconds() / mediaRate * float64(time.Second))
+		}
+	}
+
+	if r.json {
+		line, _ := json.Marshal(progressLine{
+			Segments:          p.Segments,
+			TotalSegments:     p.TotalSegments,
+			Bytes:             p.Bytes,
+			BytesPerSecond:    speed,
+			MediaSeconds:      p.Media.Seconds(),
+			TotalMediaSeconds: p.TotalMedia.Seconds(),
+			ETASeconds:        eta.Seconds(),
+		})
+		fmt.Println(string(line))
+		return
+	}
+
+	fraction := 0.0
+	if p.TotalSegments > 0 {
+		fraction = float64(p.Segments) / float64(p.TotalSegments)
+	}
+	filled := int(fraction * progressBarWidth)
+	bar := strings.Repeat("#", filled) + strings.Repeat(".", progressBarWidth-filled)
+
+	etaText := "--:--:--"
+	if eta > 0 {
+		etaText = ui.FormatClock(eta)
+	}
+	fmt.Printf("\r[%s] %3.0f%% %d/%d segments  %s  %s/s  ETA %s ",
+		bar, fraction*100, p.Segments, p.TotalSegments,
+		ui.FormatBytes(p.Bytes), ui.FormatBytes(int64(speed)), etaText)
+}
+
+// finish ends the status line.
+func (r *progressReporter) finish() {
+	if !r.json {
+		fmt.Println()
+	}
+}
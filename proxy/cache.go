@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cache keeps recently fetched segments and keys in memory, so players that
+// re-request them (after a seek or a quality switch) are served locally.
+// Entries expire after ttl and the least recently used are dropped once the
+// cache holds more than maxBytes.
+type cache struct {
+	maxBytes int64
+	ttl      time.Duration
+
+	mu      sync.Mutex
+	size    int64
+	order   *list.List // of *cacheEntry, most recently used first
+	entries map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key     string
+	body    *body
+	expires time.Time
+}
+
+func newCache(maxBytes int64, ttl time.Duration) *cache {
+	return &cache{
+		maxBytes: maxBytes,
+		ttl:      ttl,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+func (c *cache) get(key string) (*body, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := el.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		c.remove(el)
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return entry.body, true
+}
+
+func (c *cache) put(key string, b *body) {
+	if int64(len(b.data)) > c.maxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+	entry := &cacheEntry{key: key, body: b, expires: time.Now().Add(c.ttl)}
+	c.entries[key] = c.order.PushFront(entry)
+	c.size += int64(len(b.data))
+
+	for c.size > c.maxBytes {
+		c.remove(c.order.Back())
+	}
+}
+
+// removePrefix drops every entry whose key starts with prefix.
+func (c *cache) removePrefix(prefix string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, el := range c.entries {
+		if strings.HasPrefix(key, prefix) {
+			c.remove(el)
+		}
+	}
+}
+
+func (c *cache) remove(el *list.Element) {
+	entry := c.order.Remove(el).(*cacheEntry)
+	delete(c.entries, entry.key)
+	c.size -= int64(len(entry.body.data))
+}
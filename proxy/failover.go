@@ -0,0 +1,328 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"kino/hls"
+)
+
+const (
+	// segmentRetries is how many times a failing segment is retried before
+	// failing over.
+	segmentRetries = 2
+	retryDelay     = 500 * time.Millisecond
+	// recoverInterval is how often a stream that failed over checks whether
+	// its own variant works again.
+	recoverInterval = 30 * time.Second
+	// mirrorTimeout bounds loading a mirror's master playlist.
+	mirrorTimeout = 10 * time.Second
+	// primary is failover.active while segments come from the stream itself.
+	primary = -1
+)
+
+// failover tracks where a media playlist's segments are being fetched from.
+type failover struct {
+	// alternates are loaded the first time the stream fails.
+	alternates []alternate
+	loaded     bool
+	// active indexes alternates, or is primary.
+	active     int
+	switchedAt time.Time
+}
+
+// alternate is another source for a media playlist's segments: the same
+// variant on a mirror, or a lower variant in the same master playlist.
+type alternate struct {
+	label string
+	media *mediaState
+}
+
+// segment fetches segment seq of media playlist v. When it keeps failing,
+// the segment covering the same time is fetched from an alternate instead,
+// and later segments come from there until the variant recovers. If no
+// alternate works either, the stream is resolved again.
+func (s *Session) segment(ctx context.Context, v, seq int) (*body, error) {
+	s.mu.Lock()
+	if v < 0 || v >= len(s.media) {
+		s.mu.Unlock()
+		return nil, errNotFound
+	}
+	m := s.media[v]
+	active := m.failover.active
+	recheck := active != primary && time.Since(m.failover.switchedAt) >= recoverInterval
+	gen := s.generation
+	s.mu.Unlock()
+
+	if active != primary && !recheck {
+		if b, err := s.alternateSegment(ctx, v, active, seq); err == nil {
+			return b, nil
+		}
+	}
+
+	retries := segmentRetries
+	if active != primary {
+		// Only checking whether it has recovered.
+		retries = 0
+	}
+	b, err := s.primarySegment(ctx, v, seq, retries)
+	if err == nil {
+		if active != primary {
+			s.switchTo(v, primary, "its own variant")
+		}
+		return b, nil
+	}
+	if !canFailOver(ctx, err) {
+		return nil, err
+	}
+
+	for i, alt := range s.alternates(ctx, v) {
+		if i == active && !recheck {
+			continue // already failed above
+		}
+		if b, aerr := s.alternateSegment(ctx, v, i, seq); aerr == nil {
+			s.switchTo(v, i, alt.label)
+			return b, nil
+		}
+	}
+
+	// Every source is failing; the stream may have moved to other servers.
+	if rerr := s.refresh(ctx, gen); rerr != nil {
+		s.server.Logf("proxy: %v", rerr)
+		return nil, err
+	}
+	return s.primarySegment(ctx, v, seq, 0)
+}
+
+func (s *Session) switchTo(v, active int, label string) {
+	s.mu.Lock()
+	m := s.media[v]
+	changed := m.failover.active != active
+	m.failover.active = active
+	m.failover.switchedAt = time.Now()
+	s.mu.Unlock()
+
+	if changed {
+		s.server.Logf("proxy: stream %s/%d: switching to %s", s.id, v, label)
+	}
+}
+
+// primarySegment fetches a segment from the media playlist itself, retrying
+// failures that may be temporary.
+func (s *Session) primarySegment(ctx context.Context, v, seq, retries int) (*body, error) {
+	var b *body
+	var err error
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(retryDelay * time.Duration(attempt)):
+			}
+		}
+
+		err = s.withRefresh(ctx, func(ctx context.Context) error {
+			upstream, err := s.lookup(ctx, v, segmentFinder(seq))
+			if err != nil {
+				return err
+			}
+			b, err = s.server.get(ctx, upstream, s.header)
+			return err
+		})
+		if err == nil || !canFailOver(ctx, err) {
+			return b, err
+		}
+	}
+	return nil, err
+}
+
+// alternateSegment fetches the segment of alternate i of media playlist v
+// that starts when segment seq does. The player decodes it with the key, IV
+// and initialization section of seq, so alternates whose segment needs
+// others are skipped.
+func (s *Session) alternateSegment(ctx context.Context, v, i, seq int) (*body, error) {
+	s.mu.Lock()
+	m := s.media[v]
+	var start time.Duration
+	var known bool
+	var want segmentSetup
+	var wantKey, wantInit string
+	if m.playlist != nil {
+		start, known = m.playlist.starts[seq]
+		want = m.playlist.setups[seq]
+		wantKey, wantInit = m.playlist.resource(want.key), m.playlist.resource(want.init)
+	}
+	var alt alternate
+	if i >= 0 && i < len(m.failover.alternates) {
+		alt = m.failover.alternates[i]
+	}
+	s.mu.Unlock()
+
+	if !known || alt.media == nil {
+		return nil, errNotFound
+	}
+
+	playlist, err := s.fetchMediaState(ctx, alt.media)
+	if err != nil {
+		return nil, err
+	}
+	altSeq, ok := segmentAt(playlist, start)
+	if !ok {
+		return nil, errNotFound
+	}
+	got := playlist.setups[altSeq]
+	if got.method != want.method || got.keyFormat != want.keyFormat || got.iv != want.iv || got.initRange != want.initRange ||
+		!s.sameResource(ctx, s.resourceKey(v, want.key), wantKey, playlist.resource(got.key)) ||
+		!s.sameResource(ctx, s.resourceKey(v, want.init), wantInit, playlist.resource(got.init)) {
+		return nil, fmt.Errorf("%s decodes segment %d differently", alt.label, seq)
+	}
+	return s.server.get(ctx, playlist.segments[altSeq], s.header)
+}
+
+// sameResource reports whether the upstream keys or initialization sections
+// a and b, either of which may be "" for none, have the same bytes. a is
+// taken from the cache under cached if it is there, since its server may be
+// the one failing.
+func (s *Session) sameResource(ctx context.Context, cached, a, b string) bool {
+	if a == b {
+		return true
+	}
+	if a == "" || b == "" {
+		return false
+	}
+	pair := [2]string{a, b}
+	s.mu.Lock()
+	same, known := s.sameResources[pair]
+	s.mu.Unlock()
+	if known {
+		return same
+	}
+
+	ab, ok := s.server.cache.get(cached)
+	if !ok {
+		var err error
+		if ab, err = s.server.get(ctx, a, s.header); err != nil {
+			return false
+		}
+	}
+	bb, err := s.server.get(ctx, b, s.header)
+	if err != nil {
+		return false
+	}
+	same = bytes.Equal(ab.data, bb.data)
+
+	s.mu.Lock()
+	if s.sameResources == nil {
+		s.sameResources = make(map[[2]string]bool)
+	}
+	s.sameResources[pair] = same
+	s.mu.Unlock()
+	return same
+}
+
+// segmentAt returns the sequence number of the segment of p playing at
+// start.
+func segmentAt(p *rewrittenMedia, start time.Duration) (int, bool) {
+	best, found := -1, false
+	for seq, s := range p.starts {
+		if s <= start+remapTolerance && (!found || s > p.starts[best]) {
+			best, found = seq, true
+		}
+	}
+	return best, found
+}
+
+// alternates returns the fallbacks for media playlist v, loading them the
+// first time: the same stream on each mirror, then lower variants of this
+// master playlist, best first.
+func (s *Session) alternates(ctx context.Context, v int) []alternate {
+	s.failoverMu.Lock()
+	defer s.failoverMu.Unlock()
+
+	s.mu.Lock()
+	m := s.media[v]
+	if m.failover.loaded {
+		alts := m.failover.alternates
+		s.mu.Unlock()
+		return alts
+	}
+	entry := m.masterEntry
+	mirrors := s.mirrors
+	media := append([]*mediaState(nil), s.media...)
+	s.mu.Unlock()
+
+	var alts []alternate
+	for i, mirror := range mirrors {
+		mctx, cancel := context.WithTimeout(ctx, mirrorTimeout)
+		_, entries, err := s.fetchMaster(mctx, mirror)
+		cancel()
+		if err != nil {
+			s.server.Logf("proxy: mirror %d unavailable: %v", i+1, err)
+			continue
+		}
+		if j, ok := matchEntry(entry, v, len(media), entries); ok {
+			alts = append(alts, alternate{
+				label: fmt.Sprintf("mirror %d", i+1),
+				media: &mediaState{masterEntry: entries[j]},
+			})
+		}
+	}
+
+	if isVariant(entry.tag) {
+		current := bandwidth(entry.tag)
+		var lower []int
+		for j, other := range media {
+			if j != v && isVariant(other.tag) && bandwidth(other.tag) < current {
+				lower = append(lower, j)
+			}
+		}
+		sort.Slice(lower, func(a, b int) bool {
+			return bandwidth(media[lower[a]].tag) > bandwidth(media[lower[b]].tag)
+		})
+		for _, j := range lower {
+			alts = append(alts, alternate{label: describe(media[j].tag, j), media: media[j]})
+		}
+	}
+
+	s.mu.Lock()
+	m.failover.alternates = alts
+	m.failover.loaded = true
+	s.mu.Unlock()
+	return alts
+}
+
+// canFailOver reports whether err is worth trying another source for: server
+// errors, rate limiting and network failures, but not the player giving up.
+func canFailOver(ctx context.Context, err error) bool {
+	if ctx.Err() != nil || errors.Is(err, errNotFound) {
+		return false
+	}
+	var upstream *UpstreamError
+	if errors.As(err, &upstream) {
+		return upstream.StatusCode >= 500 || upstream.StatusCode == http.StatusTooManyRequests
+	}
+	return true
+}
+
+func isVariant(tag string) bool {
+	return strings.HasPrefix(tag, "#EXT-X-STREAM-INF:")
+}
+
+func bandwidth(tag string) int {
+	n, _ := strconv.Atoi(hls.ParseAttributes(tagAttrs(tag))["BANDWIDTH"])
+	return n
+}
+
+func describe(tag string, index int) string {
+	if res := hls.ParseAttributes(tagAttrs(tag))["RESOLUTION"]; res != "" {
+		return res + " variant"
+	}
+	return fmt.Sprintf("variant %d", index)
+}
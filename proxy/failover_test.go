@@ -0,0 +1,269 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+const testVariant = `#EXT-X-STREAM-INF:BANDWIDTH=2000000,RESOLUTION=1280x720`
+
+// fakeHLS serves master and media playlists under a per-source prefix,
+// such as /v1/ for the first resolution of a stream, /v2/ for the next and
+// /mirror/ for a mirror. Segments answer with "<source> <sequence>", and
+// failures are injected by path prefix.
+type fakeHLS struct {
+	*httptest.Server
+
+	mu    sync.Mutex
+	fails map[string]int // path prefix to status
+	// media describes each source's media playlist.
+	media map[string]fakeMedia
+}
+
+type fakeMedia struct {
+	firstSequence int
+	segments      int
+	duration      float64
+	// key, if set, encrypts the segments with AES-128 under a key with
+	// these bytes, and iv with an explicit IV.
+	key string
+	iv  string
+}
+
+func newFakeHLS(t *testing.T) *fakeHLS {
+	f := &fakeHLS{fails: make(map[string]int), media: make(map[string]fakeMedia)}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeHLS) fail(prefix string, status int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.fails[prefix] = status
+}
+
+func (f *fakeHLS) master(source string) string {
+	return fmt.Sprintf("%s/%s/master.m3u8", f.URL, source)
+}
+
+func (f *fakeHLS) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for prefix, status := range f.fails {
+		if strings.HasPrefix(r.URL.Path, prefix) {
+			w.WriteHeader(status)
+			return
+		}
+	}
+
+	source, name, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	media, ok := f.media[source]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	switch {
+	case name == "master.m3u8":
+		fmt.Fprintf(w, "#EXTM3U\n%s\n720/index.m3u8\n", testVariant)
+	case name == "720/index.m3u8":
+		fmt.Fprintf(w, "#EXTM3U\n#EXT-X-TARGETDURATION:%d\n#EXT-X-MEDIA-SEQUENCE:%d\n", int(media.duration), media.firstSequence)
+		if media.key != "" {
+			fmt.Fprint(w, `#EXT-X-KEY:METHOD=AES-128,URI="key.bin"`)
+			if media.iv != "" {
+				fmt.Fprintf(w, ",IV=%s", media.iv)
+			}
+			fmt.Fprintln(w)
+		}
+		for i := 0; i < media.segments; i++ {
+			fmt.Fprintf(w, "#EXTINF:%.3f,\nseg%d.ts\n", media.duration, media.firstSequence+i)
+		}
+		fmt.Fprintln(w, "#EXT-X-ENDLIST")
+	case name == "720/key.bin" && media.key != "":
+		fmt.Fprint(w, media.key)
+	case strings.HasPrefix(name, "720/seg"):
+		var seq int
+		fmt.Sscanf(name, "720/seg%d.ts", &seq)
+		fmt.Fprintf(w, "%s %d", source, seq)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// newTestSession proxies the first of masters, re-resolving to resolved.
+func newTestSession(t *testing.T, f *fakeHLS, masters []string, resolved []string) (*Session, *atomic.Int32) {
+	t.Helper()
+	var resolves atomic.Int32
+	var resolve ResolveFunc
+	if resolved != nil {
+		resolve = func(context.Context) ([]string, error) {
+			resolves.Add(1)
+			return resolved, nil
+		}
+	}
+	srv := &Server{Client: f.Client(), Logf: t.Logf}
+	session, err := srv.NewSession(context.Background(), masters, nil, resolve)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := session.mediaPlaylist(context.Background(), 0); err != nil {
+		t.Fatal(err)
+	}
+	return session, &resolves
+}
+
+func segmentBody(t *testing.T, s *Session, seq int) string {
+	t.Helper()
+	b, err := s.segment(context.Background(), 0, seq)
+	if err != nil {
+		t.Fatalf("segment %d: %v", seq, err)
+	}
+	return string(b.data)
+}
+
+func TestFailoverReresolves(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		// prefix fails from the start of the test: one segment for a
+		// server error, everything for an expired token.
+		prefix string
+	}{
+		{"segment server error", http.StatusServiceUnavailable, "/v1/720/seg2.ts"},
+		{"expired token", http.StatusForbidden, "/v1/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeHLS(t)
+			f.media["v1"] = fakeMedia{firstSequence: 0, segments: 5, duration: 4}
+			f.media["v2"] = fakeMedia{firstSequence: 0, segments: 5, duration: 4}
+			session, resolves := newTestSession(t, f, []string{f.master("v1")}, []string{f.master("v2")})
+
+			if got := segmentBody(t, session, 1); got != "v1 1" {
+				t.Fatalf("before the failure, segment 1 = %q, want %q", got, "v1 1")
+			}
+			f.fail(tt.prefix, tt.status)
+
+			if got := segmentBody(t, session, 2); got != "v2 2" {
+				t.Errorf("segment 2 = %q, want %q", got, "v2 2")
+			}
+			if got := segmentBody(t, session, 3); got != "v2 3" {
+				t.Errorf("segment 3 = %q, want %q", got, "v2 3")
+			}
+			if n := resolves.Load(); n != 1 {
+				t.Errorf("resolved %d times, want 1", n)
+			}
+		})
+	}
+}
+
+func TestFailoverToMirror(t *testing.T) {
+	f := newFakeHLS(t)
+	f.media["v1"] = fakeMedia{firstSequence: 0, segments: 5, duration: 4}
+	// The mirror numbers its segments differently and cuts them shorter,
+	// so only start times line up.
+	f.media["mirror"] = fakeMedia{firstSequence: 100, segments: 10, duration: 2}
+	session, resolves := newTestSession(t, f, []string{f.master("v1"), f.master("mirror")}, []string{f.master("v1")})
+
+	f.fail("/v1/720/seg", http.StatusBadGateway)
+	for _, tt := range []struct {
+		seq  int
+		want string
+	}{
+		{3, "mirror 106"}, // 12s in
+		{4, "mirror 108"}, // 16s in, straight from the mirror
+	} {
+		if got := segmentBody(t, session, tt.seq); got != tt.want {
+			t.Errorf("segment %d = %q, want %q", tt.seq, got, tt.want)
+		}
+	}
+	if n := resolves.Load(); n != 0 {
+		t.Errorf("resolved %d times, want 0 while the mirror works", n)
+	}
+}
+
+func TestFailoverChecksDecoding(t *testing.T) {
+	const iv = "0x000102030405060708090a0b0c0d0e0f"
+	tests := []struct {
+		name    string
+		primary fakeMedia
+		mirror  fakeMedia
+		// cacheKey has the player fetch the primary's key through the
+		// proxy before its server fails.
+		cacheKey bool
+		prefix   string
+		want     string
+	}{
+		{
+			name:    "same key",
+			primary: fakeMedia{segments: 5, duration: 4, key: "k1"},
+			mirror:  fakeMedia{segments: 5, duration: 4, key: "k1"},
+			prefix:  "/v1/720/seg",
+			want:    "mirror 2",
+		},
+		{
+			name:    "different key",
+			primary: fakeMedia{segments: 5, duration: 4, key: "k1"},
+			mirror:  fakeMedia{segments: 5, duration: 4, key: "k2"},
+			prefix:  "/v1/720/seg",
+			want:    "v2 2",
+		},
+		{
+			name:    "unencrypted mirror",
+			primary: fakeMedia{segments: 5, duration: 4, key: "k1"},
+			mirror:  fakeMedia{segments: 5, duration: 4},
+			prefix:  "/v1/720/seg",
+			want:    "v2 2",
+		},
+		{
+			// The IV comes from the sequence number, which differs.
+			name:    "renumbered",
+			primary: fakeMedia{segments: 5, duration: 4, key: "k1"},
+			mirror:  fakeMedia{firstSequence: 100, segments: 5, duration: 4, key: "k1"},
+			prefix:  "/v1/720/seg",
+			want:    "v2 2",
+		},
+		{
+			name:    "renumbered with explicit IVs",
+			primary: fakeMedia{segments: 5, duration: 4, key: "k1", iv: iv},
+			mirror:  fakeMedia{firstSequence: 100, segments: 5, duration: 4, key: "k1", iv: iv},
+			prefix:  "/v1/720/seg",
+			want:    "mirror 102",
+		},
+		{
+			name:     "primary down, key cached",
+			primary:  fakeMedia{segments: 5, duration: 4, key: "k1"},
+			mirror:   fakeMedia{segments: 5, duration: 4, key: "k1"},
+			cacheKey: true,
+			prefix:   "/v1/",
+			want:     "mirror 2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeHLS(t)
+			f.media["v1"] = tt.primary
+			f.media["mirror"] = tt.mirror
+			f.media["v2"] = tt.primary
+			session, _ := newTestSession(t, f, []string{f.master("v1"), f.master("mirror")}, []string{f.master("v2")})
+			if tt.cacheKey {
+				w := httptest.NewRecorder()
+				session.server.ServeHTTP(w, httptest.NewRequest("GET", "/s/"+session.id+"/0/res/0", nil))
+				if w.Code != http.StatusOK {
+					t.Fatalf("fetching the key: %d", w.Code)
+				}
+			}
+
+			f.fail(tt.prefix, http.StatusBadGateway)
+			if got := segmentBody(t, session, 2); got != tt.want {
+				t.Errorf("segment 2 = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
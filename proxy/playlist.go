@@ -0,0 +1,398 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"kino/hls"
+)
+
+var uriAttrPattern = regexp.MustCompile(`,?URI="([^"]*)"`)
+
+// remapTolerance absorbs rounding differences in EXTINF durations when
+// matching segments by start time.
+const remapTolerance = 100 * time.Millisecond
+
+// masterEntry is a media playlist listed in a master playlist.
+type masterEntry struct {
+	url string
+	// tag is the EXT-X-STREAM-INF or EXT-X-MEDIA line describing it, without
+	// its URI, used to find the same stream in a re-resolved master playlist.
+	tag string
+}
+
+// rewriteMaster points the variant streams and renditions of a master
+// playlist at the proxy. It returns the rewritten playlist and the upstream
+// media playlists, whose indexes appear in the proxy paths.
+func rewriteMaster(body string, base *url.URL) (string, []masterEntry, error) {
+	var media []masterEntry
+	index := func(ref, tag string) (string, error) {
+		abs, err := resolve(base, ref)
+		if err != nil {
+			return "", err
+		}
+		for i, m := range media {
+			if m.url == abs {
+				return fmt.Sprintf("%d/index.m3u8", i), nil
+			}
+		}
+		media = append(media, masterEntry{url: abs, tag: tag})
+		return fmt.Sprintf("%d/index.m3u8", len(media)-1), nil
+	}
+
+	var b strings.Builder
+	var err error
+	var streamInf string
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+			continue
+		case strings.HasPrefix(line, "#EXT-X-STREAM-INF:"):
+			streamInf = line
+		case strings.HasPrefix(line, "#EXT-X-MEDIA:"), strings.HasPrefix(line, "#EXT-X-I-FRAME-STREAM-INF:"):
+			tag := uriAttrPattern.ReplaceAllString(line, "")
+			line = replaceURIAttr(line, func(ref string) string {
+				var local string
+				if local, err = index(ref, tag); err != nil {
+					return ref
+				}
+				return local
+			})
+		case !strings.HasPrefix(line, "#"):
+			line, err = index(line, streamInf)
+			streamInf = ""
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	if err := scanner.Err(); err != nil {
+		return "", nil, fmt.Errorf("reading master playlist: %w", err)
+	}
+	if len(media) == 0 {
+		return "", nil, fmt.Errorf("no variant streams in master playlist")
+	}
+	return b.String(), media, nil
+}
+
+// matchEntry returns the index in fresh of the stream old describes: the
+// one with the same tag, else the same resolution, else the one at the same
+// position if the playlists list the same number of streams.
+func matchEntry(old masterEntry, oldIndex int, oldCount int, fresh []masterEntry) (int, bool) {
+	for i, m := range fresh {
+		if m.tag == old.tag {
+			return i, true
+		}
+	}
+	if res := hls.ParseAttributes(tagAttrs(old.tag))["RESOLUTION"]; res != "" {
+		for i, m := range fresh {
+			if hls.ParseAttributes(tagAttrs(m.tag))["RESOLUTION"] == res {
+				return i, true
+			}
+		}
+	}
+	if oldCount == len(fresh) {
+		return oldIndex, true
+	}
+	return 0, false
+}
+
+func tagAttrs(tag string) string {
+	_, attrs, _ := strings.Cut(tag, ":")
+	return attrs
+}
+
+// rewrittenMedia is a media playlist pointed at the proxy.
+type rewrittenMedia struct {
+	text string
+	// segments maps media sequence numbers to upstream segment URLs.
+	segments map[int]string
+	// starts maps media sequence numbers to the segment's start time.
+	starts map[int]time.Duration
+	// resources are the upstream URLs of keys and initialization sections.
+	resources []string
+	// uses says where each of resources first applies, to find the same
+	// resource in a re-fetched copy of the playlist.
+	uses []resourceUse
+	// setups maps media sequence numbers to how the segment is decoded.
+	setups  map[int]segmentSetup
+	endList bool
+}
+
+// segmentSetup is what a player needs besides a segment's bytes to decode
+// it: the key and IV it is encrypted with, and its initialization section.
+// key and init index resources, or are -1 for none.
+type segmentSetup struct {
+	method    string
+	keyFormat string
+	// iv is in lower-case hex, derived from the sequence number if the key
+	// has none.
+	iv        string
+	key       int
+	init      int
+	initRange string
+}
+
+// resource returns the upstream URL of resource n, or "" for none.
+func (m *rewrittenMedia) resource(n int) string {
+	if n < 0 || n >= len(m.resources) {
+		return ""
+	}
+	return m.resources[n]
+}
+
+// resourceUse is the tag a resource is listed in, such as "#EXT-X-KEY", and
+// the start time of the first segment it applies to.
+type resourceUse struct {
+	tag   string
+	start time.Duration
+}
+
+// rewriteMedia points the segments, keys and initialization sections of a
+// media playlist at the proxy. Segments are addressed by media sequence
+// number, which stays the same when upstream URLs change. Segments whose
+// sequence number is in ads are left out, with a discontinuity in their
+// place, and AES-128 segments after them get explicit IVs.
+func rewriteMedia(body string, base *url.URL, ads map[int]bool) (*rewrittenMedia, error) {
+	m := &rewrittenMedia{
+		segments: make(map[int]string),
+		starts:   make(map[int]time.Duration),
+		setups:   make(map[int]segmentSetup),
+	}
+	var start, duration time.Duration
+	// last is the index of the resource most recently looked up.
+	last := -1
+	resource := func(ref, tag string) (string, error) {
+		abs, err := resolve(base, ref)
+		if err != nil {
+			return "", err
+		}
+		for i, u := range m.resources {
+			if u == abs {
+				last = i
+				return fmt.Sprintf("res/%d", i), nil
+			}
+		}
+		m.resources = append(m.resources, abs)
+		m.uses = append(m.uses, resourceUse{tag, start})
+		last = len(m.resources) - 1
+		return fmt.Sprintf("res/%d", last), nil
+	}
+
+	var b strings.Builder
+	var err error
+	sequence := 0
+	skipped := false
+	// Once ads are left out, players number the segments after them lower
+	// than upstream did. Segments whose IV comes from the sequence number
+	// get it spelt out, using keyTag, the AES-128 key they are under.
+	dropped := false
+	keyTag := ""
+	setup := segmentSetup{key: -1, init: -1}
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+			continue
+		case strings.HasPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"):
+			if n, err := strconv.Atoi(strings.TrimPrefix(line, "#EXT-X-MEDIA-SEQUENCE:")); err == nil {
+				sequence = n
+			}
+		case ads[sequence] && segmentTag(line):
+			continue
+		case line == "#EXT-X-DISCONTINUITY":
+			skipped = false
+		case strings.HasPrefix(line, "#EXTINF:"):
+			value, _, _ := strings.Cut(strings.TrimPrefix(line, "#EXTINF:"), ",")
+			if secs, err := strconv.ParseFloat(value, 64); err == nil {
+				duration = time.Duration(secs * float64(time.Second))
+			}
+			if skipped {
+				b.WriteString("#EXT-X-DISCONTINUITY\n")
+				skipped = false
+			}
+		case strings.HasPrefix(line, "#EXT-X-KEY:"), strings.HasPrefix(line, "#EXT-X-MAP:"):
+			tag, _, _ := strings.Cut(line, ":")
+			last = -1
+			line = replaceURIAttr(line, func(ref string) string {
+				var local string
+				if local, err = resource(ref, tag); err != nil {
+					return ref
+				}
+				return local
+			})
+			attrs := hls.ParseAttributes(tagAttrs(line))
+			if tag == "#EXT-X-KEY" {
+				keyTag = ""
+				if attrs["METHOD"] == hls.MethodAES128 && attrs["IV"] == "" {
+					keyTag = line
+				}
+				setup.method, setup.keyFormat, setup.key = "", "", -1
+				setup.iv = strings.ToLower(strings.TrimPrefix(strings.TrimPrefix(attrs["IV"], "0x"), "0X"))
+				if method := attrs["METHOD"]; method != "NONE" {
+					setup.method, setup.keyFormat, setup.key = method, attrs["KEYFORMAT"], last
+				}
+			} else {
+				setup.init, setup.initRange = last, attrs["BYTERANGE"]
+			}
+		case line == "#EXT-X-ENDLIST":
+			m.endList = true
+		case strings.HasPrefix(line, "#EXT-X-STREAM-INF"):
+			return nil, fmt.Errorf("expected a media playlist, got a master playlist")
+		case !strings.HasPrefix(line, "#") && ads[sequence]:
+			sequence++
+			duration = 0
+			skipped = true
+			dropped = true
+			continue
+		case !strings.HasPrefix(line, "#"):
+			var abs string
+			if abs, err = resolve(base, line); err == nil {
+				m.segments[sequence] = abs
+				m.starts[sequence] = start
+				segSetup := setup
+				if segSetup.method != "" && segSetup.iv == "" {
+					segSetup.iv = hex.EncodeToString(hls.SequenceIV(sequence))
+				}
+				m.setups[sequence] = segSetup
+				if dropped && keyTag != "" {
+					fmt.Fprintf(&b, "%s,IV=0x%s\n", keyTag, hex.EncodeToString(hls.SequenceIV(sequence)))
+				}
+				line = fmt.Sprintf("seg/%d%s", sequence, segmentExt(abs))
+				sequence++
+				start += duration
+				duration = 0
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading media playlist: %w", err)
+	}
+	m.text = b.String()
+	return m, nil
+}
+
+// adSequences returns the media sequence numbers of the segments in body
+// that hls.DetectAds takes for ads, and the breaks they belong to.
+func adSequences(body, playlistURL string) (map[int]bool, []hls.AdBreak) {
+	playlist, err := hls.ParseMedia(strings.NewReader(body), playlistURL)
+	if err != nil {
+		return nil, nil
+	}
+	breaks := hls.DetectAds(playlist)
+	ads := make(map[int]bool)
+	for _, b := range breaks {
+		for _, seg := range playlist.Segments[b.First : b.Last+1] {
+			ads[seg.Sequence] = true
+		}
+	}
+	return ads, breaks
+}
+
+// segmentTag reports whether line is a tag applying only to the segment
+// after it, as opposed to the playlist or all following segments.
+func segmentTag(line string) bool {
+	for _, tag := range []string{"#EXTINF:", "#EXT-X-DISCONTINUITY", "#EXT-X-BYTERANGE:", "#EXT-X-PROGRAM-DATE-TIME:", "#EXT-X-GAP"} {
+		if strings.HasPrefix(line, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// remap returns fresh, a re-fetched copy of a media playlist, numbered like
+// original, the copy the player has. Segments are matched by start time, so
+// this works even if the new playlist starts from another media sequence.
+// Keys and initialization sections are matched by their tag and the start
+// of the first segment they apply to.
+func remap(original, fresh *rewrittenMedia) *rewrittenMedia {
+	type segment struct {
+		start time.Duration
+		url   string
+	}
+	byStart := make([]segment, 0, len(fresh.segments))
+	for seq, u := range fresh.segments {
+		byStart = append(byStart, segment{fresh.starts[seq], u})
+	}
+	sort.Slice(byStart, func(i, j int) bool { return byStart[i].start < byStart[j].start })
+
+	out := &rewrittenMedia{
+		text:      original.text,
+		segments:  make(map[int]string, len(original.segments)),
+		starts:    original.starts,
+		resources: make([]string, len(original.resources)),
+		uses:      original.uses,
+		setups:    original.setups,
+		endList:   fresh.endList,
+	}
+	for i, use := range original.uses {
+		for j, u := range fresh.uses {
+			if u.tag == use.tag && (u.start-use.start).Abs() <= remapTolerance {
+				out.resources[i] = fresh.resources[j]
+				break
+			}
+		}
+	}
+	for seq, start := range original.starts {
+		// The last fresh segment starting at or (allowing for rounding)
+		// just after the original one.
+		i := sort.Search(len(byStart), func(i int) bool {
+			return byStart[i].start > start+remapTolerance
+		})
+		if i > 0 {
+			out.segments[seq] = byStart[i-1].url
+		}
+	}
+	return out
+}
+
+// segmentExt keeps the extension of segment formats players recognise and
+// uses .ts for anything else; ffmpeg refuses HLS segments whose extension it
+// does not expect, and some CDNs disguise segments as images.
+func segmentExt(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ".ts"
+	}
+	switch ext := strings.ToLower(path.Ext(u.Path)); ext {
+	case ".ts", ".m4s", ".mp4", ".m4a", ".m4v", ".aac", ".vtt", ".webvtt":
+		return ext
+	}
+	return ".ts"
+}
+
+func replaceURIAttr(line string, fn func(string) string) string {
+	return uriAttrPattern.ReplaceAllStringFunc(line, func(m string) string {
+		ref := uriAttrPattern.FindStringSubmatch(m)[1]
+		prefix, _, _ := strings.Cut(m, "URI=")
+		return prefix + `URI="` + fn(ref) + `"`
+	})
+}
+
+func resolve(base *url.URL, ref string) (string, error) {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return "", fmt.Errorf("invalid URI %q: %w", ref, err)
+	}
+	return base.ResolveReference(u).String(), nil
+}
@@ -0,0 +1,117 @@
+package proxy
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestRewriteMediaWithoutAds(t *testing.T) {
+	const body = `#EXTM3U
+#EXT-X-TARGETDURATION:4
+#EXT-X-MEDIA-SEQUENCE:10
+#EXT-X-KEY:METHOD=AES-128,URI="https://cdn.example/key"
+#EXTINF:4.0,
+a.ts
+#EXT-X-DISCONTINUITY
+#EXTINF:2.0,
+https://ads.example/ad1.ts
+#EXTINF:2.0,
+https://ads.example/ad2.ts
+#EXT-X-DISCONTINUITY
+#EXTINF:4.0,
+b.ts
+#EXTINF:4.0,
+c.ts
+#EXT-X-KEY:METHOD=AES-128,URI="https://cdn.example/key2",IV=0x0123456789abcdef0123456789abcdef
+#EXTINF:4.0,
+d.ts
+#EXT-X-ENDLIST
+`
+	base, _ := url.Parse("https://cdn.example/v/index.m3u8")
+	m, err := rewriteMedia(body, base, map[int]bool{11: true, 12: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const want = `#EXTM3U
+#EXT-X-TARGETDURATION:4
+#EXT-X-MEDIA-SEQUENCE:10
+#EXT-X-KEY:METHOD=AES-128,URI="res/0"
+#EXTINF:4.0,
+seg/10.ts
+#EXT-X-DISCONTINUITY
+#EXTINF:4.0,
+#EXT-X-KEY:METHOD=AES-128,URI="res/0",IV=0x0000000000000000000000000000000d
+seg/13.ts
+#EXTINF:4.0,
+#EXT-X-KEY:METHOD=AES-128,URI="res/0",IV=0x0000000000000000000000000000000e
+seg/14.ts
+#EXT-X-KEY:METHOD=AES-128,URI="res/1",IV=0x0123456789abcdef0123456789abcdef
+#EXTINF:4.0,
+seg/15.ts
+#EXT-X-ENDLIST
+`
+	if m.text != want {
+		t.Errorf("rewritten playlist:\n%s\nwant:\n%s", m.text, want)
+	}
+	if got := m.segments[13]; !strings.HasSuffix(got, "/v/b.ts") {
+		t.Errorf("segment 13 = %q, want b.ts", got)
+	}
+	if _, ok := m.segments[11]; ok {
+		t.Error("ad segment 11 is still listed")
+	}
+}
+
+func TestRemapResources(t *testing.T) {
+	base, _ := url.Parse("https://cdn.example/v/index.m3u8")
+	original, err := rewriteMedia(`#EXTM3U
+#EXT-X-MAP:URI="init.mp4?token=old"
+#EXT-X-KEY:METHOD=AES-128,URI="key1?token=old"
+#EXTINF:4.0,
+a.m4s?token=old
+#EXT-X-KEY:METHOD=AES-128,URI="key2?token=old"
+#EXTINF:4.0,
+b.m4s?token=old
+#EXT-X-ENDLIST
+`, base, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The re-resolved playlist lists the first key before the map and adds
+	// a key of its own, so its resources are numbered differently.
+	fresh, err := rewriteMedia(`#EXTM3U
+#EXT-X-KEY:METHOD=AES-128,URI="key1?token=new"
+#EXT-X-MAP:URI="init.mp4?token=new"
+#EXTINF:4.0,
+a.m4s?token=new
+#EXT-X-KEY:METHOD=AES-128,URI="key2?token=new"
+#EXTINF:4.0,
+b.m4s?token=new
+#EXT-X-KEY:METHOD=AES-128,URI="key3?token=new"
+#EXTINF:4.0,
+c.m4s?token=new
+#EXT-X-ENDLIST
+`, base, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := remap(original, fresh)
+	want := []string{
+		"https://cdn.example/v/init.mp4?token=new",
+		"https://cdn.example/v/key1?token=new",
+		"https://cdn.example/v/key2?token=new",
+	}
+	for n, u := range want {
+		if r, ok := resourceFinder(n)(got); !ok || r != u {
+			t.Errorf("res/%d = %q, want %q", n, r, u)
+		}
+	}
+	if _, ok := resourceFinder(len(want))(got); ok {
+		t.Errorf("res/%d is served, but the player's playlist has no such resource", len(want))
+	}
+	if got.text != original.text {
+		t.Error("remap changed the playlist the player has")
+	}
+}
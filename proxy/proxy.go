@@ -0,0 +1,372 @@
+// Package proxy serves resolved HLS streams on a local HTTP address. Players
+// get stable URLs, while the proxy fetches playlists, keys and segments
+// upstream with the headers the CDN expects.
+package proxy
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	httpclient "kino/internal/client"
+)
+
+const (
+	defaultAddr      = "127.0.0.1:0"
+	defaultCacheSize = 64 << 20
+	cacheTTL         = 2 * time.Minute
+	upstreamTimeout  = 30 * time.Second
+	// segmentTimeout bounds fetching a whole segment, so a stalled one can
+	// be retried or fetched elsewhere before the player runs dry.
+	segmentTimeout = 20 * time.Second
+	playlistType   = "application/vnd.apple.mpegurl"
+)
+
+var errNotFound = errors.New("not found")
+
+// UpstreamError is an unsuccessful response from the stream host.
+type UpstreamError struct {
+	URL        string
+	StatusCode int
+}
+
+func (e *UpstreamError) Error() string {
+	return fmt.Sprintf("unexpected status %d for %q", e.StatusCode, e.URL)
+}
+
+// Server is a local HLS proxy. The zero value listens on a free port on
+// 127.0.0.1 once started.
+type Server struct {
+	// Addr is the address to listen on, e.g. "0.0.0.0:8787" to serve the LAN.
+	Addr   string
+	Client *http.Client
+	// CacheSize is how many bytes of segments and keys are kept in memory.
+	CacheSize int64
+	// Logf, if set, receives upstream failures.
+	Logf func(format string, v ...any)
+	// StripAds leaves segments hls.DetectAds takes for ads out of media
+	// playlists.
+	StripAds bool
+	// Debugf, if set, receives the ad breaks found.
+	Debugf func(format string, v ...any)
+
+	initOnce sync.Once
+	mux      *http.ServeMux
+	cache    *cache
+
+	mu       sync.Mutex
+	sessions map[string]*Session
+	listener net.Listener
+	server   *http.Server
+}
+
+func (s *Server) init() {
+	s.initOnce.Do(func() {
+		if s.Client == nil {
+			s.Client = httpclient.NewMedia(upstreamTimeout)
+		}
+		if s.CacheSize <= 0 {
+			s.CacheSize = defaultCacheSize
+		}
+		if s.Logf == nil {
+			s.Logf = func(string, ...any) {}
+		}
+		if s.Debugf == nil {
+			s.Debugf = func(string, ...any) {}
+		}
+		s.cache = newCache(s.CacheSize, cacheTTL)
+		s.sessions = make(map[string]*Session)
+
+		s.mux = http.NewServeMux()
+		s.mux.HandleFunc("GET /s/{session}/master.m3u8", s.handleMaster)
+		s.mux.HandleFunc("GET /s/{session}/{media}/index.m3u8", s.handleMedia)
+		s.mux.HandleFunc("GET /s/{session}/{media}/seg/{segment}", s.handleSegment)
+		s.mux.HandleFunc("GET /s/{session}/{media}/res/{resource}", s.handleResource)
+	})
+}
+
+// Start listens on Addr and serves in the background until Close.
+func (s *Server) Start() error {
+	s.init()
+
+	addr := s.Addr
+	if addr == "" {
+		addr = defaultAddr
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("starting proxy: %w", err)
+	}
+
+	s.mu.Lock()
+	s.listener = ln
+	s.server = &http.Server{Handler: s, ReadHeaderTimeout: 10 * time.Second}
+	s.mu.Unlock()
+
+	go s.server.Serve(ln)
+	return nil
+}
+
+// Close stops the server.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.server == nil {
+		return nil
+	}
+	return s.server.Close()
+}
+
+// BaseURL returns the URL the server is reachable at from this machine.
+func (s *Server) BaseURL() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener == nil {
+		return ""
+	}
+
+	addr := s.listener.Addr().(*net.TCPAddr)
+	host := "127.0.0.1"
+	if !addr.IP.IsUnspecified() {
+		host = addr.IP.String()
+	}
+	return "http://" + net.JoinHostPort(host, strconv.Itoa(addr.Port))
+}
+
+// NewSession starts proxying the first of masters, sending header with
+// every upstream request. The other masters are mirrors segments are fetched
+// from when the first one fails. If resolve is set, it is used to get fresh
+// master playlists when upstream URLs expire.
+func (s *Server) NewSession(ctx context.Context, masters []string, header http.Header, resolve ResolveFunc) (*Session, error) {
+	s.init()
+	if len(masters) == 0 {
+		return nil, errors.New("no master playlist to proxy")
+	}
+
+	id := make([]byte, 8)
+	rand.Read(id)
+	session := &Session{
+		id:        hex.EncodeToString(id),
+		server:    s,
+		header:    header.Clone(),
+		resolve:   resolve,
+		masterURL: masters[0],
+		mirrors:   masters[1:],
+	}
+	if err := session.loadMaster(ctx); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.sessions[session.id] = session
+	s.mu.Unlock()
+	return session, nil
+}
+
+// ServeHTTP serves the proxied playlists and media.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.init()
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) remove(id string) {
+	s.mu.Lock()
+	delete(s.sessions, id)
+	s.mu.Unlock()
+	s.cache.removePrefix(id + "/")
+}
+
+func (s *Server) session(r *http.Request) (*Session, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[r.PathValue("session")]
+	return session, ok
+}
+
+func (s *Server) handleMaster(w http.ResponseWriter, r *http.Request) {
+	session, ok := s.session(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	session.mu.Lock()
+	text := session.master
+	session.mu.Unlock()
+
+	w.Header().Set("Content-Type", playlistType)
+	io.WriteString(w, text)
+}
+
+func (s *Server) handleMedia(w http.ResponseWriter, r *http.Request) {
+	session, ok := s.session(r)
+	media, err := strconv.Atoi(r.PathValue("media"))
+	if !ok || err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	playlist, err := session.mediaPlaylist(r.Context(), media)
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", playlistType)
+	io.WriteString(w, playlist.text)
+}
+
+func (s *Server) handleSegment(w http.ResponseWriter, r *http.Request) {
+	session, ok := s.session(r)
+	media, err := strconv.Atoi(r.PathValue("media"))
+	name, _, _ := strings.Cut(r.PathValue("segment"), ".")
+	seq, seqErr := strconv.Atoi(name)
+	if !ok || err != nil || seqErr != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if rng := r.Header.Get("Range"); rng != "" {
+		s.serveRange(w, r, func() (*http.Response, error) {
+			return session.fetchRange(r.Context(), media, segmentFinder(seq), rng)
+		})
+		return
+	}
+	s.serveCached(w, r, fmt.Sprintf("%s/%d/seg/%d", session.id, media, seq), func() (*body, error) {
+		return session.segment(r.Context(), media, seq)
+	})
+}
+
+func (s *Server) handleResource(w http.ResponseWriter, r *http.Request) {
+	session, ok := s.session(r)
+	media, err := strconv.Atoi(r.PathValue("media"))
+	n, nErr := strconv.Atoi(r.PathValue("resource"))
+	if !ok || err != nil || nErr != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if rng := r.Header.Get("Range"); rng != "" {
+		s.serveRange(w, r, func() (*http.Response, error) {
+			return session.fetchRange(r.Context(), media, resourceFinder(n), rng)
+		})
+		return
+	}
+	s.serveCached(w, r, session.resourceKey(media, n), func() (*body, error) {
+		return session.resource(r.Context(), media, n)
+	})
+}
+
+// serveCached serves key from the cache, calling fetch on a miss.
+func (s *Server) serveCached(w http.ResponseWriter, r *http.Request, key string, fetch func() (*body, error)) {
+	b, ok := s.cache.get(key)
+	if !ok {
+		var err error
+		if b, err = fetch(); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+		s.cache.put(key, b)
+	}
+
+	if b.contentType != "" {
+		w.Header().Set("Content-Type", b.contentType)
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(b.data)))
+	w.Write(b.data)
+}
+
+// serveRange passes a range request through uncached.
+func (s *Server) serveRange(w http.ResponseWriter, r *http.Request, fetch func() (*http.Response, error)) {
+	resp, err := fetch()
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	for _, h := range []string{"Content-Type", "Content-Range", "Content-Length"} {
+		if v := resp.Header.Get(h); v != "" {
+			w.Header().Set(h, v)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+// body is a fully read upstream response.
+type body struct {
+	data        []byte
+	contentType string
+}
+
+// get fetches all of upstream, giving up if it takes longer than
+// segmentTimeout.
+func (s *Server) get(ctx context.Context, upstream string, header http.Header) (*body, error) {
+	ctx, cancel := context.WithTimeout(ctx, segmentTimeout)
+	defer cancel()
+
+	resp, err := s.fetch(ctx, upstream, header, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading %q: %w", upstream, err)
+	}
+	return &body{data: data, contentType: resp.Header.Get("Content-Type")}, nil
+}
+
+// fetch requests upstream with the session headers, returning an
+// *UpstreamError for unsuccessful responses.
+func (s *Server) fetch(ctx context.Context, upstream string, header http.Header, rng string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", upstream, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request for %q: %w", upstream, err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if rng != "" {
+		req.Header.Set("Range", rng)
+	}
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching %q: %w", upstream, err)
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return nil, &UpstreamError{URL: upstream, StatusCode: resp.StatusCode}
+	}
+	return resp, nil
+}
+
+// fail reports err to the player, passing upstream statuses through.
+func (s *Server) fail(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, errNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Context().Err() != nil {
+		return
+	}
+
+	s.Logf("proxy: %s: %v", r.URL.Path, err)
+	var upstream *UpstreamError
+	if errors.As(err, &upstream) {
+		http.Error(w, err.Error(), upstream.StatusCode)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusBadGateway)
+}
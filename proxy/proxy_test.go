@@ -0,0 +1,39 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSessionClose(t *testing.T) {
+	f := newFakeHLS(t)
+	f.media["v1"] = fakeMedia{firstSequence: 0, segments: 3, duration: 4}
+	srv := &Server{Client: f.Client(), Logf: t.Logf}
+	session, err := srv.NewSession(context.Background(), []string{f.master("v1")}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(path string) int {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w.Code
+	}
+	segment := "/s/" + session.id + "/0/seg/1.ts"
+	if code := get(segment); code != http.StatusOK {
+		t.Fatalf("segment before Close = %d, want 200", code)
+	}
+
+	session.Close()
+	if code := get("/s/" + session.id + "/master.m3u8"); code != http.StatusNotFound {
+		t.Errorf("master after Close = %d, want 404", code)
+	}
+	if code := get(segment); code != http.StatusNotFound {
+		t.Errorf("segment after Close = %d, want 404", code)
+	}
+	if _, ok := srv.cache.get(session.id + "/0/seg/1"); ok {
+		t.Error("segment still cached after Close")
+	}
+}
@@ -0,0 +1,377 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"kino/hls"
+)
+
+const (
+	// maxPlaylistSize bounds how much of an upstream playlist is read.
+	maxPlaylistSize = 16 << 20
+	// expiredRetryDelay is how long to wait before retrying a request that
+	// looked expired, in case the host was only briefly refusing it.
+	expiredRetryDelay = 500 * time.Millisecond
+	// minRefreshInterval stops a stream whose host keeps refusing requests
+	// from being re-resolved in a loop.
+	minRefreshInterval = 30 * time.Second
+)
+
+// ResolveFunc re-runs stream resolution for the same title and returns fresh
+// master playlist URLs: the one to play, followed by mirrors.
+type ResolveFunc func(ctx context.Context) ([]string, error)
+
+// Session is one stream served by the proxy. Its URLs stay the same for as
+// long as the server runs, whatever happens to the upstream URLs behind them.
+type Session struct {
+	id      string
+	server  *Server
+	header  http.Header
+	resolve ResolveFunc
+
+	// refreshMu serialises re-resolution; generation counts them, so
+	// requests that failed on the same upstream URLs only trigger one.
+	refreshMu   sync.Mutex
+	lastRefresh time.Time
+
+	// failoverMu serialises loading alternates, which can be slow.
+	failoverMu sync.Mutex
+
+	mu         sync.Mutex
+	generation int
+	masterURL  string
+	mirrors    []string
+	master     string
+	media      []*mediaState
+	// sameResources remembers which pairs of upstream keys and
+	// initialization sections were found to have the same bytes.
+	sameResources map[[2]string]bool
+}
+
+type mediaState struct {
+	masterEntry
+	playlist *rewrittenMedia
+	// original is the first finished playlist served to the player. Later
+	// copies, fetched after re-resolution, are renumbered to match it.
+	original *rewrittenMedia
+	failover failover
+}
+
+// URL returns the proxied master playlist.
+func (s *Session) URL() string {
+	return fmt.Sprintf("%s/s/%s/master.m3u8", s.server.BaseURL(), s.id)
+}
+
+// resourceKey returns the cache key of resource n of media playlist v.
+func (s *Session) resourceKey(v, n int) string {
+	return fmt.Sprintf("%s/%d/res/%d", s.id, v, n)
+}
+
+// Close stops serving the session and drops its cached segments and keys.
+func (s *Session) Close() {
+	s.server.remove(s.id)
+}
+
+// VariantURL returns the proxied media playlist for the upstream variant
+// URL, which must be listed in the master playlist.
+func (s *Session) VariantURL(upstream string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, m := range s.media {
+		if m.url == upstream {
+			return fmt.Sprintf("%s/s/%s/%d/index.m3u8", s.server.BaseURL(), s.id, i), true
+		}
+	}
+	return "", false
+}
+
+func (s *Session) loadMaster(ctx context.Context) error {
+	text, media, err := s.fetchMaster(ctx, s.masterURL)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.master = text
+	s.media = make([]*mediaState, len(media))
+	for i, m := range media {
+		s.media[i] = &mediaState{masterEntry: m, failover: failover{active: primary}}
+	}
+	return nil
+}
+
+func (s *Session) fetchMaster(ctx context.Context, masterURL string) (string, []masterEntry, error) {
+	body, err := s.fetchPlaylist(ctx, masterURL)
+	if err != nil {
+		return "", nil, err
+	}
+	base, err := url.Parse(masterURL)
+	if err != nil {
+		return "", nil, fmt.Errorf("parsing master URL: %w", err)
+	}
+	text, media, err := rewriteMaster(body, base)
+	if err != nil {
+		return "", nil, fmt.Errorf("%s: %w", masterURL, err)
+	}
+	return text, media, nil
+}
+
+// refresh re-resolves the stream after requests made at generation gen
+// were refused, pointing every media playlist at its fresh equivalent. The
+// player keeps its URLs; only what they are proxied to changes.
+func (s *Session) refresh(ctx context.Context, gen int) error {
+	s.refreshMu.Lock()
+	defer s.refreshMu.Unlock()
+
+	s.mu.Lock()
+	current := s.generation
+	s.mu.Unlock()
+	if current != gen {
+		// Another request already re-resolved the stream.
+		return nil
+	}
+	if s.resolve == nil {
+		return errors.New("stream cannot be re-resolved")
+	}
+	if time.Since(s.lastRefresh) < minRefreshInterval {
+		return fmt.Errorf("stream was re-resolved less than %v ago", minRefreshInterval)
+	}
+	s.lastRefresh = time.Now()
+
+	masters, err := s.resolve(ctx)
+	if err != nil {
+		return fmt.Errorf("re-resolving stream: %w", err)
+	}
+	if len(masters) == 0 {
+		return errors.New("re-resolving stream: no master playlist")
+	}
+	masterURL := masters[0]
+	_, fresh, err := s.fetchMaster(ctx, masterURL)
+	if err != nil {
+		return fmt.Errorf("re-resolving stream: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, m := range s.media {
+		j, ok := matchEntry(m.masterEntry, i, len(s.media), fresh)
+		if !ok {
+			s.server.Logf("proxy: stream %d is missing after re-resolving", i)
+			continue
+		}
+		m.url = fresh[j].url
+		m.playlist = nil
+		m.failover = failover{active: primary}
+	}
+	s.masterURL = masterURL
+	s.mirrors = masters[1:]
+	s.generation++
+	s.server.Logf("proxy: re-resolved stream %s", s.id)
+	return nil
+}
+
+// mediaPlaylist returns media playlist v rewritten for the proxy. Playlists
+// of finished (VOD) streams are fetched once; live ones on every request.
+func (s *Session) mediaPlaylist(ctx context.Context, v int) (*rewrittenMedia, error) {
+	var playlist *rewrittenMedia
+	err := s.withRefresh(ctx, func(ctx context.Context) error {
+		var err error
+		playlist, err = s.fetchMedia(ctx, v)
+		return err
+	})
+	return playlist, err
+}
+
+func (s *Session) fetchMedia(ctx context.Context, v int) (*rewrittenMedia, error) {
+	s.mu.Lock()
+	if v < 0 || v >= len(s.media) {
+		s.mu.Unlock()
+		return nil, errNotFound
+	}
+	m := s.media[v]
+	s.mu.Unlock()
+	return s.fetchMediaState(ctx, m)
+}
+
+// fetchMediaState returns the current copy of m's playlist.
+func (s *Session) fetchMediaState(ctx context.Context, m *mediaState) (*rewrittenMedia, error) {
+	s.mu.Lock()
+	if m.playlist != nil && m.playlist.endList {
+		s.mu.Unlock()
+		return m.playlist, nil
+	}
+	upstream := m.url
+	first := m.playlist == nil
+	s.mu.Unlock()
+
+	body, err := s.fetchPlaylist(ctx, upstream)
+	if err != nil {
+		return nil, err
+	}
+	base, err := url.Parse(upstream)
+	if err != nil {
+		return nil, fmt.Errorf("parsing playlist URL: %w", err)
+	}
+	var ads map[int]bool
+	if s.server.StripAds {
+		var breaks []hls.AdBreak
+		ads, breaks = adSequences(body, upstream)
+		if first {
+			for _, b := range breaks {
+				s.server.Debugf("proxy: stream %s: skipping %s", s.id, b)
+			}
+		}
+	}
+	playlist, err := rewriteMedia(body, base, ads)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", upstream, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if playlist.endList {
+		if m.original == nil {
+			m.original = playlist
+		} else {
+			playlist = remap(m.original, playlist)
+		}
+	}
+	m.playlist = playlist
+	return playlist, nil
+}
+
+// fetchRange fetches part of a segment or resource of media playlist v, for
+// playlists using byte ranges. It does not fail over.
+func (s *Session) fetchRange(ctx context.Context, v int, find func(*rewrittenMedia) (string, bool), rng string) (*http.Response, error) {
+	var resp *http.Response
+	err := s.withRefresh(ctx, func(ctx context.Context) error {
+		upstream, err := s.lookup(ctx, v, find)
+		if err != nil {
+			return err
+		}
+		resp, err = s.server.fetch(ctx, upstream, s.header, rng)
+		return err
+	})
+	return resp, err
+}
+
+// resource fetches key or initialization section n of media playlist v.
+func (s *Session) resource(ctx context.Context, v, n int) (*body, error) {
+	var b *body
+	err := s.withRefresh(ctx, func(ctx context.Context) error {
+		upstream, err := s.lookup(ctx, v, resourceFinder(n))
+		if err != nil {
+			return err
+		}
+		b, err = s.server.get(ctx, upstream, s.header)
+		return err
+	})
+	return b, err
+}
+
+func segmentFinder(seq int) func(*rewrittenMedia) (string, bool) {
+	return func(p *rewrittenMedia) (string, bool) {
+		u, ok := p.segments[seq]
+		return u, ok
+	}
+}
+
+func resourceFinder(n int) func(*rewrittenMedia) (string, bool) {
+	return func(p *rewrittenMedia) (string, bool) {
+		if n < 0 || n >= len(p.resources) || p.resources[n] == "" {
+			return "", false
+		}
+		return p.resources[n], true
+	}
+}
+
+// withRefresh runs fn, and if upstream refuses it twice as expired,
+// re-resolves the stream and runs it once more.
+func (s *Session) withRefresh(ctx context.Context, fn func(context.Context) error) error {
+	s.mu.Lock()
+	gen := s.generation
+	s.mu.Unlock()
+
+	err := fn(ctx)
+	if !isExpired(err) {
+		return err
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(expiredRetryDelay):
+	}
+	if err = fn(ctx); !isExpired(err) {
+		return err
+	}
+
+	if rerr := s.refresh(ctx, gen); rerr != nil {
+		s.server.Logf("proxy: %v", rerr)
+		return err
+	}
+	return fn(ctx)
+}
+
+// isExpired reports whether err is the kind of refusal tokenised CDN URLs
+// give once they expire.
+func isExpired(err error) bool {
+	var upstream *UpstreamError
+	if !errors.As(err, &upstream) {
+		return false
+	}
+	switch upstream.StatusCode {
+	case http.StatusForbidden, http.StatusNotFound, http.StatusGone:
+		return true
+	}
+	return false
+}
+
+// lookup finds an upstream URL in media playlist v, fetching the playlist
+// first if the player skipped it or it has moved on.
+func (s *Session) lookup(ctx context.Context, v int, find func(*rewrittenMedia) (string, bool)) (string, error) {
+	s.mu.Lock()
+	var playlist *rewrittenMedia
+	if v >= 0 && v < len(s.media) {
+		playlist = s.media[v].playlist
+	}
+	s.mu.Unlock()
+
+	if playlist != nil {
+		if u, ok := find(playlist); ok {
+			return u, nil
+		}
+	}
+
+	playlist, err := s.fetchMedia(ctx, v)
+	if err != nil {
+		return "", err
+	}
+	if u, ok := find(playlist); ok {
+		return u, nil
+	}
+	return "", errNotFound
+}
+
+func (s *Session) fetchPlaylist(ctx context.Context, upstream string) (string, error) {
+	resp, err := s.server.fetch(ctx, upstream, s.header, "")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxPlaylistSize))
+	if err != nil {
+		return "", fmt.Errorf("reading %s: %w", upstream, err)
+	}
+	return string(body), nil
+}
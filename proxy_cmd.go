@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+
+	"kino/proxy"
+	"kino/stream"
+	"kino/ui"
+)
+
+const proxyUsage = `usage: kino proxy <query|imdb-id> [flags]`
+
+func runProxy(client *http.Client, args []string) error {
+	fs := flag.NewFlagSet("proxy", flag.ContinueOnError)
+	season := fs.Int("season", 0, "Season number for TV shows")
+	episode := fs.Int("episode", 0, "Episode number for TV shows")
+	addr := fs.String("addr", "127.0.0.1:8787", "Address to listen on; use 0.0.0.0:8787 to serve other devices on the LAN")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), proxyUsage)
+		fs.PrintDefaults()
+	}
+
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected one query or IMDb ID")
+	}
+
+	targets, err := resolveDownloadTargets(client, positional[0], *season, *episode, false)
+	if err != nil {
+		return err
+	}
+	target := targets[0]
+
+	fmt.Println("\nFetching streaming options...")
+	variants, err := stream.GetStreamVariants(target.imdbID, target.mediaType, target.season, target.episode)
+	if err != nil {
+		return fmt.Errorf("failed to get streaming variants: %w", err)
+	}
+
+	srv := &proxy.Server{Addr: *addr, Logf: log.Printf, StripAds: cfg.StripAds, Debugf: debugf}
+	if err := srv.Start(); err != nil {
+		return err
+	}
+	defer srv.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	session, err := srv.NewSession(ctx, masters(&variants[0]), stream.VariantHeader(&variants[0]), reresolver(stream.ResolveOptions{
+		IMDBID:  target.imdbID,
+		Type:    target.mediaType,
+		Season:  target.season,
+		Episode: target.episode,
+	}))
+	if err != nil {
+		return err
+	}
+
+	host := lanHost(*addr)
+	fmt.Printf("\nServing %s\n\n", target.title.Name)
+	fmt.Printf("  %-20s %s\n", "Adaptive", withHost(session.URL(), host))
+	for _, v := range variants {
+		if u, ok := session.VariantURL(v.URL); ok {
+			fmt.Printf("  %-20s %s\n", ui.FormatVariantDisplay(v), withHost(u, host))
+		}
+	}
+	fmt.Println("\nPress Ctrl+C to stop.")
+
+	<-ctx.Done()
+	return nil
+}
+
+// lanHost returns this machine's LAN address when addr listens on all
+// interfaces, so the printed URLs work from other devices.
+func lanHost(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil || (host != "" && !net.ParseIP(host).IsUnspecified()) {
+		return ""
+	}
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return ""
+	}
+	for _, a := range addrs {
+		if ipnet, ok := a.(*net.IPNet); ok && !ipnet.IP.IsLoopback() && ipnet.IP.To4() != nil {
+			return ipnet.IP.String()
+		}
+	}
+	return ""
+}
+
+func withHost(u, host string) string {
+	if host == "" {
+		return u
+	}
+	return strings.Replace(u, "127.0.0.1", host, 1)
+}
+
+var (
+	playbackProxyOnce sync.Once
+	playbackProxy     *proxy.Server
+)
+
+// reresolver runs the resolve pipeline for opts again, for the proxy to use
+// when the stream's URLs expire.
+func reresolver(opts stream.ResolveOptions) proxy.ResolveFunc {
+	return func(ctx context.Context) ([]string, error) {
+		masterURL, mirrors, err := opts.ResolveMaster()
+		if err != nil {
+			return nil, err
+		}
+		return append([]string{masterURL}, mirrors...), nil
+	}
+}
+
+// masters returns the master playlists variant can be played from, its own
+// first.
+func masters(variant *stream.StreamVariant) []string {
+	return append([]string{variant.MasterURL}, variant.Mirrors...)
+}
+
+// playbackURL returns the URL the player should open for variant, resolved
+// with opts: a stable URL on the local proxy, or the upstream URL if the
+// proxy is disabled or cannot be started. Call release once the player is
+// done with the URL.
+func playbackURL(variant *stream.StreamVariant, opts stream.ResolveOptions) (url string, release func()) {
+	if !cfg.Proxy.Enabled || variant.MasterURL == "" {
+		return variant.URL, func() {}
+	}
+
+	playbackProxyOnce.Do(func() {
+		srv := &proxy.Server{Addr: cfg.Proxy.Addr, Logf: log.Printf, StripAds: cfg.StripAds, Debugf: debugf}
+		if err := srv.Start(); err != nil {
+			log.Printf("Warning: %v; playing streams directly", err)
+			return
+		}
+		playbackProxy = srv
+	})
+	if playbackProxy == nil {
+		return variant.URL, func() {}
+	}
+
+	session, err := playbackProxy.NewSession(context.Background(), masters(variant), stream.VariantHeader(variant), reresolver(opts))
+	if err != nil {
+		log.Printf("Warning: Could not proxy stream: %v", err)
+		return variant.URL, func() {}
+	}
+	if variant.Resolution == stream.Auto {
+		return session.URL(), session.Close
+	}
+	if u, ok := session.VariantURL(variant.URL); ok {
+		return u, session.Close
+	}
+	session.Close()
+	return variant.URL, func() {}
+}
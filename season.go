@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"kino/history"
+	"kino/player"
+	"kino/stream"
+	"kino/ui"
+)
+
+type queuedEpisode struct {
+	imdbID  string
+	season  int
+	episode int
+	name    string
+	variant string
+	// subs are the episode's subtitle files, added once it loads.
+	subs []string
+}
+
+// seasonQueue feeds the episodes after the first into mpv's playlist. Each
+// episode is resolved only once the one before it starts playing, so start-up
+// does not wait on the whole season and stream URLs are fresh when used.
+type seasonQueue struct {
+	quality string
+	resume  time.Duration
+
+	mu        sync.Mutex
+	entries   []queuedEpisode // index matches the mpv playlist position
+	appended  int
+	appending bool
+	releases  []func() // of the queued proxy sessions
+	done      bool
+}
+
+// handleSeasonPlayback plays imdbID and the rest of its season as one mpv playlist.
+func handleSeasonPlayback(client *http.Client, imdbID string) error {
+	backend, err := playerBackend()
+	if errors.Is(err, errNoPlayer) {
+		warnPlayerMissing()
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if _, ok := backend.(*player.MPV); !ok {
+		// Queueing the rest of the season needs mpv's playlist.
+		log.Printf("Season playlists need mpv; playing episodes one at a time with %s", backend.Name())
+		return handleStreamingSelection(imdbID, "")
+	}
+
+	_, season, episode := parseIMDbID(imdbID)
+	baseID := strings.Split(imdbID, "/")[0]
+
+	episodes, err := getEpisodes(client, baseID, season)
+	if err != nil {
+		return fmt.Errorf("error getting episodes: %v", err)
+	}
+	sort.Ints(episodes)
+
+	fmt.Println("\nFetching streaming options...")
+	variants, err := stream.GetStreamVariants(imdbID, stream.TV, season, episode)
+	if err != nil {
+		return fmt.Errorf("failed to get streaming variants: %w", err)
+	}
+
+	selectedVariant, err := selectVariant(variants, "")
+	if err != nil {
+		return err
+	}
+
+	title, name := getTitleForPlayer(imdbID, stream.TV, season, episode)
+
+	q := &seasonQueue{
+		quality:  selectedVariant.Resolution,
+		resume:   resumePosition(baseID, season, episode),
+		appended: 1,
+	}
+	q.entries = append(q.entries, queuedEpisode{
+		imdbID: imdbID, season: season, episode: episode,
+		name: name, variant: selectedVariant.Resolution,
+		subs: subtitlePaths(fetchSubtitles(imdbID, season, episode, selectedVariant, subtitleLanguages())),
+	})
+	for _, ep := range episodes {
+		if ep > episode {
+			q.entries = append(q.entries, queuedEpisode{
+				imdbID: fmt.Sprintf("%s/%d-%d", baseID, season, ep), season: season, episode: ep,
+			})
+		}
+	}
+
+	fmt.Printf("\nPlaying season %d from episode %d (%d episodes) in %s...\n",
+		season, episode, len(q.entries), ui.FormatVariantDisplay(*selectedVariant))
+
+	url, release := playbackURL(selectedVariant, stream.ResolveOptions{
+		IMDBID:  imdbID,
+		Type:    stream.TV,
+		Season:  season,
+		Episode: episode,
+	})
+	q.keep(release)
+	defer q.releaseAll()
+	media := player.Media{URL: url, Title: title, Header: stream.VariantHeader(selectedVariant)}
+	_, err = backend.Play(context.Background(), media, player.Options{
+		CacheSize:  *cacheSize,
+		HLSBitrate: autoBitrate(selectedVariant),
+		OnControl: func(c player.Controller) {
+			q.run(c.(*player.IPCClient))
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to play stream: %w", err)
+	}
+	return nil
+}
+
+// run follows the playlist position, records each episode in the watch
+// history and keeps the next episode queued.
+func (q *seasonQueue) run(c *player.IPCClient) {
+	if err := c.ObserveProperty("playlist-pos"); err != nil {
+		log.Printf("Warning: Could not follow playlist, only the first episode will play: %v", err)
+		return
+	}
+
+	var status player.Status
+	current := -1
+	entryIDs := make(map[int]int)
+	// follow records the episode at pos once it starts playing and queues
+	// the one after it.
+	follow := func(pos int) {
+		if pos == current {
+			return
+		}
+		ep, ok := q.entry(pos)
+		if !ok {
+			return
+		}
+		current = pos
+
+		entryIDs[pos] = recordHistory(history.Entry{
+			IMDbID:    strings.Split(ep.imdbID, "/")[0],
+			Title:     ep.name,
+			Season:    ep.season,
+			Episode:   ep.episode,
+			Provider:  stream.Provider,
+			Variant:   ep.variant,
+			StartedAt: time.Now(),
+		})
+		go q.appendAfter(c, pos)
+	}
+
+	for e := range c.Events() {
+		status.Update(e)
+
+		switch {
+		case e.Name == "file-loaded":
+			// Events are dropped when the loop falls behind, so the
+			// position is asked for rather than trusted to have arrived.
+			if pos, err := c.PlaylistPos(); err == nil {
+				follow(pos)
+			} else {
+				log.Printf("Warning: Could not read playlist position: %v", err)
+			}
+			if current == 0 && q.resume > 0 {
+				c.Seek(q.resume)
+				q.resume = 0
+			}
+			// --sub-file would apply to every episode, so each episode's
+			// subtitles are added once it loads. The last one added is
+			// selected, so they go in from least to most preferred.
+			if ep, ok := q.entry(current); ok {
+				for i := len(ep.subs) - 1; i >= 0; i-- {
+					if err := c.SubAdd(ep.subs[i]); err != nil {
+						log.Printf("Warning: Could not load subtitles %s: %v", ep.subs[i], err)
+					}
+				}
+			}
+
+		case e.Name == "end-file" && current >= 0:
+			updateHistory(entryIDs[current], status)
+
+		case e.Name == "property-change" && e.Prop == "playlist-pos":
+			var pos int
+			if json.Unmarshal(e.Data, &pos) == nil {
+				follow(pos)
+			}
+		}
+	}
+}
+
+// keep holds on to release until the season playback ends, or calls it
+// right away if it already has.
+func (q *seasonQueue) keep(release func()) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.done {
+		release()
+		return
+	}
+	q.releases = append(q.releases, release)
+}
+
+// releaseAll releases the proxy sessions of every queued episode.
+func (q *seasonQueue) releaseAll() {
+	q.mu.Lock()
+	releases := q.releases
+	q.releases, q.done = nil, true
+	q.mu.Unlock()
+
+	for _, release := range releases {
+		release()
+	}
+}
+
+func (q *seasonQueue) entry(pos int) (queuedEpisode, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if pos < 0 || pos >= q.appended {
+		return queuedEpisode{}, false
+	}
+	return q.entries[pos], true
+}
+
+// appendAfter resolves and appends the episode following pos unless it is
+// already in the playlist. Episodes that fail to resolve are skipped.
+func (q *seasonQueue) appendAfter(c *player.IPCClient, pos int) {
+	q.mu.Lock()
+	if q.appending || q.appended > pos+1 {
+		q.mu.Unlock()
+		return
+	}
+	q.appending = true
+	q.mu.Unlock()
+
+	defer func() {
+		q.mu.Lock()
+		q.appending = false
+		q.mu.Unlock()
+	}()
+
+	for {
+		q.mu.Lock()
+		if q.appended >= len(q.entries) {
+			q.mu.Unlock()
+			return
+		}
+		ep := q.entries[q.appended]
+		q.mu.Unlock()
+
+		err := q.resolveAndAppend(c, &ep)
+
+		q.mu.Lock()
+		if err == nil {
+			q.entries[q.appended] = ep
+			q.appended++
+			q.mu.Unlock()
+			return
+		}
+		log.Printf("Warning: Skipping S%02dE%02d: %v", ep.season, ep.episode, err)
+		q.entries = append(q.entries[:q.appended], q.entries[q.appended+1:]...)
+		q.mu.Unlock()
+	}
+}
+
+func (q *seasonQueue) resolveAndAppend(c *player.IPCClient, ep *queuedEpisode) error {
+	variants, err := stream.GetStreamVariants(ep.imdbID, stream.TV, ep.season, ep.episode)
+	if err != nil {
+		return err
+	}
+
+	prefs := qualityPreferences()
+	if stream.Adaptive(q.quality) {
+		prefs = probedPreferences(variants, prefs)
+	}
+	variant := stream.Select(stream.WithAuto(variants, prefs), q.quality, prefs)
+	if variant == nil {
+		variant = stream.BestVariant(variants)
+	}
+
+	title, name := getTitleForPlayer(ep.imdbID, stream.TV, ep.season, ep.episode)
+	url, release := playbackURL(variant, stream.ResolveOptions{
+		IMDBID:  ep.imdbID,
+		Type:    stream.TV,
+		Season:  ep.season,
+		Episode: ep.episode,
+	})
+	opts := player.FileOptions(stream.VariantHeader(variant))
+	opts["force-media-title"] = title
+	if err := c.AppendFile(url, opts); err != nil {
+		release()
+		return fmt.Errorf("queueing in mpv: %w", err)
+	}
+	q.keep(release)
+
+	ep.name = name
+	ep.variant = variant.Resolution
+	ep.subs = subtitlePaths(fetchSubtitles(ep.imdbID, ep.season, ep.episode, variant, subtitleLanguages()))
+	return nil
+}
@@ -0,0 +1,121 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"kino/hls"
+)
+
+const (
+	// probeTimeout bounds the whole probe. A segment still downloading by
+	// then is measured on what has arrived.
+	probeTimeout = 8 * time.Second
+	// probeMargin is the share of the measured throughput a variant's
+	// BANDWIDTH may take, leaving room for slow patches and audio.
+	probeMargin = 0.7
+)
+
+// Probe measures the connection to the stream host by downloading the first
+// segment of a mid-bitrate variant, and returns the throughput in bits per
+// second.
+func Probe(ctx context.Context, client *http.Client, variants []StreamVariant) (int, error) {
+	v := midVariant(variants)
+	if v == nil {
+		return 0, errors.New("no variant to probe")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	header := VariantHeader(v)
+	resp, err := probeGet(ctx, client, v.URL, header)
+	if err != nil {
+		return 0, err
+	}
+	playlist, err := hls.ParseMedia(resp.Body, v.URL)
+	resp.Body.Close()
+	if err != nil {
+		return 0, fmt.Errorf("parsing %q: %w", v.URL, err)
+	}
+	if len(playlist.Segments) == 0 {
+		return 0, fmt.Errorf("no segments in %q", v.URL)
+	}
+
+	start := time.Now()
+	resp, err = probeGet(ctx, client, playlist.Segments[0].URI, header)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	n, err := io.Copy(io.Discard, resp.Body)
+	elapsed := time.Since(start)
+	if err != nil && !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return 0, fmt.Errorf("reading segment: %w", err)
+	}
+	if n == 0 || elapsed <= 0 {
+		return 0, errors.New("no data received")
+	}
+	return int(float64(n*8) / elapsed.Seconds()), nil
+}
+
+func probeGet(ctx context.Context, client *http.Client, url string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request for %q: %w", url, err)
+	}
+	req.Header = header.Clone()
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status %d for %q", resp.StatusCode, url)
+	}
+	return resp, nil
+}
+
+// midVariant returns the fixed-resolution variant in the middle of the
+// bandwidth range, rounding down, or nil if there is none.
+func midVariant(variants []StreamVariant) *StreamVariant {
+	var fixed []StreamVariant
+	for _, v := range variants {
+		if v.Resolution != Auto {
+			fixed = append(fixed, v)
+		}
+	}
+	if len(fixed) == 0 {
+		return nil
+	}
+	sort.SliceStable(fixed, func(i, j int) bool {
+		a, _ := strconv.Atoi(fixed[i].Bandwidth)
+		b, _ := strconv.Atoi(fixed[j].Bandwidth)
+		return a < b
+	})
+	return &fixed[(len(fixed)-1)/2]
+}
+
+// WithThroughput returns p with MaxBandwidth lowered so that automatic
+// choices fit within a safety margin of throughput, in bits per second.
+func (p Preferences) WithThroughput(throughput int) Preferences {
+	limit := int(float64(throughput) * probeMargin)
+	if limit > 0 && (p.MaxBandwidth == 0 || limit < p.MaxBandwidth) {
+		p.MaxBandwidth = limit
+	}
+	return p
+}
+
+// Adaptive reports whether quality picks a variant by what the connection
+// can carry ("best", "auto" or "<=720p"), rather than naming one.
+func Adaptive(quality string) bool {
+	quality = strings.ToLower(strings.TrimSpace(quality))
+	return quality == "best" || quality == Auto || strings.HasPrefix(quality, "<=")
+}
@@ -0,0 +1,137 @@
+package stream
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMidVariant(t *testing.T) {
+	v := func(bw string) StreamVariant { return StreamVariant{Resolution: "x" + bw, Bandwidth: bw} }
+	tests := []struct {
+		name     string
+		variants []StreamVariant
+		want     string
+	}{
+		{"odd", []StreamVariant{v("3000"), v("1000"), v("2000")}, "2000"},
+		{"even rounds down", []StreamVariant{v("4000"), v("1000"), v("3000"), v("2000")}, "2000"},
+		{"one", []StreamVariant{v("1000")}, "1000"},
+		{"auto skipped", []StreamVariant{{Resolution: Auto}, v("1000"), v("2000"), v("3000")}, "2000"},
+		{"only auto", []StreamVariant{{Resolution: Auto}}, ""},
+		{"none", nil, ""},
+	}
+	for _, tt := range tests {
+		got := ""
+		if m := midVariant(tt.variants); m != nil {
+			got = m.Bandwidth
+		}
+		if got != tt.want {
+			t.Errorf("%s: midVariant = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWithThroughput(t *testing.T) {
+	tests := []struct {
+		prefs      Preferences
+		throughput int
+		want       int
+	}{
+		{Preferences{}, 10_000_000, 7_000_000},
+		{Preferences{MaxBandwidth: 5_000_000}, 10_000_000, 5_000_000},
+		{Preferences{MaxBandwidth: 9_000_000}, 10_000_000, 7_000_000},
+		{Preferences{}, 0, 0},
+		{Preferences{MaxBandwidth: 5_000_000}, 0, 5_000_000},
+	}
+	for _, tt := range tests {
+		if got := tt.prefs.WithThroughput(tt.throughput).MaxBandwidth; got != tt.want {
+			t.Errorf("%+v with %d bit/s: MaxBandwidth = %d, want %d", tt.prefs, tt.throughput, got, tt.want)
+		}
+	}
+}
+
+func TestProbe(t *testing.T) {
+	// The segment trickles out at about 2 Mbit/s: 125 kB over half a
+	// second.
+	const chunks, chunkSize = 10, 12_500
+	var mu sync.Mutex
+	var probed []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		probed = append(probed, r.URL.Path)
+		mu.Unlock()
+		if r.Header.Get("Referer") != "https://host.example/" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch {
+		case strings.HasSuffix(r.URL.Path, ".m3u8"):
+			fmt.Fprint(w, "#EXTM3U\n#EXT-X-TARGETDURATION:6\n#EXTINF:6.0,\nseg0.ts\n#EXT-X-ENDLIST\n")
+		case strings.HasSuffix(r.URL.Path, "/seg0.ts"):
+			chunk := make([]byte, chunkSize)
+			for i := 0; i < chunks; i++ {
+				time.Sleep(50 * time.Millisecond)
+				w.Write(chunk)
+				w.(http.Flusher).Flush()
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	header := http.Header{"Referer": {"https://host.example/"}}
+	variant := func(res, bw string) StreamVariant {
+		return StreamVariant{Resolution: res, Bandwidth: bw, URL: srv.URL + "/" + bw + "/index.m3u8", Header: header}
+	}
+	variants := []StreamVariant{
+		variant("1920x1080", "6000000"),
+		variant("1280x720", "3000000"),
+		variant("960x540", "1200000"),
+		variant("640x360", "800000"),
+	}
+
+	bps, err := Probe(context.Background(), srv.Client(), WithAuto(variants, Preferences{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Sleeping only ever makes the download slower than planned.
+	if bps > 2_100_000 || bps < 1_000_000 {
+		t.Errorf("throughput = %d bit/s, want about 2 Mbit/s", bps)
+	}
+	if want := []string{"/1200000/index.m3u8", "/1200000/seg0.ts"}; fmt.Sprint(probed) != fmt.Sprint(want) {
+		t.Errorf("probed %v, want the middle variant: %v", probed, want)
+	}
+
+	// With 70% of about 2 Mbit/s to spend, 720p at 3 Mbit/s is too much.
+	if v := Select(variants, "best", Preferences{}.WithThroughput(bps)); v == nil || v.Resolution != "960x540" {
+		t.Errorf("best within %d bit/s = %+v, want 960x540", bps, v)
+	}
+}
+
+func TestProbeErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/empty.m3u8":
+			fmt.Fprint(w, "#EXTM3U\n#EXT-X-ENDLIST\n")
+		default:
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer srv.Close()
+
+	for _, variants := range [][]StreamVariant{
+		nil,
+		{{Resolution: "1280x720", URL: srv.URL + "/gone.m3u8"}},
+		{{Resolution: "1280x720", URL: srv.URL + "/empty.m3u8"}},
+	} {
+		if _, err := Probe(context.Background(), srv.Client(), variants); err == nil {
+			t.Errorf("Probe(%+v): want error", variants)
+		}
+	}
+}
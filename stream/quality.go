@@ -0,0 +1,188 @@
+package stream
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Preferences narrow down the variants Select may pick automatically.
+type Preferences struct {
+	// MaxHeight caps the resolution, e.g. 1080. Zero means no cap.
+	MaxHeight int
+	// MaxBandwidth caps the BANDWIDTH in bits per second. Zero means no cap.
+	MaxBandwidth int
+	// AvoidCodecs lists codecs not to pick, such as "hevc" on machines that
+	// cannot decode it smoothly; see codecFamilies.
+	AvoidCodecs []string
+}
+
+// codecFamilies maps the codec names used in preferences to the sample
+// entry prefixes found in the CODECS attribute.
+var codecFamilies = map[string][]string{
+	"h264": {"avc1", "avc3"},
+	"avc":  {"avc1", "avc3"},
+	"hevc": {"hvc1", "hev1"},
+	"h265": {"hvc1", "hev1"},
+	"av1":  {"av01"},
+	"vp9":  {"vp09"},
+}
+
+// Select returns the variant quality asks for, or nil if none matches:
+//
+//   - "best" and "worst": the highest or lowest bandwidth variant allowed
+//     by prefs
+//   - "<=720p": the best allowed variant no taller than 720 lines
+//   - "auto": the adaptive variant added by WithAuto
+//   - "720p" or "1280x720": that resolution, preferring a variant allowed
+//     by prefs when there are several
+func Select(variants []StreamVariant, quality string, prefs Preferences) *StreamVariant {
+	quality = strings.ToLower(strings.TrimSpace(quality))
+	switch {
+	case quality == "":
+		return nil
+
+	case quality == "best":
+		return BestVariant(prefs.allowed(variants))
+
+	case quality == "worst":
+		return worstVariant(prefs.allowed(variants))
+
+	case strings.HasPrefix(quality, "<="):
+		limit := height(strings.TrimPrefix(quality, "<="))
+		if limit <= 0 {
+			return nil
+		}
+		if prefs.MaxHeight == 0 || limit < prefs.MaxHeight {
+			prefs.MaxHeight = limit
+		}
+		return BestVariant(prefs.allowed(variants))
+	}
+
+	if v := FindVariant(prefs.allowed(variants), quality); v != nil {
+		return v
+	}
+	return FindVariant(variants, quality)
+}
+
+// allowed returns the fixed-resolution variants within p, in their
+// original order.
+func (p Preferences) allowed(variants []StreamVariant) []StreamVariant {
+	var out []StreamVariant
+	for _, v := range variants {
+		if v.Resolution == Auto {
+			continue
+		}
+		if p.MaxHeight > 0 && height(v.Resolution) > p.MaxHeight {
+			continue
+		}
+		if bw, _ := strconv.Atoi(v.Bandwidth); p.MaxBandwidth > 0 && bw > p.MaxBandwidth {
+			continue
+		}
+		if p.avoids(v.Codecs) {
+			continue
+		}
+		out = append(out, v)
+	}
+	return out
+}
+
+func (p Preferences) avoids(codecs string) bool {
+	for _, codec := range strings.Split(codecs, ",") {
+		entry, _, _ := strings.Cut(strings.TrimSpace(codec), ".")
+		for _, avoid := range p.AvoidCodecs {
+			avoid = strings.ToLower(avoid)
+			prefixes, ok := codecFamilies[avoid]
+			if !ok {
+				prefixes = []string{avoid}
+			}
+			for _, prefix := range prefixes {
+				if strings.EqualFold(entry, prefix) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// WithAuto returns variants preceded by an adaptive variant that plays their
+// master playlist. If prefs rule out some variants, the adaptive variant's
+// Bandwidth is that of the best one allowed, for the player to use as a cap.
+func WithAuto(variants []StreamVariant, prefs Preferences) []StreamVariant {
+	if len(variants) == 0 || variants[0].MasterURL == "" {
+		return variants
+	}
+
+	auto := StreamVariant{
+		Resolution: Auto,
+		URL:        variants[0].MasterURL,
+		MasterURL:  variants[0].MasterURL,
+		Mirrors:    variants[0].Mirrors,
+		Header:     variants[0].Header,
+		Subtitles:  variants[0].Subtitles,
+	}
+	if allowed := prefs.allowed(variants); len(allowed) < len(variants) {
+		if best := BestVariant(allowed); best != nil {
+			auto.Bandwidth = best.Bandwidth
+		}
+	}
+
+	return append([]StreamVariant{auto}, variants...)
+}
+
+// FindVariant returns the variant matching quality, given either as a
+// resolution ("1920x1080") or a height ("1080p"), or nil if there is none.
+func FindVariant(variants []StreamVariant, quality string) *StreamVariant {
+	if quality == "" {
+		return nil
+	}
+
+	for i := range variants {
+		if variants[i].Resolution == quality {
+			return &variants[i]
+		}
+	}
+	if h := height(quality); h > 0 {
+		for i := range variants {
+			if height(variants[i].Resolution) == h {
+				return &variants[i]
+			}
+		}
+	}
+	return nil
+}
+
+// BestVariant returns the variant with the highest bandwidth.
+func BestVariant(variants []StreamVariant) *StreamVariant {
+	return byBandwidth(variants, func(a, b int) bool { return a > b })
+}
+
+func worstVariant(variants []StreamVariant) *StreamVariant {
+	return byBandwidth(variants, func(a, b int) bool { return a < b })
+}
+
+// byBandwidth returns the first variant after sorting by bandwidth with
+// less, or nil if there are none.
+func byBandwidth(variants []StreamVariant, less func(a, b int) bool) *StreamVariant {
+	if len(variants) == 0 {
+		return nil
+	}
+	sorted := append([]StreamVariant(nil), variants...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, _ := strconv.Atoi(sorted[i].Bandwidth)
+		b, _ := strconv.Atoi(sorted[j].Bandwidth)
+		return less(a, b)
+	})
+	return &sorted[0]
+}
+
+// height returns the height of a resolution given as "1920x1080" or
+// "1080p", or 0.
+func height(resolution string) int {
+	if _, h, ok := strings.Cut(resolution, "x"); ok {
+		resolution = h
+	}
+	n, _ := strconv.Atoi(strings.TrimSuffix(strings.ToLower(resolution), "p"))
+	return n
+}
@@ -0,0 +1,105 @@
+package stream
+
+import (
+	"testing"
+)
+
+var testVariants = []StreamVariant{
+	{Resolution: "1920x1080", Bandwidth: "6000000", Codecs: "hvc1.1.6.L120.90,mp4a.40.2", MasterURL: "https://cdn.example/master.m3u8"},
+	{Resolution: "1920x1080", Bandwidth: "5000000", Codecs: "avc1.640028,mp4a.40.2", MasterURL: "https://cdn.example/master.m3u8"},
+	{Resolution: "1280x720", Bandwidth: "2800000", Codecs: "avc1.64001f,mp4a.40.2", MasterURL: "https://cdn.example/master.m3u8"},
+	{Resolution: "960x540", Bandwidth: "1500000", Codecs: "avc1.64001f,mp4a.40.2", MasterURL: "https://cdn.example/master.m3u8"},
+	{Resolution: "640x360", Bandwidth: "800000", Codecs: "avc1.42e01e,mp4a.40.2", MasterURL: "https://cdn.example/master.m3u8"},
+}
+
+func TestSelect(t *testing.T) {
+	tests := []struct {
+		name    string
+		quality string
+		prefs   Preferences
+		want    string // "<resolution> <bandwidth>", or "" for nil
+	}{
+		{"empty", "", Preferences{}, ""},
+		{"best", "best", Preferences{}, "1920x1080 6000000"},
+		{"best is case-insensitive", " BEST ", Preferences{}, "1920x1080 6000000"},
+		{"worst", "worst", Preferences{}, "640x360 800000"},
+		{"best avoiding hevc", "best", Preferences{AvoidCodecs: []string{"hevc"}}, "1920x1080 5000000"},
+		{"best avoiding by sample entry", "best", Preferences{AvoidCodecs: []string{"HVC1"}}, "1920x1080 5000000"},
+		{"best under a height cap", "best", Preferences{MaxHeight: 720}, "1280x720 2800000"},
+		{"best under a bandwidth ceiling", "best", Preferences{MaxBandwidth: 2000000}, "960x540 1500000"},
+		{"worst under a ceiling", "worst", Preferences{MaxBandwidth: 2000000}, "640x360 800000"},
+		{"nothing allowed", "best", Preferences{MaxBandwidth: 100000}, ""},
+		{"at most 720p", "<=720p", Preferences{}, "1280x720 2800000"},
+		{"at most 720p under a lower cap", "<=720p", Preferences{MaxHeight: 540}, "960x540 1500000"},
+		{"at most 720p under a higher cap", "<=720p", Preferences{MaxHeight: 1080}, "1280x720 2800000"},
+		{"at most an odd height", "<=600", Preferences{}, "960x540 1500000"},
+		{"at most nonsense", "<=tall", Preferences{}, ""},
+		{"exact height", "720p", Preferences{}, "1280x720 2800000"},
+		{"exact resolution", "960x540", Preferences{}, "960x540 1500000"},
+		{"exact height prefers an allowed variant", "1080p", Preferences{AvoidCodecs: []string{"hevc"}}, "1920x1080 5000000"},
+		// Asking for a resolution by name overrides the preferences.
+		{"exact height outside the preferences", "1080p", Preferences{MaxHeight: 720}, "1920x1080 6000000"},
+		{"missing height", "2160p", Preferences{}, ""},
+		{"auto without WithAuto", "auto", Preferences{}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ""
+			if v := Select(testVariants, tt.quality, tt.prefs); v != nil {
+				got = v.Resolution + " " + v.Bandwidth
+			}
+			if got != tt.want {
+				t.Errorf("Select(%q, %+v) = %q, want %q", tt.quality, tt.prefs, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectAuto(t *testing.T) {
+	v := Select(WithAuto(testVariants, Preferences{}), "auto", Preferences{})
+	if v == nil || v.Resolution != Auto {
+		t.Fatalf("Select(auto) = %+v, want the adaptive variant", v)
+	}
+	// best only considers fixed variants.
+	if v := Select(WithAuto(testVariants, Preferences{}), "best", Preferences{}); v == nil || v.Resolution == Auto {
+		t.Errorf("Select(best) = %+v, want a fixed variant", v)
+	}
+}
+
+func TestWithAuto(t *testing.T) {
+	tests := []struct {
+		name      string
+		variants  []StreamVariant
+		prefs     Preferences
+		wantAuto  bool
+		bandwidth string
+	}{
+		{"no preferences", testVariants, Preferences{}, true, ""},
+		{"capped by height", testVariants, Preferences{MaxHeight: 720}, true, "2800000"},
+		{"capped by codec", testVariants, Preferences{AvoidCodecs: []string{"hevc"}}, true, "5000000"},
+		{"caps that rule out nothing", testVariants, Preferences{MaxHeight: 2160}, true, ""},
+		{"no master playlist", []StreamVariant{{Resolution: "1280x720", URL: "https://cdn.example/720.m3u8"}}, Preferences{}, false, ""},
+		{"no variants", nil, Preferences{}, false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := WithAuto(tt.variants, tt.prefs)
+			if !tt.wantAuto {
+				if len(got) != len(tt.variants) {
+					t.Errorf("WithAuto added a variant: %+v", got)
+				}
+				return
+			}
+			if len(got) != len(tt.variants)+1 {
+				t.Fatalf("WithAuto returned %d variants, want %d", len(got), len(tt.variants)+1)
+			}
+			auto := got[0]
+			if auto.Resolution != Auto || auto.URL != tt.variants[0].MasterURL {
+				t.Errorf("first variant = %+v, want the master playlist as %s", auto, Auto)
+			}
+			if auto.Bandwidth != tt.bandwidth {
+				t.Errorf("auto bandwidth = %q, want %q", auto.Bandwidth, tt.bandwidth)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package stream
 import (
 	"fmt"
 	"log"
+	"net/http"
 
 	"kino/extractor"
 )
@@ -13,11 +14,32 @@ type (
 	StreamVariant  = extractor.StreamVariant
 )
 
+// Provider names the source streams are resolved from.
+const Provider = "vidsrc"
+
+// Header returns the headers stream hosts expect on playlist, key and
+// segment requests.
+func Header() http.Header {
+	return extractor.PlaybackHeader()
+}
+
+// VariantHeader returns the headers v was resolved with, or Header if it
+// has none.
+func VariantHeader(v *StreamVariant) http.Header {
+	if len(v.Header) > 0 {
+		return v.Header
+	}
+	return Header()
+}
+
 const (
 	Movie MediaType = extractor.Movie
 	TV    MediaType = extractor.TV
 )
 
+// Auto is the quality of the adaptive variant added by WithAuto.
+const Auto = extractor.AutoResolution
+
 func GetStreamVariants(imdbID string, mediaType MediaType, season, episode int) ([]StreamVariant, error) {
 	opts := ResolveOptions{IMDBID: imdbID, Type: mediaType, Season: season, Episode: episode}
 
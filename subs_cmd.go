@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"kino/subtitles"
+)
+
+const subsUsage = `usage: kino subs shift [-by 1.5s] [-fps 23.976:25] [-o output] <file>`
+
+func runSubs(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing subs command\n%s", subsUsage)
+	}
+
+	cmd, args := args[0], args[1:]
+	switch cmd {
+	case "shift":
+		fs := flag.NewFlagSet("subs shift", flag.ContinueOnError)
+		by := fs.Duration("by", 0, "Move every cue later by this much, or earlier if negative (e.g. -1.5s)")
+		fps := fs.String("fps", "", "Convert timing between frame rates, from:to (e.g. 23.976:25)")
+		output := fs.String("o", "", "Where to write the result, as .srt or .vtt (default: overwrite the input)")
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		if fs.NArg() != 1 {
+			return fmt.Errorf("expected one subtitle file\n%s", subsUsage)
+		}
+		return shiftSubtitles(fs.Arg(0), *output, *by, *fps)
+
+	default:
+		return fmt.Errorf("unknown subs command %q\n%s", cmd, subsUsage)
+	}
+}
+
+// shiftSubtitles retimes the subtitle file at path for a frame rate change
+// and then moves it by offset, writing it as UTF-8 to output, in the format
+// its extension names.
+func shiftSubtitles(path, output string, offset time.Duration, fps string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	cues, err := subtitles.Parse(data)
+	if err != nil {
+		return fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	if fps != "" {
+		from, to, err := parseFramerates(fps)
+		if err != nil {
+			return err
+		}
+		cues = subtitles.Retime(cues, from, to)
+	}
+	cues = subtitles.Shift(cues, offset)
+
+	if output == "" {
+		output = path
+	}
+	var buf bytes.Buffer
+	if err := subtitles.Write(&buf, cues, filepath.Ext(output)); err != nil {
+		return err
+	}
+	tmp := output + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("writing %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, output); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	fmt.Printf("Wrote %d cues to %s.\n", len(cues), output)
+	return nil
+}
+
+// parseFramerates parses "from:to", such as "23.976:25".
+func parseFramerates(s string) (from, to float64, err error) {
+	a, b, ok := strings.Cut(s, ":")
+	if ok {
+		from, err = strconv.ParseFloat(a, 64)
+		if err == nil {
+			to, err = strconv.ParseFloat(b, 64)
+		}
+	}
+	if !ok || err != nil || from <= 0 || to <= 0 {
+		return 0, 0, fmt.Errorf("invalid frame rates %q (want from:to, e.g. 23.976:25)", s)
+	}
+	return from, to, nil
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"kino/internal/client"
+	"kino/stream"
+	"kino/subtitles"
+)
+
+// subtitleTimeout bounds fetching all subtitle tracks for a title.
+const subtitleTimeout = 30 * time.Second
+
+// subtitleLanguages returns the languages to load subtitles in: those given
+// with -subs, or else the configured ones. -no-subs turns them off.
+func subtitleLanguages() []string {
+	if *noSubs {
+		return nil
+	}
+	if *subs != "" {
+		return parseLanguages(*subs)
+	}
+	return cfg.Subtitles.Languages
+}
+
+// parseLanguages splits a list such as "en,de".
+func parseLanguages(s string) []string {
+	var langs []string
+	for _, lang := range strings.Split(s, ",") {
+		if lang = strings.TrimSpace(lang); lang != "" {
+			langs = append(langs, lang)
+		}
+	}
+	return langs
+}
+
+// fetchSubtitles saves the tracks of v in languages to the cache and
+// returns the files, in order of preference. Languages the stream has no
+// track in are searched for externally. Tracks that cannot be fetched are
+// skipped with a warning.
+func fetchSubtitles(imdbID string, season, episode int, v *stream.StreamVariant, languages []string) []subtitles.File {
+	return loadSubtitles(imdbID, season, episode, v, languages, "")
+}
+
+// downloadSubtitles is fetchSubtitles for the finished download at path.
+// The external search also uses the file's moviehash, which prefers
+// subtitles timed for that exact file. What it finds is saved with the
+// download, so playing it from the library needs no network.
+func downloadSubtitles(imdbID string, season, episode int, v *stream.StreamVariant, languages []string, path string) []subtitles.File {
+	var hash string
+	if len(languages) > 0 && subtitleProvider() != nil {
+		var err error
+		if hash, err = subtitles.Hash(path); err != nil {
+			log.Printf("Warning: Could not hash %s: %v", path, err)
+		}
+	}
+	return loadSubtitles(imdbID, season, episode, v, languages, hash)
+}
+
+// loadSubtitles implements fetchSubtitles, searching externally by hash if
+// it is set.
+func loadSubtitles(imdbID string, season, episode int, v *stream.StreamVariant, languages []string, hash string) []subtitles.File {
+	codes := languageCodes(languages)
+	if len(codes) == 0 {
+		return nil
+	}
+
+	dir, err := subtitles.CacheDir(subtitleKey(imdbID, season, episode))
+	if err != nil {
+		log.Printf("Warning: Could not cache subtitles: %v", err)
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), subtitleTimeout)
+	defer cancel()
+	c := client.NewMedia(subtitleTimeout)
+
+	var files []subtitles.File
+	for _, t := range subtitles.Select(v.Subtitles, codes) {
+		f, err := subtitles.Fetch(ctx, c, stream.VariantHeader(v), t, dir)
+		if err != nil {
+			log.Printf("Warning: %v", err)
+			continue
+		}
+		files = append(files, f)
+	}
+
+	q := subtitles.Query{
+		IMDbID:    strings.Split(imdbID, "/")[0],
+		Season:    season,
+		Episode:   episode,
+		Languages: missingLanguages(codes, files),
+		Hash:      hash,
+	}
+	searchDir := dir
+	if hash != "" {
+		// Results for one file are no use for another copy of the title.
+		if searchDir, err = subtitles.CacheDir(subtitleKey(imdbID, season, episode) + "-" + hash); err != nil {
+			log.Printf("Warning: Could not cache subtitles: %v", err)
+			return sortByLanguage(files, codes)
+		}
+	}
+	files = append(files, searchSubtitles(ctx, q, searchDir)...)
+	return sortByLanguage(files, codes)
+}
+
+// subtitleProvider returns the configured external subtitle search, or nil
+// if none is set up.
+func subtitleProvider() subtitles.Provider {
+	conf := cfg.Subtitles.OpenSubtitles
+	if conf.URL == "" && conf.APIKey == "" {
+		return nil
+	}
+	return subtitles.NewOpenSubtitles(conf.URL, conf.APIKey, client.NewMedia(subtitleTimeout))
+}
+
+// searchSubtitles returns files for q's languages from the external
+// provider, reusing ones saved in dir earlier. Failures are warnings.
+func searchSubtitles(ctx context.Context, q subtitles.Query, dir string) []subtitles.File {
+	var files []subtitles.File
+	var missing []string
+	for _, lang := range q.Languages {
+		if f, ok := subtitles.Cached(dir, lang); ok {
+			files = append(files, f)
+		} else {
+			missing = append(missing, lang)
+		}
+	}
+	p := subtitleProvider()
+	if p == nil || len(missing) == 0 {
+		return files
+	}
+
+	q.Languages = missing
+	results, err := subtitles.SearchCached(ctx, p, q, dir)
+	if err != nil {
+		log.Printf("Warning: Could not search for subtitles: %v", err)
+		return files
+	}
+	for _, r := range subtitles.Best(results, missing) {
+		f, err := p.Download(ctx, r, dir)
+		if err != nil {
+			log.Printf("Warning: Could not download %s subtitles from %s: %v", r.Language, p.Name(), err)
+			continue
+		}
+		files = append(files, f)
+	}
+	return files
+}
+
+// languageCodes returns the ISO 639-1 codes of languages, skipping unknown
+// and repeated ones.
+func languageCodes(languages []string) []string {
+	var codes []string
+	seen := make(map[string]bool)
+	for _, lang := range languages {
+		if code := subtitles.LanguageCode(lang); code != "" && !seen[code] {
+			seen[code] = true
+			codes = append(codes, code)
+		}
+	}
+	return codes
+}
+
+// missingLanguages returns the codes no file is in.
+func missingLanguages(codes []string, files []subtitles.File) []string {
+	var missing []string
+	for _, code := range codes {
+		if !slices.ContainsFunc(files, func(f subtitles.File) bool { return f.Language == code }) {
+			missing = append(missing, code)
+		}
+	}
+	return missing
+}
+
+// sortByLanguage orders files by the preference of their language in codes.
+func sortByLanguage(files []subtitles.File, codes []string) []subtitles.File {
+	slices.SortStableFunc(files, func(a, b subtitles.File) int {
+		return slices.Index(codes, a.Language) - slices.Index(codes, b.Language)
+	})
+	return files
+}
+
+// subtitleKey names the cache directory for a title's subtitles.
+func subtitleKey(imdbID string, season, episode int) string {
+	baseID := strings.Split(imdbID, "/")[0]
+	if season > 0 {
+		return fmt.Sprintf("%s-S%02dE%02d", baseID, season, episode)
+	}
+	return baseID
+}
+
+func subtitlePaths(files []subtitles.File) []string {
+	paths := make([]string, len(files))
+	for i, f := range files {
+		paths[i] = f.Path
+	}
+	return paths
+}
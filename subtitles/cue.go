@@ -0,0 +1,197 @@
+package subtitles
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cue is one timed piece of subtitle text, the model SRT and WebVTT files
+// are parsed into.
+type Cue struct {
+	Start time.Duration
+	End   time.Duration
+	// Text may span lines and keep markup such as <i>.
+	Text string
+	// Settings are WebVTT cue settings such as "align:start", which SRT
+	// has no place for.
+	Settings string
+}
+
+// Parse reads an SRT or WebVTT file, telling them apart by the WEBVTT
+// header. The text is converted to UTF-8 first (see ToUTF8). Identifiers,
+// and WebVTT NOTE, STYLE and REGION blocks, are dropped.
+func Parse(data []byte) ([]Cue, error) {
+	data = bytes.ReplaceAll(ToUTF8(data), []byte("\r\n"), []byte("\n"))
+	data = bytes.ReplaceAll(data, []byte("\r"), []byte("\n"))
+	vtt := bytes.HasPrefix(data, []byte("WEBVTT"))
+
+	var cues []Cue
+	var block []string
+	blockLine := 0
+	flush := func() error {
+		defer func() { block = block[:0] }()
+		if len(block) == 0 {
+			return nil
+		}
+		if vtt && (blockLine == 1 || isVTTMetadata(block[0])) {
+			return nil
+		}
+		timing := slices.IndexFunc(block, func(l string) bool { return strings.Contains(l, "-->") })
+		if timing < 0 || timing > 1 {
+			return fmt.Errorf("line %d: no cue timing", blockLine)
+		}
+		cue, err := parseTiming(block[timing])
+		if err != nil {
+			return fmt.Errorf("line %d: %w", blockLine+timing, err)
+		}
+		cue.Text = strings.Join(block[timing+1:], "\n")
+		cues = append(cues, cue)
+		return nil
+	}
+
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(nil, maxFileSize)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimRight(sc.Text(), " \t")
+		if line == "" {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if len(block) == 0 {
+			blockLine = n
+		}
+		block = append(block, line)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return cues, nil
+}
+
+func isVTTMetadata(line string) bool {
+	for _, kind := range []string{"NOTE", "STYLE", "REGION"} {
+		if line == kind || strings.HasPrefix(line, kind+" ") || strings.HasPrefix(line, kind+"\t") {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTiming parses "00:01:02,500 --> 00:01:04,000" with any WebVTT cue
+// settings after it.
+func parseTiming(line string) (Cue, error) {
+	start, rest, _ := strings.Cut(line, "-->")
+	fields := strings.Fields(rest)
+	if len(fields) == 0 {
+		return Cue{}, fmt.Errorf("invalid cue timing %q", line)
+	}
+	var cue Cue
+	var err error
+	if cue.Start, err = parseTimestamp(strings.TrimSpace(start)); err != nil {
+		return Cue{}, err
+	}
+	if cue.End, err = parseTimestamp(fields[0]); err != nil {
+		return Cue{}, err
+	}
+	cue.Settings = strings.Join(fields[1:], " ")
+	return cue, nil
+}
+
+// parseTimestamp parses "hh:mm:ss,mmm" or "mm:ss.mmm", taking either
+// separator before the milliseconds.
+func parseTimestamp(s string) (time.Duration, error) {
+	invalid := fmt.Errorf("invalid timestamp %q", s)
+	secs, frac, _ := strings.Cut(strings.Replace(s, ",", ".", 1), ".")
+	parts := strings.Split(secs, ":")
+	if len(parts) < 2 || len(parts) > 3 || len(frac) > 3 {
+		return 0, invalid
+	}
+	var d time.Duration
+	for _, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return 0, invalid
+		}
+		d = d*60 + time.Duration(n)
+	}
+	d *= time.Second
+	if frac != "" {
+		ms, err := strconv.Atoi((frac + "00")[:3])
+		if err != nil {
+			return 0, invalid
+		}
+		d += time.Duration(ms) * time.Millisecond
+	}
+	return d, nil
+}
+
+// formatTimestamp writes d as "hh:mm:ss" and milliseconds after sep.
+func formatTimestamp(d time.Duration, sep string) string {
+	d = d.Round(time.Millisecond)
+	ms := d / time.Millisecond
+	return fmt.Sprintf("%02d:%02d:%02d%s%03d", ms/3600000, ms/60000%60, ms/1000%60, sep, ms%1000)
+}
+
+// vttOnlyTags matches WebVTT markup SRT players show literally: voice,
+// class, language and ruby spans, and karaoke timestamps.
+var vttOnlyTags = regexp.MustCompile(`</?(?:c|v|lang|ruby|rt)(?:[.\s][^>]*)?>|<\d[^>]*>`)
+
+// WriteSRT writes cues as an SRT file.
+func WriteSRT(w io.Writer, cues []Cue) error {
+	bw := bufio.NewWriter(w)
+	for i, c := range cues {
+		fmt.Fprintf(bw, "%d\n%s --> %s\n%s\n\n", i+1,
+			formatTimestamp(c.Start, ","), formatTimestamp(c.End, ","),
+			cueText(vttOnlyTags.ReplaceAllString(c.Text, "")))
+	}
+	return bw.Flush()
+}
+
+// WriteVTT writes cues as a WebVTT file.
+func WriteVTT(w io.Writer, cues []Cue) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString("WEBVTT\n\n")
+	for _, c := range cues {
+		timing := formatTimestamp(c.Start, ".") + " --> " + formatTimestamp(c.End, ".")
+		if c.Settings != "" {
+			timing += " " + c.Settings
+		}
+		fmt.Fprintf(bw, "%s\n%s\n\n", timing, cueText(c.Text))
+	}
+	return bw.Flush()
+}
+
+// Write writes cues in the format of ext, ".srt" or ".vtt".
+func Write(w io.Writer, cues []Cue, ext string) error {
+	switch strings.ToLower(ext) {
+	case ".srt":
+		return WriteSRT(w, cues)
+	case ".vtt":
+		return WriteVTT(w, cues)
+	default:
+		return fmt.Errorf("unsupported subtitle format %q (want .srt or .vtt)", ext)
+	}
+}
+
+// cueText drops blank lines, which would end the cue early.
+func cueText(text string) string {
+	var lines []string
+	for _, l := range strings.Split(text, "\n") {
+		if strings.TrimSpace(l) != "" {
+			lines = append(lines, l)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
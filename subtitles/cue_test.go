@@ -0,0 +1,123 @@
+package subtitles
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func ms(n int) time.Duration { return time.Duration(n) * time.Millisecond }
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want []Cue
+	}{
+		{
+			name: "srt",
+			in:   "1\r\n00:00:01,000 --> 00:00:02,500\r\n<i>Hello</i>\r\nthere\r\n\r\n2\r\n01:02:03,004 --> 01:02:04,000\r\nBye\r\n",
+			want: []Cue{
+				{Start: ms(1000), End: ms(2500), Text: "<i>Hello</i>\nthere"},
+				{Start: ms(3723004), End: ms(3724000), Text: "Bye"},
+			},
+		},
+		{
+			name: "vtt",
+			in:   "WEBVTT\nKind: captions\n\nNOTE a comment\n\nintro\n00:01.000 --> 00:02.000 align:start line:10%\n<v Bob>Hi</v>\n\n00:00:03.5 --> 00:00:04.000\nshort fraction\n",
+			want: []Cue{
+				{Start: ms(1000), End: ms(2000), Text: "<v Bob>Hi</v>", Settings: "align:start line:10%"},
+				{Start: ms(3500), End: ms(4000), Text: "short fraction"},
+			},
+		},
+		{
+			name: "bom",
+			in:   "\ufeffWEBVTT\n\n00:00:01.000 --> 00:00:02.000\nHi\n",
+			want: []Cue{{Start: ms(1000), End: ms(2000), Text: "Hi"}},
+		},
+		{
+			name: "cp1251",
+			in:   "1\n00:00:01,000 --> 00:00:02,000\n\xcf\xf0\xe8\xe2\xe5\xf2, \xec\xe8\xf0!\n",
+			want: []Cue{{Start: ms(1000), End: ms(2000), Text: "Привет, мир!"}},
+		},
+		{
+			name: "cp1252",
+			in:   "1\n00:00:01,000 --> 00:00:02,000\nCaf\xe9 cr\xe8me, na\xefve\n",
+			want: []Cue{{Start: ms(1000), End: ms(2000), Text: "Café crème, naïve"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse([]byte(tt.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, in := range []string{
+		"1\nHello\n",
+		"1\n00:00:01,000 --> later\nHello\n",
+		"1\n00:00:01.5000 --> 00:00:02,000\nHello\n",
+	} {
+		if _, err := Parse([]byte(in)); err == nil {
+			t.Errorf("Parse(%q): want error", in)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	cues := []Cue{
+		{Start: ms(1000), End: ms(2500), Text: "<i>Hello</i>\nthere"},
+		{Start: ms(3723004), End: ms(3724000), Text: "Bye", Settings: "align:end"},
+	}
+	tests := []struct {
+		ext  string
+		want string
+	}{
+		{".srt", "1\n00:00:01,000 --> 00:00:02,500\n<i>Hello</i>\nthere\n\n2\n01:02:03,004 --> 01:02:04,000\nBye\n\n"},
+		{".vtt", "WEBVTT\n\n00:00:01.000 --> 00:00:02.500\n<i>Hello</i>\nthere\n\n01:02:03.004 --> 01:02:04.000 align:end\nBye\n\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ext, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := Write(&buf, cues, tt.ext); err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("Write = %q, want %q", buf.String(), tt.want)
+			}
+
+			got, err := Parse(buf.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := cues
+			if tt.ext == ".srt" {
+				// SRT has nowhere to keep cue settings.
+				want = append([]Cue(nil), cues...)
+				want[1].Settings = ""
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("round trip = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestWriteSRTStripsVTTMarkup(t *testing.T) {
+	var buf bytes.Buffer
+	cues := []Cue{{Start: 0, End: ms(1000), Text: "<v Bob><c.yellow>Hi</c> <00:00:00.500><i>there</i></v>"}}
+	if err := WriteSRT(&buf, cues); err != nil {
+		t.Fatal(err)
+	}
+	if want := "1\n00:00:00,000 --> 00:00:01,000\nHi <i>there</i>\n\n"; buf.String() != want {
+		t.Errorf("WriteSRT = %q, want %q", buf.String(), want)
+	}
+}
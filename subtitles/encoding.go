@@ -0,0 +1,58 @@
+package subtitles
+
+import (
+	"bytes"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// ToUTF8 returns subtitle data as UTF-8 without a byte order mark. Data
+// that is not valid UTF-8 is taken to be in a legacy Windows code page:
+// CP1251 when its non-ASCII letters form whole words, as in Cyrillic text,
+// and CP1252 otherwise, as in Western European text with the odd accent.
+func ToUTF8(data []byte) []byte {
+	data = bytes.TrimPrefix(data, []byte("\ufeff"))
+	if utf8.Valid(data) {
+		return data
+	}
+
+	dec := charmap.Windows1252.NewDecoder()
+	if looksCyrillic(data) {
+		dec = charmap.Windows1251.NewDecoder()
+	}
+	out, err := dec.Bytes(data)
+	if err != nil {
+		return data
+	}
+	return out
+}
+
+// looksCyrillic reports whether words spelt entirely with bytes in
+// CP1251's letter range (0xC0-0xFF), as Cyrillic words are, outnumber
+// words mixing them with ASCII letters, as accented words in CP1252 text do.
+func looksCyrillic(data []byte) bool {
+	var cyrillic, accented int
+	var high, ascii int
+	endWord := func() {
+		switch {
+		case ascii == 0 && high >= 2:
+			cyrillic++
+		case ascii > 0 && high > 0:
+			accented++
+		}
+		high, ascii = 0, 0
+	}
+	for _, b := range data {
+		switch {
+		case b >= 0xC0:
+			high++
+		case b >= 'a' && b <= 'z', b >= 'A' && b <= 'Z':
+			ascii++
+		default:
+			endWord()
+		}
+	}
+	endWord()
+	return cyrillic > accented
+}
@@ -0,0 +1,44 @@
+package subtitles
+
+import "testing"
+
+func TestToUTF8(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"utf-8", "Привет, café", "Привет, café"},
+		{"bom", "\ufeffHello", "Hello"},
+		{"cp1251", "\xcf\xf0\xe8\xe2\xe5\xf2, \xec\xe8\xf0!", "Привет, мир!"},
+		{"cp1251 with latin", "<i>\xc4\xe0</i> OK", "<i>Да</i> OK"},
+		{"cp1252", "Caf\xe9 cr\xe8me", "Café crème"},
+		{"cp1252 quotes", "\x93Quoted\x94 \x96 dash", "“Quoted” – dash"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(ToUTF8([]byte(tt.in))); got != tt.want {
+				t.Errorf("ToUTF8(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLooksCyrillic(t *testing.T) {
+	tests := []struct {
+		in   string
+		want bool
+	}{
+		{"\xcf\xf0\xe8\xe2\xe5\xf2", true},
+		{"Caf\xe9", false},
+		{"Stra\xdfe \xfcber Gr\xf6\xdfe", false},
+		{"\xe0 la carte", false},
+		{"<i>\xc4\xe0</i> OK", true},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := looksCyrillic([]byte(tt.in)); got != tt.want {
+			t.Errorf("looksCyrillic(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
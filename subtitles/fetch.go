@@ -0,0 +1,152 @@
+package subtitles
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"kino/hls"
+	"kino/internal/xdg"
+	"kino/metadata"
+)
+
+// maxFileSize bounds how much of a subtitle file or segment is read.
+const maxFileSize = 16 << 20
+
+// File is a subtitle track saved to disk.
+type File struct {
+	Path string
+	// Language is the ISO 639-1 code, or empty if unknown.
+	Language string
+}
+
+// CacheDir returns the directory subtitles for key, such as an IMDb ID, are
+// cached in ($XDG_CACHE_HOME/kino/subtitles/<key>), creating it if needed.
+func CacheDir(key string) (string, error) {
+	base, err := xdg.CacheDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(base, "subtitles", metadata.SanitizeFileName(key))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("creating %s: %w", dir, err)
+	}
+	return dir, nil
+}
+
+// Fetch saves t into dir, named after its language, and returns the file.
+// A copy saved earlier is reused. HLS renditions are joined into a single
+// WebVTT file, and legacy encodings are converted to UTF-8.
+func Fetch(ctx context.Context, client *http.Client, header http.Header, t Track, dir string) (File, error) {
+	name := t.Language
+	if name == "" {
+		name = metadata.SanitizeFileName(t.Label)
+	}
+	if name == "" {
+		name = "subtitles"
+	}
+	file := File{Path: filepath.Join(dir, name+t.Ext()), Language: t.Language}
+
+	if _, err := os.Stat(file.Path); err == nil {
+		return file, nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return File{}, err
+	}
+
+	var data []byte
+	var err error
+	if t.Playlist {
+		data, err = fetchRendition(ctx, client, header, t.URL)
+	} else {
+		data, err = get(ctx, client, header, t.URL)
+	}
+	if err != nil {
+		return File{}, fmt.Errorf("fetching %s subtitles: %w", t.Label, err)
+	}
+
+	if err := writeFile(file.Path, ToUTF8(data)); err != nil {
+		return File{}, err
+	}
+	return file, nil
+}
+
+// writeFile writes data to a temporary file and renames it over path, so an
+// interrupted write never leaves a truncated subtitle file in the cache.
+func writeFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("writing %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// fetchRendition downloads the WebVTT segments of an HLS subtitle playlist
+// and joins them, keeping only the first segment's header.
+func fetchRendition(ctx context.Context, client *http.Client, header http.Header, playlistURL string) ([]byte, error) {
+	body, err := get(ctx, client, header, playlistURL)
+	if err != nil {
+		return nil, err
+	}
+	playlist, err := hls.ParseMedia(bytes.NewReader(body), playlistURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	for i, seg := range playlist.Segments {
+		data, err := get(ctx, client, header, seg.URI)
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 {
+			data = stripVTTHeader(data)
+		}
+		out.Write(bytes.TrimRight(data, "\r\n"))
+		out.WriteString("\n\n")
+	}
+	return out.Bytes(), nil
+}
+
+// stripVTTHeader removes the WEBVTT line and the header block after it, up
+// to the first blank line.
+func stripVTTHeader(data []byte) []byte {
+	data = bytes.ReplaceAll(ToUTF8(data), []byte("\r\n"), []byte("\n"))
+	if !bytes.HasPrefix(data, []byte("WEBVTT")) {
+		return data
+	}
+	_, cues, _ := bytes.Cut(data, []byte("\n\n"))
+	return cues
+}
+
+func get(ctx context.Context, client *http.Client, header http.Header, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request for %q: %w", url, err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching %q: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d for %q", resp.StatusCode, url)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxFileSize))
+	if err != nil {
+		return nil, fmt.Errorf("reading %q: %w", url, err)
+	}
+	return data, nil
+}
@@ -0,0 +1,43 @@
+package subtitles
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// hashChunk is how much of each end of a file the moviehash reads.
+const hashChunk = 64 << 10
+
+// Hash returns the OpenSubtitles moviehash of the video at path: its size
+// plus the sum of the first and last 64KiB read as little-endian uint64s,
+// in hex.
+func Hash(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	size := info.Size()
+	if size < hashChunk {
+		return "", fmt.Errorf("%s is too small to hash", path)
+	}
+
+	hash := uint64(size)
+	buf := make([]byte, hashChunk)
+	for _, off := range []int64{0, size - hashChunk} {
+		if _, err := f.ReadAt(buf, off); err != nil && err != io.EOF {
+			return "", fmt.Errorf("reading %s: %w", path, err)
+		}
+		for i := 0; i < hashChunk; i += 8 {
+			hash += binary.LittleEndian.Uint64(buf[i:])
+		}
+	}
+	return fmt.Sprintf("%016x", hash), nil
+}
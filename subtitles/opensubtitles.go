@@ -0,0 +1,174 @@
+package subtitles
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// OpenSubtitlesURL is the OpenSubtitles REST API.
+const OpenSubtitlesURL = "https://api.opensubtitles.com/api/v1"
+
+// apiUserAgent identifies kino to the API, which rejects browser agents.
+const apiUserAgent = "kino v1.0"
+
+// OpenSubtitles searches an OpenSubtitles-compatible REST API.
+type OpenSubtitles struct {
+	baseURL string
+	apiKey  string
+	client  *http.Client
+}
+
+// NewOpenSubtitles returns a provider for the API at baseURL, or
+// OpenSubtitlesURL if it is empty.
+func NewOpenSubtitles(baseURL, apiKey string, client *http.Client) *OpenSubtitles {
+	if baseURL == "" {
+		baseURL = OpenSubtitlesURL
+	}
+	return &OpenSubtitles{baseURL: strings.TrimRight(baseURL, "/"), apiKey: apiKey, client: client}
+}
+
+func (o *OpenSubtitles) Name() string { return "opensubtitles" }
+
+type searchResponse struct {
+	Data []struct {
+		Attributes struct {
+			Language       string `json:"language"`
+			Release        string `json:"release"`
+			DownloadCount  int    `json:"download_count"`
+			MoviehashMatch bool   `json:"moviehash_match"`
+			Files          []struct {
+				FileID int `json:"file_id"`
+			} `json:"files"`
+		} `json:"attributes"`
+	} `json:"data"`
+}
+
+// Search looks q up by IMDb ID (the show's, with the season and episode,
+// for an episode) and moviehash.
+func (o *OpenSubtitles) Search(ctx context.Context, q Query) ([]Result, error) {
+	id, err := strconv.Atoi(strings.TrimLeft(strings.TrimPrefix(q.IMDbID, "tt"), "0"))
+	if err != nil && q.Hash == "" {
+		return nil, fmt.Errorf("invalid IMDb ID %q", q.IMDbID)
+	}
+
+	params := url.Values{}
+	if id > 0 {
+		if q.Season > 0 {
+			params.Set("parent_imdb_id", strconv.Itoa(id))
+			params.Set("season_number", strconv.Itoa(q.Season))
+			params.Set("episode_number", strconv.Itoa(q.Episode))
+		} else {
+			params.Set("imdb_id", strconv.Itoa(id))
+		}
+	}
+	if q.Hash != "" {
+		params.Set("moviehash", q.Hash)
+	}
+	if len(q.Languages) > 0 {
+		langs := append([]string(nil), q.Languages...)
+		sort.Strings(langs) // the API redirects unsorted lists
+		params.Set("languages", strings.Join(langs, ","))
+	}
+
+	var resp searchResponse
+	if err := o.do(ctx, "GET", "/subtitles?"+params.Encode(), nil, &resp); err != nil {
+		return nil, err
+	}
+
+	var results []Result
+	for _, d := range resp.Data {
+		a := d.Attributes
+		if len(a.Files) == 0 {
+			continue
+		}
+		results = append(results, Result{
+			ID:        strconv.Itoa(a.Files[0].FileID),
+			Language:  LanguageCode(a.Language),
+			Release:   a.Release,
+			Downloads: a.DownloadCount,
+			HashMatch: a.MoviehashMatch,
+		})
+	}
+	return results, nil
+}
+
+// Download requests a link for r as SRT and saves the file into dir, named
+// after its language, in UTF-8.
+func (o *OpenSubtitles) Download(ctx context.Context, r Result, dir string) (File, error) {
+	fileID, err := strconv.Atoi(r.ID)
+	if err != nil {
+		return File{}, fmt.Errorf("invalid file ID %q", r.ID)
+	}
+	body, err := json.Marshal(map[string]any{"file_id": fileID, "sub_format": "srt"})
+	if err != nil {
+		return File{}, err
+	}
+	var link struct {
+		Link string `json:"link"`
+	}
+	if err := o.do(ctx, "POST", "/download", body, &link); err != nil {
+		return File{}, err
+	}
+	if link.Link == "" {
+		return File{}, errors.New("no download link in response")
+	}
+
+	data, err := get(ctx, o.client, nil, link.Link)
+	if err != nil {
+		return File{}, err
+	}
+	file := File{Path: filepath.Join(dir, r.Language+".srt"), Language: r.Language}
+	if err := writeFile(file.Path, ToUTF8(data)); err != nil {
+		return File{}, err
+	}
+	return file, nil
+}
+
+// do sends an API request and decodes the JSON response into v.
+func (o *OpenSubtitles) do(ctx context.Context, method, path string, body []byte, v any) error {
+	req, err := http.NewRequestWithContext(ctx, method, o.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", apiUserAgent)
+	if o.apiKey != "" {
+		req.Header.Set("Api-Key", o.apiKey)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s %s: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxFileSize))
+	if err != nil {
+		return fmt.Errorf("reading response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Message != "" {
+			return fmt.Errorf("%s %s: %s (status %d)", method, path, apiErr.Message, resp.StatusCode)
+		}
+		return fmt.Errorf("%s %s: unexpected status %d", method, path, resp.StatusCode)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("decoding response: %w", err)
+	}
+	return nil
+}
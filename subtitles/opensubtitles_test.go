@@ -0,0 +1,140 @@
+package subtitles
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestOpenSubtitlesSearch(t *testing.T) {
+	tests := []struct {
+		name  string
+		query Query
+		want  url.Values
+	}{
+		{
+			name:  "movie",
+			query: Query{IMDbID: "tt0133093", Languages: []string{"en"}},
+			want:  url.Values{"imdb_id": {"133093"}, "languages": {"en"}},
+		},
+		{
+			name:  "episode",
+			query: Query{IMDbID: "tt0903747", Season: 2, Episode: 5, Languages: []string{"en"}},
+			want: url.Values{
+				"parent_imdb_id": {"903747"},
+				"season_number":  {"2"},
+				"episode_number": {"5"},
+				"languages":      {"en"},
+			},
+		},
+		{
+			name:  "languages sorted",
+			query: Query{IMDbID: "tt0133093", Languages: []string{"pt", "de", "en"}, Hash: "8e245d9679d31e12"},
+			want:  url.Values{"imdb_id": {"133093"}, "languages": {"de,en,pt"}, "moviehash": {"8e245d9679d31e12"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got url.Values
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/api/v1/subtitles" {
+					http.NotFound(w, r)
+					return
+				}
+				if key := r.Header.Get("Api-Key"); key != "secret" {
+					t.Errorf("Api-Key = %q, want %q", key, "secret")
+				}
+				got = r.URL.Query()
+				w.Write([]byte(`{"data": [
+					{"attributes": {"language": "en", "download_count": 10, "release": "A", "files": [{"file_id": 1}]}},
+					{"attributes": {"language": "pt-BR", "download_count": 3, "moviehash_match": true, "files": [{"file_id": 2}]}},
+					{"attributes": {"language": "de", "download_count": 50, "files": []}}
+				]}`))
+			}))
+			defer srv.Close()
+
+			o := NewOpenSubtitles(srv.URL+"/api/v1/", "secret", srv.Client())
+			results, err := o.Search(context.Background(), tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Encode() != tt.want.Encode() {
+				t.Errorf("query = %s, want %s", got.Encode(), tt.want.Encode())
+			}
+			want := []Result{
+				{ID: "1", Language: "en", Release: "A", Downloads: 10},
+				{ID: "2", Language: "pt", Downloads: 3, HashMatch: true},
+			}
+			if len(results) != len(want) {
+				t.Fatalf("results = %+v, want %+v", results, want)
+			}
+			for i := range want {
+				if results[i] != want[i] {
+					t.Errorf("result %d = %+v, want %+v", i, results[i], want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestOpenSubtitlesError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotAcceptable)
+		w.Write([]byte(`{"message": "You have downloaded your allowed 20 subtitles for 24h"}`))
+	}))
+	defer srv.Close()
+
+	o := NewOpenSubtitles(srv.URL, "", srv.Client())
+	_, err := o.Download(context.Background(), Result{ID: "1", Language: "en"}, t.TempDir())
+	if err == nil || !strings.Contains(err.Error(), "allowed 20 subtitles") {
+		t.Errorf("error = %v, want the API's message", err)
+	}
+}
+
+func TestOpenSubtitlesDownload(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/download":
+			var req struct {
+				FileID    int    `json:"file_id"`
+				SubFormat string `json:"sub_format"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil || r.Method != "POST" {
+				t.Errorf("download request: %s, %v", r.Method, err)
+			}
+			if req.FileID != 42 || req.SubFormat != "srt" {
+				t.Errorf("download request = %+v, want file 42 as srt", req)
+			}
+			json.NewEncoder(w).Encode(map[string]string{"link": srv.URL + "/file/42.srt"})
+		case "/file/42.srt":
+			// CP1252, as old uploads often are.
+			w.Write([]byte("1\r\n00:00:01,000 --> 00:00:02,000\r\nCaf\xe9 cr\xe8me\r\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	o := NewOpenSubtitles(srv.URL, "", srv.Client())
+	f, err := o.Download(context.Background(), Result{ID: "42", Language: "fr"}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Language != "fr" || !strings.HasSuffix(f.Path, "fr.srt") {
+		t.Errorf("file = %+v, want fr.srt in fr", f)
+	}
+	data, err := os.ReadFile(f.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "Café crème") {
+		t.Errorf("saved %q, want the text in UTF-8", data)
+	}
+}
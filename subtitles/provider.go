@@ -0,0 +1,129 @@
+package subtitles
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"kino/internal/jsonstore"
+)
+
+// Provider searches an external subtitle service, for titles the stream
+// provider has no subtitles for.
+type Provider interface {
+	Name() string
+	Search(ctx context.Context, q Query) ([]Result, error)
+	// Download saves r into dir and returns the file.
+	Download(ctx context.Context, r Result, dir string) (File, error)
+}
+
+// Query describes the subtitles wanted for a title.
+type Query struct {
+	// IMDbID is the film's ID, or the show's for an episode.
+	IMDbID  string
+	Season  int
+	Episode int
+	// Languages are ISO 639-1 codes.
+	Languages []string
+	// Hash is the moviehash of a local copy (see Hash), which finds
+	// subtitles timed for that exact file.
+	Hash string
+}
+
+// Result is a subtitle file a Provider offers.
+type Result struct {
+	// ID identifies the file to the provider.
+	ID        string `json:"id"`
+	Language  string `json:"language"`
+	Release   string `json:"release,omitempty"`
+	Downloads int    `json:"downloads"`
+	// HashMatch is set when the file was made for the queried moviehash.
+	HashMatch bool `json:"hash_match,omitempty"`
+}
+
+// Rank orders results best first: those matching the moviehash, then the
+// most downloaded.
+func Rank(results []Result) {
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].HashMatch != results[j].HashMatch {
+			return results[i].HashMatch
+		}
+		return results[i].Downloads > results[j].Downloads
+	})
+}
+
+// Best ranks results and returns the best one in each of the languages, in
+// the order the languages are given.
+func Best(results []Result, languages []string) []Result {
+	Rank(results)
+	var best []Result
+	for _, lang := range languages {
+		for _, r := range results {
+			if r.Language == lang {
+				best = append(best, r)
+				break
+			}
+		}
+	}
+	return best
+}
+
+// searchTTL is how long search results stay cached.
+const searchTTL = 24 * time.Hour
+
+// searchCache is the file search results are cached in, in a title's
+// CacheDir.
+const searchCache = "search.json"
+
+type cachedSearch struct {
+	Time    time.Time `json:"time"`
+	Results []Result  `json:"results"`
+}
+
+// SearchCached runs q against p, reusing results cached in dir, the
+// title's CacheDir, for up to a day.
+func SearchCached(ctx context.Context, p Provider, q Query, dir string) ([]Result, error) {
+	path := filepath.Join(dir, searchCache)
+	key := p.Name() + " " + strings.Join(q.Languages, ",") + " " + q.Hash
+
+	var cache map[string]cachedSearch
+	if err := jsonstore.View(path, &cache); err != nil {
+		return nil, err
+	}
+	if c, ok := cache[key]; ok && time.Since(c.Time) < searchTTL {
+		return c.Results, nil
+	}
+
+	results, err := p.Search(ctx, q)
+	if err != nil {
+		return nil, fmt.Errorf("searching %s: %w", p.Name(), err)
+	}
+	err = jsonstore.Update(path, &cache, func() error {
+		if cache == nil {
+			cache = make(map[string]cachedSearch)
+		}
+		cache[key] = cachedSearch{Time: time.Now(), Results: results}
+		return nil
+	})
+	return results, err
+}
+
+// Cached returns a file saved in dir earlier for lang, by Fetch or a
+// Provider.
+func Cached(dir, lang string) (File, bool) {
+	for _, ext := range []string{".srt", ".vtt"} {
+		path := filepath.Join(dir, lang+ext)
+		if _, err := os.Stat(path); err == nil {
+			return File{Path: path, Language: lang}, true
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return File{}, false
+		}
+	}
+	return File{}, false
+}
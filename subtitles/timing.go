@@ -0,0 +1,35 @@
+package subtitles
+
+import "time"
+
+// Shift moves cues by d, earlier if it is negative. Cues that would start
+// before zero are clipped, and dropped if they would end before it too.
+func Shift(cues []Cue, d time.Duration) []Cue {
+	shifted := make([]Cue, 0, len(cues))
+	for _, c := range cues {
+		c.Start += d
+		c.End += d
+		if c.End <= 0 {
+			continue
+		}
+		c.Start = max(c.Start, 0)
+		shifted = append(shifted, c)
+	}
+	return shifted
+}
+
+// Retime converts cues timed for a video at from frames per second to the
+// same video at to frames per second, such as a 23.976 fps release and its
+// 25 fps PAL speed-up, which drift apart as they play.
+func Retime(cues []Cue, from, to float64) []Cue {
+	scale := func(d time.Duration) time.Duration {
+		return time.Duration(float64(d) * from / to)
+	}
+	retimed := make([]Cue, len(cues))
+	for i, c := range cues {
+		c.Start = scale(c.Start)
+		c.End = scale(c.End)
+		retimed[i] = c
+	}
+	return retimed
+}
@@ -0,0 +1,85 @@
+package subtitles
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestShift(t *testing.T) {
+	cues := []Cue{
+		{Start: ms(500), End: ms(1500), Text: "dropped"},
+		{Start: ms(1500), End: ms(3000), Text: "clipped"},
+		{Start: ms(5000), End: ms(6000), Text: "moved"},
+	}
+	tests := []struct {
+		name string
+		by   time.Duration
+		want []Cue
+	}{
+		{"later", ms(1000), []Cue{
+			{Start: ms(1500), End: ms(2500), Text: "dropped"},
+			{Start: ms(2500), End: ms(4000), Text: "clipped"},
+			{Start: ms(6000), End: ms(7000), Text: "moved"},
+		}},
+		{"earlier", -ms(2000), []Cue{
+			{Start: 0, End: ms(1000), Text: "clipped"},
+			{Start: ms(3000), End: ms(4000), Text: "moved"},
+		}},
+		{"ending at zero", -ms(1500), []Cue{
+			{Start: 0, End: ms(1500), Text: "clipped"},
+			{Start: ms(3500), End: ms(4500), Text: "moved"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Shift(cues, tt.by); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Shift(%v) = %+v, want %+v", tt.by, got, tt.want)
+			}
+		})
+	}
+	if cues[0].Start != ms(500) {
+		t.Error("Shift modified its input")
+	}
+}
+
+func TestRetime(t *testing.T) {
+	secs := func(s float64) time.Duration { return time.Duration(s * float64(time.Second)) }
+	cues := []Cue{
+		{Start: 0, End: secs(2)},
+		{Start: secs(3600), End: secs(3602.5)},
+	}
+	tests := []struct {
+		name     string
+		from, to float64
+		want     []Cue
+	}{
+		// A 23.976 fps release sped up to 25 fps is 4% shorter, so its
+		// cues come sooner.
+		{"23.976 to 25", 23.976, 25, []Cue{
+			{Start: 0, End: secs(1.91808)},
+			{Start: secs(3452.544), End: secs(3454.9416)},
+		}},
+		{"25 to 23.976", 25, 23.976, []Cue{
+			{Start: 0, End: secs(2.085418752)},
+			{Start: secs(3753.753753754), End: secs(3756.360527194)},
+		}},
+		{"unchanged", 25, 25, cues},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Retime(cues, tt.from, tt.to)
+			if len(got) != len(tt.want) {
+				t.Fatalf("Retime = %+v, want %+v", got, tt.want)
+			}
+			for i := range got {
+				if d := (got[i].Start - tt.want[i].Start).Abs(); d > time.Microsecond {
+					t.Errorf("cue %d start = %v, want %v", i, got[i].Start, tt.want[i].Start)
+				}
+				if d := (got[i].End - tt.want[i].End).Abs(); d > time.Microsecond {
+					t.Errorf("cue %d end = %v, want %v", i, got[i].End, tt.want[i].End)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package subtitles
+
+import (
+	"path"
+	"strings"
+)
+
+// Track is a subtitle track offered for a title.
+type Track struct {
+	// Label is the name the source gives the track, e.g. "English".
+	Label string
+	// Language is the ISO 639-1 code, e.g. "en", or empty if unknown.
+	Language string
+	URL      string
+	// Playlist is set for HLS subtitle renditions, whose URL is a media
+	// playlist of WebVTT segments rather than a subtitle file.
+	Playlist bool
+}
+
+// Ext returns the file extension the track is saved with, ".vtt" or
+// ".srt".
+func (t Track) Ext() string {
+	if !t.Playlist && strings.EqualFold(path.Ext(urlPath(t.URL)), ".srt") {
+		return ".srt"
+	}
+	return ".vtt"
+}
+
+func urlPath(u string) string {
+	u, _, _ = strings.Cut(u, "?")
+	u, _, _ = strings.Cut(u, "#")
+	return u
+}
+
+// language is one entry of the table used to recognise track labels.
+type language struct {
+	name  string
+	code  string // ISO 639-1
+	code3 string // ISO 639-2/B, as MP4 and Matroska metadata expect
+}
+
+var languages = []language{
+	{"English", "en", "eng"},
+	{"Spanish", "es", "spa"},
+	{"French", "fr", "fre"},
+	{"German", "de", "ger"},
+	{"Italian", "it", "ita"},
+	{"Portuguese", "pt", "por"},
+	{"Dutch", "nl", "dut"},
+	{"Russian", "ru", "rus"},
+	{"Polish", "pl", "pol"},
+	{"Turkish", "tr", "tur"},
+	{"Arabic", "ar", "ara"},
+	{"Hebrew", "he", "heb"},
+	{"Greek", "el", "gre"},
+	{"Swedish", "sv", "swe"},
+	{"Norwegian", "no", "nor"},
+	{"Danish", "da", "dan"},
+	{"Finnish", "fi", "fin"},
+	{"Czech", "cs", "cze"},
+	{"Slovak", "sk", "slo"},
+	{"Hungarian", "hu", "hun"},
+	{"Romanian", "ro", "rum"},
+	{"Bulgarian", "bg", "bul"},
+	{"Croatian", "hr", "hrv"},
+	{"Serbian", "sr", "srp"},
+	{"Slovenian", "sl", "slv"},
+	{"Ukrainian", "uk", "ukr"},
+	{"Chinese", "zh", "chi"},
+	{"Japanese", "ja", "jpn"},
+	{"Korean", "ko", "kor"},
+	{"Vietnamese", "vi", "vie"},
+	{"Thai", "th", "tha"},
+	{"Indonesian", "id", "ind"},
+	{"Malay", "ms", "may"},
+	{"Hindi", "hi", "hin"},
+	{"Persian", "fa", "per"},
+}
+
+// LanguageCode returns the ISO 639-1 code for a language given by name
+// ("English", "Portuguese (BR)"), two-letter or three-letter code, or ""
+// if it is not recognised.
+func LanguageCode(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if name, _, ok := strings.Cut(s, "("); ok {
+		s = strings.TrimSpace(name)
+	}
+	if code, _, ok := strings.Cut(s, "-"); ok && len(code) == 2 {
+		s = code // "pt-BR"
+	}
+	for _, l := range languages {
+		if s == strings.ToLower(l.name) || s == l.code || s == l.code3 {
+			return l.code
+		}
+	}
+	return ""
+}
+
+// LanguageCode3 returns the ISO 639-2 code for an ISO 639-1 code, or "und".
+func LanguageCode3(code string) string {
+	for _, l := range languages {
+		if l.code == code {
+			return l.code3
+		}
+	}
+	return "und"
+}
+
+// Select returns the first track in each of the preferred languages, in
+// the order the languages are given.
+func Select(tracks []Track, preferred []string) []Track {
+	var chosen []Track
+	seen := make(map[string]bool)
+	for _, want := range preferred {
+		code := LanguageCode(want)
+		if code == "" || seen[code] {
+			continue
+		}
+		seen[code] = true
+		for _, t := range tracks {
+			if t.Language == code {
+				chosen = append(chosen, t)
+				break
+			}
+		}
+	}
+	return chosen
+}
@@ -4,12 +4,20 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	"kino/extractor"
 )
 
 // FormatVariantDisplay formats a stream variant for display in the UI.
 func FormatVariantDisplay(v extractor.StreamVariant) string {
+	if v.Resolution == extractor.AutoResolution {
+		if v.Bandwidth != "" {
+			return fmt.Sprintf("Auto (adaptive, up to %s)", FormatBandwidth(v.Bandwidth))
+		}
+		return "Auto (adaptive)"
+	}
+
 	resolution := FormatResolution(v.Resolution)
 	bandwidth := FormatBandwidth(v.Bandwidth)
 
@@ -21,28 +29,15 @@ func FormatVariantDisplay(v extractor.StreamVariant) string {
 
 // FormatResolution converts raw resolution strings (e.g., "1920x1080") into friendly names (e.g., "1080p").
 func FormatResolution(resolution string) string {
-	if !strings.Contains(resolution, "x") {
-		return resolution
-	}
-
-	parts := strings.Split(resolution, "x")
-	if len(parts) != 2 {
+	_, height, ok := strings.Cut(resolution, "x")
+	if !ok {
 		return resolution
 	}
 
-	height := parts[1]
-	switch height {
-	case "1080":
-		return "1080p"
-	case "720":
-		return "720p"
-	case "480":
-		return "480p"
-	case "360":
-		return "360p"
-	default:
+	if h, err := strconv.Atoi(height); err != nil || h <= 0 {
 		return resolution
 	}
+	return height + "p"
 }
 
 // FormatBandwidth converts raw bandwidth in bps to human-readable Mbps or Gbps.
@@ -62,3 +57,23 @@ func FormatBandwidth(bandwidth string) string {
 	}
 	return bandwidth + " bps"
 }
+
+// FormatClock formats a duration as HH:MM:SS.
+func FormatClock(d time.Duration) string {
+	s := int(d.Seconds())
+	return fmt.Sprintf("%02d:%02d:%02d", s/3600, s/60%60, s%60)
+}
+
+// FormatBytes formats a byte count with binary units (e.g., "1.5 MiB").
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,25 @@
+package ui
+
+import "testing"
+
+func TestFormatResolution(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"3840x2160", "2160p"},
+		{"2560x1440", "1440p"},
+		{"1920x1080", "1080p"},
+		{"1280x720", "720p"},
+		{"960x540", "540p"},
+		{"640x360", "360p"},
+		{"Auto", "Auto"},
+		{"720p", "720p"},
+		{"1280x", "1280x"},
+		{"axb", "axb"},
+	}
+	for _, tt := range tests {
+		if got := FormatResolution(tt.in); got != tt.want {
+			t.Errorf("FormatResolution(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package ui
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Stdin is the buffered terminal input every prompt reads lines from. Sharing
+// it keeps a line typed ahead from being lost in a reader that is dropped.
+var Stdin = bufio.NewReader(os.Stdin)
+
+// Confirm asks a yes/no question on the terminal. An empty answer returns def.
+func Confirm(question string, def bool) bool {
+	hint := "[y/N]"
+	if def {
+		hint = "[Y/n]"
+	}
+	fmt.Printf("%s %s ", question, hint)
+
+	answer, err := Stdin.ReadString('\n')
+	if err != nil {
+		return def
+	}
+
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	case "n", "no":
+		return false
+	default:
+		return def
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"strings"
 
 	"kino/extractor"
+	"kino/history"
+	"kino/library"
 
 	"github.com/StalkR/imdb"
 	fuzzyfinder "github.com/ktr0731/go-fuzzyfinder"
@@ -90,6 +92,30 @@ func SelectEpisode(episodes []int) (int, error) {
 	return episodes[idx], nil
 }
 
+// SelectEpisodeAction asks whether to play only the selected episode or the
+// rest of the season from it. It returns true for the season.
+func SelectEpisodeAction() (bool, error) {
+	items := []string{"Play episode", "Play season from here", "← Go Back"}
+
+	idx, err := fuzzyfinder.Find(
+		items,
+		func(i int) string {
+			return items[i]
+		},
+		fuzzyfinder.WithPromptString("Select an action:"),
+	)
+
+	if err != nil {
+		return false, err
+	}
+
+	if idx == len(items)-1 {
+		return false, fmt.Errorf("abort")
+	}
+
+	return idx == 1, nil
+}
+
 // SelectStreamVariant prompts the user to select a stream quality variant.
 func SelectStreamVariant(variants []extractor.StreamVariant) (*extractor.StreamVariant, error) {
 	idx, err := fuzzyfinder.Find(
@@ -106,3 +132,70 @@ func SelectStreamVariant(variants []extractor.StreamVariant) (*extractor.StreamV
 
 	return &variants[idx], nil
 }
+
+// SelectContinue prompts the user to pick an in-progress or next-up item.
+// Entries without an ID are next-up episodes that have not been played yet.
+func SelectContinue(entries []history.Entry) (*history.Entry, error) {
+	idx, err := fuzzyfinder.Find(
+		entries,
+		func(i int) string {
+			e := entries[i]
+			if e.ID == 0 {
+				return fmt.Sprintf("Next up: %s", e.DisplayTitle())
+			}
+			if e.Duration > 0 {
+				return fmt.Sprintf("Resume: %s (%s / %s)", e.DisplayTitle(), FormatClock(e.Position), FormatClock(e.Duration))
+			}
+			return fmt.Sprintf("Resume: %s", e.DisplayTitle())
+		},
+		fuzzyfinder.WithPromptString("Continue watching:"),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &entries[idx], nil
+}
+
+// SelectMainMenu prompts the user to continue watching or start a new search.
+// It returns true if continue was chosen.
+func SelectMainMenu() (bool, error) {
+	items := []string{"Continue watching", "Search"}
+
+	idx, err := fuzzyfinder.Find(
+		items,
+		func(i int) string {
+			return items[i]
+		},
+		fuzzyfinder.WithPromptString("kino:"),
+	)
+
+	if err != nil {
+		return false, err
+	}
+
+	return idx == 0, nil
+}
+
+// SelectLibraryTitle prompts the user to select a downloaded movie or show.
+func SelectLibraryTitle(items []library.Item) (*library.Item, error) {
+	idx, err := fuzzyfinder.Find(
+		items,
+		func(i int) string {
+			item := items[i]
+			mediaType := "Film"
+			if item.Season > 0 {
+				mediaType = "TV"
+			}
+			return fmt.Sprintf("%s [%s]", item.Title, mediaType)
+		},
+		fuzzyfinder.WithPromptString("Select a download:"),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &items[idx], nil
+}